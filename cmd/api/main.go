@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"gorm.io/driver/sqlite"
@@ -17,6 +23,9 @@ import (
 
 func main() {
 	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
 	// Initialize database and storer
 	db := openDatabase("app.db")
@@ -26,14 +35,26 @@ func main() {
 	svc := services.NewServicesFromConfig(cfg)
 
 	// Initialize handlers
-	h := handlers.NewHandlers(svc, appStorer, cfg.WebhookURL, cfg.StripeWebhookSecret)
+	h := handlers.NewHandlers(svc, appStorer, cfg.WebhookURL, cfg.StripeEndpoints, cfg.StarsPrices, cfg.CustomPrice, cfg.TronWebhook)
+
+	// CLI subcommands
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		runReconcile(h, os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "sweep" {
+		runSweep(cfg, svc, h, os.Args[2:])
+		return
+	}
 
 	// Parse payment templates
 	successTpl := template.Must(template.ParseFiles("templates/success.html"))
 	canceledTpl := template.Must(template.ParseFiles("templates/canceled.html"))
 
 	// Routes
-	http.HandleFunc("/webhook/stripe", h.Webhook.HandleStripeWebhook)
+	for _, stripeWebhook := range h.StripeWebhooks {
+		http.HandleFunc(stripeWebhook.Path(), stripeWebhook.HandleStripeWebhook)
+	}
 	http.HandleFunc("/webhook/tron", h.TronWebhook.HandleTronWebhook)
 	http.HandleFunc("/payment-success", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -47,10 +68,19 @@ func main() {
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintf(w, "OK")
 	})
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		svc.Tron.Metrics().WritePrometheus(w)
+	})
 
 	// Start Tron payment checker in a goroutine
 	go h.TronWebhook.CheckPendingPayments()
 
+	// Repair missed Stripe webhooks; Stripe stops retrying after 3 days
+	for _, stripeWebhook := range h.StripeWebhooks {
+		go stripeWebhook.RunStripeReconciliation(time.Hour, 72*time.Hour)
+	}
+
 	// Start Telegram bot in a goroutine
 	go func() {
 		u := tgbotapi.NewUpdate(0)
@@ -65,6 +95,79 @@ func main() {
 	log.Fatal(http.ListenAndServe(":"+cfg.Port, nil))
 }
 
+// runReconcile reconciles Stripe Checkout Sessions on demand:
+//
+//	go run ./cmd/api reconcile -from 2025-12-01 -to 2025-12-31
+func runReconcile(h *handlers.Handlers, args []string) {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	fromFlag := fs.String("from", "", "start date YYYY-MM-DD (default: 24 hours ago)")
+	toFlag := fs.String("to", "", "end date YYYY-MM-DD, inclusive (default: now)")
+	fs.Parse(args)
+
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+	if *fromFlag != "" {
+		parsed, err := time.Parse("2006-01-02", *fromFlag)
+		if err != nil {
+			log.Fatalf("Invalid -from date: %v", err)
+		}
+		from = parsed
+	}
+	if *toFlag != "" {
+		parsed, err := time.Parse("2006-01-02", *toFlag)
+		if err != nil {
+			log.Fatalf("Invalid -to date: %v", err)
+		}
+		to = parsed.Add(24 * time.Hour)
+	}
+
+	for _, stripeWebhook := range h.StripeWebhooks {
+		report, err := stripeWebhook.ReconcileStripe(from, to)
+		if err != nil {
+			log.Fatalf("Reconciliation failed for %s: %v", stripeWebhook.Path(), err)
+		}
+		fmt.Printf("Reconciled %s %s .. %s: %s\n", stripeWebhook.Path(), from.Format(time.RFC3339), to.Format(time.RFC3339), report)
+	}
+}
+
+// runSweep moves confirmed Tron deposits to TRON_COLD_ADDRESS. Keys are only read here,
+// so the sweep can run on a separate machine from the bot:
+//
+//	go run ./cmd/api sweep -dry-run
+func runSweep(cfg *config.Config, svc *services.Services, h *handlers.Handlers, args []string) {
+	fs := flag.NewFlagSet("sweep", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "print the planned transactions and fees without signing anything")
+	fs.Parse(args)
+
+	var signer services.TronSigner
+	if cfg.Tron.SweepXPrv != "" {
+		local, err := services.NewLocalTronSigner(cfg.Tron.SweepXPrv, cfg.Tron.FeeKey)
+		if err != nil {
+			log.Fatalf("Failed to load sweep keys: %v", err)
+		}
+		signer = local
+	} else if !*dryRun {
+		log.Fatalf("TRON_SWEEP_XPRV is required to sweep, use -dry-run to only plan")
+	}
+
+	// Ctrl-C cancels TronGrid requests and waits instead of leaving them to time out
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sweeper, err := services.NewTronSweeper(svc.Tron.WithContext(ctx), signer, cfg.Tron.ColdAddress)
+	if err != nil {
+		log.Fatalf("Failed to initialize sweeper: %v", err)
+	}
+
+	plan, err := h.TronWebhook.SweepDeposits(sweeper, *dryRun)
+	if plan != nil {
+		fmt.Print(plan)
+	}
+	if err != nil {
+		log.Fatalf("Sweep failed: %v", err)
+	}
+}
+
 func openDatabase(dbPath string) *gorm.DB{
 	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
 	if err != nil {
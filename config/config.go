@@ -1,37 +1,327 @@
 package config
 
 import (
+	"fmt"
+	"log"
+	"math"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// StripeEndpoint is one Stripe account with its own webhook route
+type StripeEndpoint struct {
+	Name           string
+	SecretKey      string
+	WebhookSecrets []string // all active signing secrets, several while rotating
+	WebhookPath    string
+}
+
+// CustomPriceConfig limits "Name your price" checkouts, amounts in USD cents
+type CustomPriceConfig struct {
+	Min            int64
+	Max            int64
+	BonusThreshold int64 // tips of at least this much get a bonus photo, 0 disables
+}
+
+// SellerInfo is printed on PDF receipts
+type SellerInfo struct {
+	Name    string
+	Address string
+	Email   string
+	TaxID   string
+}
+
+// TronConfig selects the Tron network and the asset buyers pay with
+type TronConfig struct {
+	Network       string // mainnet, shasta, nile or custom
+	NetworkName   string // shown to buyers, e.g. "Tron (Shasta Testnet)"
+	RPCURL        string
+	Asset         string        // TRX or USDT (TRC-20)
+	TokenContract string        // TRC-20 contract, required when Asset is USDT
+	TokenDecimals int           // decimals of the TRC-20 contract, 6 for USDT
+	Confirmations int64         // blocks required before a payment counts as confirmed
+	RateLimit     float64       // TronGrid requests per second, sized to the API key tier
+	LateGrace     time.Duration // payments this long after expiry are still accepted
+	LateWindow    time.Duration // payments later than the grace but within this go to admin review
+	XPub          string        // account-level extended public key m/44'/195'/0', enables a deposit address per order
+	ColdAddress   string        // sweep destination
+	SweepXPrv     string        // account-level extended private key, only needed by the sweep command
+	FeeKey        string        // hex private key of the wallet that tops up TRX for sweep fees
+}
+
+// TronWebhookConfig authenticates /webhook/tron and picks the notification format
+type TronWebhookConfig struct {
+	Secrets   []string      // HMAC keys, several while rotating; the webhook is disabled without one
+	Provider  string        // payload format: generic, tatum or trongrid-event
+	Tolerance time.Duration // maximum age of a signed request
+}
+
+// tronWebhookProviders are the payload formats handlers can normalize
+var tronWebhookProviders = []string{"generic", "tatum", "trongrid-event"}
+
+// RatesConfig selects where USD exchange rates for crypto quotes come from
+type RatesConfig struct {
+	Source   string             // coingecko, binance or static
+	CacheTTL time.Duration      // how long a fetched rate is reused
+	Static   map[string]float64 // USD per unit, used when the source is static or unavailable
+}
+
+// tronNetworks are the known public networks; USDT is the default token contract where one exists
+var tronNetworks = map[string]struct {
+	Name string
+	URL  string
+	USDT string
+}{
+	"mainnet": {Name: "Tron (Mainnet)", URL: "https://api.trongrid.io", USDT: "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t"},
+	"shasta":  {Name: "Tron (Shasta Testnet)", URL: "https://api.shasta.trongrid.io"},
+	"nile":    {Name: "Tron (Nile Testnet)", URL: "https://nile.trongrid.io", USDT: "TXYZopYRdj2D9XRtbG411XZZ3kM5VkAeBf"},
+}
+
 type Config struct {
-	StripeKey           string
-	StripeSecret        string
-	StripeWebhookSecret string
-	TelegramKey         string
-	WebhookURL          string
-	Port                string
-	CoinbaseAPIKey      string
-	TronAPIKey          string
-	TronMainAddress     string
+	StripeKey             string
+	StripeSecret          string
+	StripeEndpoints       []StripeEndpoint // the first one is the default account used for checkout
+	StripeAPIURL          string           // optional, e.g. a local stripe-mock
+	TelegramKey           string
+	TelegramProviderToken string
+	StarsPrices           map[string]int64 // price in Telegram Stars per product
+	CustomPrice           CustomPriceConfig
+	Seller                SellerInfo
+	WebhookURL            string
+	Port                  string
+	CoinbaseAPIKey        string
+	TronAPIKey            string
+	TronMainAddress       string
+	Tron                  TronConfig
+	TronWebhook           TronWebhookConfig
+	Rates                 RatesConfig
 }
 
 func Load() *Config {
 	godotenv.Load()
 
+	stripeSecret := getEnv("STRIPE_SECRET_KEY", "")
+
 	return &Config{
-		StripeKey:           getEnv("STRIPE_PUBLISHABLE_KEY", ""),
-		StripeSecret:        getEnv("STRIPE_SECRET_KEY", ""),
-		StripeWebhookSecret: getEnv("STRIPE_WEBHOOK_SECRET", ""),
-		TelegramKey:         getEnv("TELEGRAM_BOT_TOKEN", ""),
-		WebhookURL:          getEnv("WEBHOOK_URL", "http://localhost:8080"),
-		Port:                getEnv("PORT", "8080"),
-		CoinbaseAPIKey:      getEnv("COINBASE_API_KEY", ""),
-		TronAPIKey:          getEnv("TRON_API_KEY", ""),
-		TronMainAddress:     getEnv("TRON_MAIN_ADDRESS", ""),
+		StripeKey:             getEnv("STRIPE_PUBLISHABLE_KEY", ""),
+		StripeSecret:          stripeSecret,
+		StripeEndpoints:       loadStripeEndpoints(stripeSecret),
+		StripeAPIURL:          getEnv("STRIPE_API_URL", ""),
+		TelegramKey:           getEnv("TELEGRAM_BOT_TOKEN", ""),
+		TelegramProviderToken: getEnv("TELEGRAM_PROVIDER_TOKEN", ""),
+		StarsPrices:           getEnvPrices("STARS_PRICES", "image_pack=50"),
+		CustomPrice: CustomPriceConfig{
+			Min:            getEnvCents("CUSTOM_PRICE_MIN", "9.99"),
+			Max:            getEnvCents("CUSTOM_PRICE_MAX", "500"),
+			BonusThreshold: getEnvCents("TIP_BONUS_THRESHOLD", "10"),
+		},
+		Seller: SellerInfo{
+			Name:    getEnv("SELLER_NAME", "GoBotCat"),
+			Address: getEnv("SELLER_ADDRESS", ""),
+			Email:   getEnv("SELLER_EMAIL", ""),
+			TaxID:   getEnv("SELLER_TAX_ID", ""),
+		},
+		WebhookURL:      getEnv("WEBHOOK_URL", "http://localhost:8080"),
+		Port:            getEnv("PORT", "8080"),
+		CoinbaseAPIKey:  getEnv("COINBASE_API_KEY", ""),
+		TronAPIKey:      getEnv("TRON_API_KEY", ""),
+		TronMainAddress: getEnv("TRON_MAIN_ADDRESS", ""),
+		Tron:            loadTronConfig(),
+		TronWebhook:     loadTronWebhookConfig(),
+		Rates:           loadRatesConfig(),
+	}
+}
+
+func loadTronConfig() TronConfig {
+	tron := TronConfig{
+		Network:       strings.ToLower(getEnv("TRON_NETWORK", "shasta")),
+		RPCURL:        strings.TrimSuffix(getEnv("TRON_RPC_URL", ""), "/"),
+		Asset:         strings.ToUpper(getEnv("TRON_ASSET", "TRX")),
+		TokenContract: getEnv("TRON_TOKEN_CONTRACT", ""),
+		XPub:          getEnv("TRON_XPUB", ""),
+		ColdAddress:   getEnv("TRON_COLD_ADDRESS", ""),
+		SweepXPrv:     getEnv("TRON_SWEEP_XPRV", ""),
+		FeeKey:        getEnv("TRON_FEE_PRIVATE_KEY", ""),
 	}
+
+	confirmations, err := strconv.ParseInt(getEnv("TRON_CONFIRMATIONS", "19"), 10, 64)
+	if err != nil {
+		confirmations = 0 // rejected by Validate
+	}
+	tron.Confirmations = confirmations
+
+	tron.TokenDecimals, err = strconv.Atoi(getEnv("TRON_TOKEN_DECIMALS", "6"))
+	if err != nil {
+		tron.TokenDecimals = -1 // rejected by Validate
+	}
+
+	// TronGrid allows far fewer requests without an API key
+	defaultRate := "3"
+	if getEnv("TRON_API_KEY", "") != "" {
+		defaultRate = "15"
+	}
+	tron.RateLimit, err = strconv.ParseFloat(getEnv("TRON_RATE_LIMIT", defaultRate), 64)
+	if err != nil {
+		tron.RateLimit = 0 // rejected by Validate
+	}
+
+	tron.LateGrace, err = time.ParseDuration(getEnv("TRON_LATE_GRACE", "1h"))
+	if err != nil {
+		tron.LateGrace = -1 // rejected by Validate
+	}
+	tron.LateWindow, err = time.ParseDuration(getEnv("TRON_LATE_WINDOW", "72h"))
+	if err != nil {
+		tron.LateWindow = -1
+	}
+
+	if known, ok := tronNetworks[tron.Network]; ok {
+		tron.NetworkName = known.Name
+		if tron.RPCURL == "" {
+			tron.RPCURL = known.URL
+		}
+		if tron.TokenContract == "" && tron.Asset == "USDT" {
+			tron.TokenContract = known.USDT
+		}
+	} else {
+		tron.NetworkName = "Tron (" + tron.Network + ")"
+	}
+
+	return tron
+}
+
+func loadTronWebhookConfig() TronWebhookConfig {
+	tolerance, err := time.ParseDuration(getEnv("TRON_WEBHOOK_TOLERANCE", "5m"))
+	if err != nil {
+		tolerance = 0 // rejected by Validate
+	}
+
+	return TronWebhookConfig{
+		Secrets:   getEnvList("TRON_WEBHOOK_SECRET"),
+		Provider:  strings.ToLower(getEnv("TRON_WEBHOOK_PROVIDER", "generic")),
+		Tolerance: tolerance,
+	}
+}
+
+func loadRatesConfig() RatesConfig {
+	ttl, err := time.ParseDuration(getEnv("RATES_CACHE_TTL", "5m"))
+	if err != nil {
+		ttl = 0 // rejected by Validate
+	}
+
+	return RatesConfig{
+		Source:   strings.ToLower(getEnv("RATES_SOURCE", "coingecko")),
+		CacheTTL: ttl,
+		Static:   getEnvRates("STATIC_RATES", "USDT=1"),
+	}
+}
+
+// Validate checks settings the bot cannot run with, so they fail at startup
+func (c *Config) Validate() error {
+	tron := c.Tron
+	if _, ok := tronNetworks[tron.Network]; !ok && tron.Network != "custom" {
+		return fmt.Errorf("TRON_NETWORK must be mainnet, shasta, nile or custom, got %q", tron.Network)
+	}
+	if tron.RPCURL == "" {
+		return fmt.Errorf("TRON_RPC_URL is required when TRON_NETWORK is custom")
+	}
+	if u, err := url.Parse(tron.RPCURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("TRON_RPC_URL %q is not a valid http(s) URL", tron.RPCURL)
+	}
+	switch tron.Asset {
+	case "TRX":
+	case "USDT":
+		if tron.TokenContract == "" {
+			return fmt.Errorf("TRON_TOKEN_CONTRACT is required for USDT on %s", tron.Network)
+		}
+	default:
+		return fmt.Errorf("TRON_ASSET must be TRX or USDT, got %q", tron.Asset)
+	}
+	if tron.TokenDecimals < 0 || tron.TokenDecimals > 77 {
+		return fmt.Errorf("TRON_TOKEN_DECIMALS must be between 0 and 77")
+	}
+	if tron.Confirmations < 1 {
+		return fmt.Errorf("TRON_CONFIRMATIONS must be a positive integer")
+	}
+	if tron.RateLimit <= 0 {
+		return fmt.Errorf("TRON_RATE_LIMIT must be a positive number of requests per second")
+	}
+	if tron.LateGrace < 0 || tron.LateWindow < tron.LateGrace {
+		return fmt.Errorf("TRON_LATE_GRACE and TRON_LATE_WINDOW must be durations with TRON_LATE_WINDOW >= TRON_LATE_GRACE")
+	}
+
+	if !slices.Contains(tronWebhookProviders, c.TronWebhook.Provider) {
+		return fmt.Errorf("TRON_WEBHOOK_PROVIDER must be one of %s, got %q", strings.Join(tronWebhookProviders, ", "), c.TronWebhook.Provider)
+	}
+	if c.TronWebhook.Tolerance <= 0 {
+		return fmt.Errorf("TRON_WEBHOOK_TOLERANCE must be a positive duration such as 5m")
+	}
+
+	rates := c.Rates
+	switch rates.Source {
+	case "coingecko", "binance":
+		// STATIC_RATES is optional here: without a rate for the asset, quotes fail once the source has been down past the stale TTL
+	case "static":
+		if _, ok := rates.Static[tron.Asset]; !ok {
+			return fmt.Errorf("STATIC_RATES has no rate for %s", tron.Asset)
+		}
+	default:
+		return fmt.Errorf("RATES_SOURCE must be coingecko, binance or static, got %q", rates.Source)
+	}
+	if rates.CacheTTL <= 0 {
+		return fmt.Errorf("RATES_CACHE_TTL must be a positive duration such as 5m")
+	}
+	return nil
+}
+
+// loadStripeEndpoints reads the default Stripe endpoint and any extra accounts
+// listed in STRIPE_ACCOUNTS, each configured with STRIPE_<NAME>_* variables
+func loadStripeEndpoints(defaultSecret string) []StripeEndpoint {
+	endpoints := []StripeEndpoint{{
+		Name:           "default",
+		SecretKey:      defaultSecret,
+		WebhookSecrets: getEnvList("STRIPE_WEBHOOK_SECRET"),
+		WebhookPath:    "/webhook/stripe",
+	}}
+
+	for _, name := range getEnvList("STRIPE_ACCOUNTS") {
+		prefix := "STRIPE_" + strings.ToUpper(name) + "_"
+		endpoints = append(endpoints, StripeEndpoint{
+			Name:           name,
+			SecretKey:      getEnv(prefix+"SECRET_KEY", defaultSecret),
+			WebhookSecrets: getEnvList(prefix + "WEBHOOK_SECRET"),
+			WebhookPath:    getEnv(prefix+"WEBHOOK_PATH", "/webhook/stripe/"+strings.ToLower(name)),
+		})
+	}
+
+	return endpoints
+}
+
+// getEnvCents parses a dollar amount such as "9.99" into cents
+func getEnvCents(key, fallback string) int64 {
+	value := getEnv(key, fallback)
+	dollars, err := strconv.ParseFloat(value, 64)
+	if err != nil || dollars < 0 {
+		log.Printf("Invalid amount %q in %s, using %s", value, key, fallback)
+		dollars, _ = strconv.ParseFloat(fallback, 64)
+	}
+	return int64(math.Round(dollars * 100))
+}
+
+// getEnvList parses a comma-separated list, skipping empty entries
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnv(key, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
 }
 
 func getEnv(key, fallback string) string {
@@ -40,3 +330,39 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// getEnvPrices parses a "product=price,product=price" list
+func getEnvPrices(key, fallback string) map[string]int64 {
+	prices := make(map[string]int64)
+	for _, entry := range strings.Split(getEnv(key, fallback), ",") {
+		product, price, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseInt(strings.TrimSpace(price), 10, 64)
+		if err != nil || value <= 0 {
+			log.Printf("Ignoring invalid price %q in %s", entry, key)
+			continue
+		}
+		prices[strings.TrimSpace(product)] = value
+	}
+	return prices
+}
+
+// getEnvRates parses an "ASSET=rate,ASSET=rate" list of USD rates
+func getEnvRates(key, fallback string) map[string]float64 {
+	rates := make(map[string]float64)
+	for _, entry := range strings.Split(getEnv(key, fallback), ",") {
+		asset, rate, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+		if err != nil || value <= 0 {
+			log.Printf("Ignoring invalid rate %q in %s", entry, key)
+			continue
+		}
+		rates[strings.ToUpper(strings.TrimSpace(asset))] = value
+	}
+	return rates
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+
+	"gobotcat/services"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// customPriceLimits returns the "Name your price" range and bonus threshold in the buyer's currency
+func (h *BotHandler) customPriceLimits(currency string) (minAmount, maxAmount, bonusThreshold int64) {
+	return fromUSD(h.customPrice.Min, currency), fromUSD(h.customPrice.Max, currency), fromUSD(h.customPrice.BonusThreshold, currency)
+}
+
+// handleCustomPrice asks the buyer to type how much they want to pay
+func (h *BotHandler) handleCustomPrice(chatID int64, from *tgbotapi.User) {
+	currency := h.userCurrency(from)
+	minAmount, maxAmount, bonusThreshold := h.customPriceLimits(currency)
+
+	message := fmt.Sprintf("💝 How much would you like to pay? Send an amount between %s and %s.",
+		formatMoney(minAmount, currency), formatMoney(maxAmount, currency))
+	if h.customPrice.BonusThreshold > 0 {
+		basePrice, _ := packPrice(currency, 1)
+		message += fmt.Sprintf("\n\nTip %s or more above %s and we'll send a bonus photo!",
+			formatMoney(bonusThreshold, currency), formatMoney(basePrice, currency))
+	}
+
+	h.awaitingPrice[chatID] = true
+	h.services.Telegram.SendMessage(chatID, message)
+}
+
+// handleCustomAmount validates the typed amount and creates a Stripe checkout for it
+func (h *BotHandler) handleCustomAmount(chatID int64, from *tgbotapi.User, text string) {
+	currency := h.userCurrency(from)
+	minAmount, maxAmount, bonusThreshold := h.customPriceLimits(currency)
+
+	amount, err := parseMoney(text)
+	if err != nil || amount < minAmount || amount > maxAmount {
+		h.services.Telegram.SendMessage(chatID, fmt.Sprintf("❌ Please send an amount between %s and %s, e.g. %s",
+			formatMoney(minAmount, currency), formatMoney(maxAmount, currency), strconv.FormatFloat(float64(minAmount)/100, 'f', 2, 64)))
+		return
+	}
+	delete(h.awaitingPrice, chatID)
+
+	basePrice, _ := packPrice(currency, 1)
+	tip := max(amount-basePrice, 0)
+	photoCount := int64(1)
+	if h.customPrice.BonusThreshold > 0 && tip >= bonusThreshold {
+		photoCount++ // thank-you tier: a bonus photo
+	}
+
+	req := services.CheckoutRequest{
+		UserID:     strconv.FormatInt(from.ID, 10),
+		Amount:     amount,
+		Currency:   currency,
+		Locale:     checkoutLocale(from.LanguageCode),
+		PhotoCount: photoCount,
+		// The tip and bonus photo are decided on the typed amount, so no promo code may lower it at checkout
+		NoPromotionCodes: true,
+		Metadata: map[string]string{
+			"gross_amount": strconv.FormatInt(amount, 10),
+			"photo_count":  strconv.FormatInt(photoCount, 10),
+			"tip_amount":   strconv.FormatInt(tip, 10),
+		},
+	}
+
+	if photoCount > 1 {
+		h.services.Telegram.SendMessage(chatID, "🎁 Thank you for the generous tip! You'll get a bonus photo.")
+	}
+	h.sendStripeCheckout(chatID, from, req)
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"gobotcat/config"
+	"gobotcat/services"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestHandleCustomAmountDisablesPromotionCodes(t *testing.T) {
+	h, _, _ := newTestTronHandler(t, config.TronConfig{Asset: "TRX"})
+
+	// A Stripe stand-in that records the checkout session; other calls fail and are only logged
+	var mu sync.Mutex
+	var session url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/v1/checkout/sessions" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"type":"invalid_request_error","message":"unexpected request"}}`)
+			return
+		}
+		r.ParseForm()
+		mu.Lock()
+		session = r.PostForm
+		mu.Unlock()
+		fmt.Fprint(w, `{"id":"cs_test_123","object":"checkout.session","url":"https://checkout.stripe.com/c/pay/cs_test_123"}`)
+	}))
+	t.Cleanup(server.Close)
+	h.services.Stripe = services.NewStripeServiceWithBackends("sk_test_123", services.NewStripeBackends(server.URL))
+
+	bot := NewBotHandler(h.services, "https://example.com", h.storer, nil,
+		config.CustomPriceConfig{Min: 100, Max: 10000, BonusThreshold: 1000})
+	bot.handleCustomAmount(testBuyerID, &tgbotapi.User{ID: testBuyerID}, "50")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if session == nil {
+		t.Fatal("no checkout session created")
+	}
+	if session.Has("allow_promotion_codes") {
+		t.Errorf("promotion codes allowed on a name-your-price checkout")
+	}
+	want := map[string]string{
+		"line_items[0][price_data][unit_amount]": "5000",
+		"metadata[tip_amount]":                   "4001",
+		"metadata[photo_count]":                  "2",
+	}
+	for key, value := range want {
+		if got := session.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+}
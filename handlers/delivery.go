@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+
+	"gobotcat/services"
+	"gobotcat/storer"
+)
+
+// deliverPhotos sends count distinct random photos for a paid payment, records
+// each delivered photo and moves the payment to its final status
+func deliverPhotos(svc *services.Services, appStorer *storer.GormStorer, chatID int64, paymentID string, count int) {
+	photos, err := appStorer.GetRandomPhotos(count)
+	if err != nil || len(photos) == 0 {
+		svc.Telegram.SendMessage(chatID, "❌ No photos found")
+		appStorer.UpdatePaymentStatus(paymentID, "failed")
+		return
+	}
+
+	fileIDs := make([]string, 0, len(photos))
+	for _, photo := range photos {
+		fileIDs = append(fileIDs, photo.FileID)
+	}
+
+	if len(fileIDs) == 1 {
+		err = svc.Telegram.SendImage(chatID, fileIDs[0], "Here is your image!")
+	} else {
+		err = svc.Telegram.SendImages(chatID, fileIDs, fmt.Sprintf("Here are your %d images!", len(fileIDs)))
+	}
+	if err != nil {
+		log.Printf("Failed to send images for payment %s: %v\n", paymentID, err)
+		svc.Telegram.SendMessage(chatID, "❌ Error sending image")
+		appStorer.UpdatePaymentStatus(paymentID, "failed")
+		return
+	}
+
+	if err := appStorer.SaveDeliveredPhotos(paymentID, photos); err != nil {
+		log.Printf("Failed to record delivered photos for payment %s: %v", paymentID, err)
+	}
+
+	status := "image_sent"
+	if len(photos) < count {
+		log.Printf("Payment %s: only %d of %d photos available", paymentID, len(photos), count)
+		svc.Telegram.SendMessage(chatID, fmt.Sprintf("⚠️ Only %d of %d photos were available. Contact admin for the rest.", len(photos), count))
+		status = "partially_sent"
+	}
+	appStorer.UpdatePaymentStatus(paymentID, status)
+
+	number, err := appStorer.AssignInvoiceNumber(paymentID)
+	if err != nil {
+		log.Printf("Failed to assign invoice number to payment %s: %v", paymentID, err)
+		return
+	}
+	svc.Telegram.SendMessage(chatID, fmt.Sprintf("🧾 Need a receipt? Send /receipt %d", number))
+}
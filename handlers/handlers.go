@@ -1,20 +1,28 @@
 package handlers
 
 import (
+	"gobotcat/config"
 	"gobotcat/services"
 	"gobotcat/storer"
 )
 
 type Handlers struct {
-	Webhook     *WebhookHandler
-	TronWebhook *TronWebhookHandler
-	Bot         *BotHandler
+	Webhook        *WebhookHandler   // default Stripe endpoint
+	StripeWebhooks []*WebhookHandler // every Stripe endpoint, the default one first
+	TronWebhook    *TronWebhookHandler
+	Bot            *BotHandler
 }
 
-func NewHandlers(svc *services.Services, appStorer *storer.GormStorer, webhookURL string, webhookSecret string) *Handlers {
+func NewHandlers(svc *services.Services, appStorer *storer.GormStorer, webhookURL string, stripeEndpoints []config.StripeEndpoint, starsPrices map[string]int64, customPrice config.CustomPriceConfig, tronWebhook config.TronWebhookConfig) *Handlers {
+	stripeWebhooks := make([]*WebhookHandler, 0, len(stripeEndpoints))
+	for _, endpoint := range stripeEndpoints {
+		stripeWebhooks = append(stripeWebhooks, NewWebhookHandler(svc, appStorer, endpoint))
+	}
+
 	return &Handlers{
-		Webhook:     NewWebhookHandler(svc, appStorer, webhookSecret),
-		TronWebhook: NewTronWebhookHandler(svc, appStorer),
-		Bot:         NewBotHandler(svc, webhookURL, appStorer),
+		Webhook:        stripeWebhooks[0],
+		StripeWebhooks: stripeWebhooks,
+		TronWebhook:    NewTronWebhookHandler(svc, appStorer, tronWebhook),
+		Bot:            NewBotHandler(svc, webhookURL, appStorer, starsPrices, customPrice),
 	}
 }
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const defaultCurrency = "usd"
+
+// packSizes are the photo packs offered at Stripe checkout
+var packSizes = []int64{1, 5, 10}
+
+// packPrices is the price of each pack per currency, in the currency's smallest unit
+var packPrices = map[string]map[int64]int64{
+	"usd": {1: 999, 5: 3999, 10: 6999},
+	"eur": {1: 899, 5: 3599, 10: 6299},
+	"gbp": {1: 799, 5: 3199, 10: 5599},
+	"pln": {1: 3999, 5: 15999, 10: 27999},
+	"brl": {1: 4999, 5: 19999, 10: 34999},
+}
+
+type currencyFormat struct {
+	Symbol    string
+	Suffix    bool  // symbol goes after the amount
+	MinAmount int64 // Stripe's minimum charge in the smallest unit
+}
+
+// currencies lists how to show each supported currency; all of them have 2 decimals
+var currencies = map[string]currencyFormat{
+	"usd": {Symbol: "$", MinAmount: 50},
+	"eur": {Symbol: "€", MinAmount: 50},
+	"gbp": {Symbol: "£", MinAmount: 30},
+	"pln": {Symbol: " zł", Suffix: true, MinAmount: 200},
+	"brl": {Symbol: "R$", MinAmount: 50},
+}
+
+// languageCurrencies maps a Telegram language_code to the buyer's likely currency
+var languageCurrencies = map[string]string{
+	"en-gb": "gbp",
+	"de":    "eur",
+	"fr":    "eur",
+	"es":    "eur",
+	"it":    "eur",
+	"nl":    "eur",
+	"pt":    "eur",
+	"fi":    "eur",
+	"el":    "eur",
+	"sk":    "eur",
+	"sl":    "eur",
+	"et":    "eur",
+	"lv":    "eur",
+	"lt":    "eur",
+	"pl":    "pln",
+	"pt-br": "brl",
+}
+
+// checkoutLocales are the Stripe Checkout locales we pass through, keyed by lowercase language tag
+var checkoutLocales = map[string]string{
+	"bg": "bg", "cs": "cs", "da": "da", "de": "de", "el": "el", "en": "en", "en-gb": "en-GB",
+	"es": "es", "et": "et", "fi": "fi", "fr": "fr", "hr": "hr", "hu": "hu", "id": "id",
+	"it": "it", "ja": "ja", "ko": "ko", "lt": "lt", "lv": "lv", "ms": "ms", "nb": "nb",
+	"nl": "nl", "pl": "pl", "pt": "pt", "pt-br": "pt-BR", "ro": "ro", "ru": "ru", "sk": "sk",
+	"sl": "sl", "sv": "sv", "th": "th", "tr": "tr", "vi": "vi", "zh": "zh",
+}
+
+// userCurrency returns the currency chosen with /currency, or one guessed from the Telegram language
+func (h *BotHandler) userCurrency(from *tgbotapi.User) string {
+	if user, err := h.storer.GetUser(strconv.FormatInt(from.ID, 10)); err == nil {
+		if _, ok := packPrices[user.Currency]; ok {
+			return user.Currency
+		}
+	}
+
+	lang := strings.ToLower(from.LanguageCode)
+	if currency, ok := languageCurrencies[lang]; ok {
+		return currency
+	}
+	base, _, _ := strings.Cut(lang, "-")
+	if currency, ok := languageCurrencies[base]; ok {
+		return currency
+	}
+	return defaultCurrency
+}
+
+// checkoutLocale picks the Stripe Checkout locale for a Telegram language_code
+func checkoutLocale(languageCode string) string {
+	lang := strings.ToLower(languageCode)
+	if locale, ok := checkoutLocales[lang]; ok {
+		return locale
+	}
+	base, _, _ := strings.Cut(lang, "-")
+	if locale, ok := checkoutLocales[base]; ok {
+		return locale
+	}
+	return "auto"
+}
+
+// fromUSD converts an amount in USD cents to currency, using the ratio of single photo prices
+func fromUSD(amount int64, currency string) int64 {
+	localPrice, ok := packPrice(currency, 1)
+	if !ok || currency == defaultCurrency {
+		return amount
+	}
+	return amount * localPrice / stripeImagePrice
+}
+
+// parseMoney parses an amount typed by the buyer, e.g. "12", "12.50", "12,50" or "€12.50"
+func parseMoney(text string) (int64, error) {
+	cleaned := strings.Map(func(r rune) rune {
+		switch {
+		case r >= '0' && r <= '9', r == '.':
+			return r
+		case r == ',':
+			return '.'
+		}
+		return -1
+	}, text)
+
+	value, err := strconv.ParseFloat(cleaned, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid amount %q", text)
+	}
+	return int64(math.Round(value * 100)), nil
+}
+
+func packPrice(currency string, count int64) (int64, bool) {
+	price, ok := packPrices[currency][count]
+	return price, ok
+}
+
+// formatMoney formats an amount in the smallest unit, e.g. 899 eur -> €8.99
+func formatMoney(amount int64, currency string) string {
+	currency = strings.ToLower(currency)
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	format, ok := currencies[currency]
+	if !ok {
+		return fmt.Sprintf("%.2f %s", float64(amount)/100, strings.ToUpper(currency))
+	}
+	if format.Suffix {
+		return fmt.Sprintf("%.2f%s", float64(amount)/100, format.Symbol)
+	}
+	return fmt.Sprintf("%s%.2f", format.Symbol, float64(amount)/100)
+}
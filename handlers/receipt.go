@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"gobotcat/services"
+	"gobotcat/storer"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// handleReceipt sends the PDF receipt of a fulfilled payment. The argument is an invoice
+// number or a transaction reference; buyers get their own receipts, admins any receipt.
+func (h *BotHandler) handleReceipt(chatID int64, from *tgbotapi.User, args string) {
+	id := strings.TrimSpace(args)
+	if id == "" {
+		h.services.Telegram.SendMessage(chatID, "Usage: /receipt <invoice number | payment reference>")
+		return
+	}
+
+	var payment *storer.Payment
+	var err error
+	if number, parseErr := strconv.ParseInt(strings.TrimPrefix(strings.ToUpper(id), "INV-"), 10, 64); parseErr == nil {
+		payment, err = h.storer.GetPaymentByInvoiceNumber(number)
+	} else if payment, err = h.storer.FindPayment(id); err != nil {
+		// Tron orders are known to buyers by the TxID of their transfer
+		payment, err = h.storer.GetTronPayment(id)
+	}
+
+	userID := strconv.FormatInt(from.ID, 10)
+	if err != nil || (payment.UserID != userID && !h.services.Telegram.IsAdmin(chatID)) {
+		h.services.Telegram.SendMessage(chatID, "❌ Receipt not found: "+id)
+		return
+	}
+	if payment.InvoiceNumber == nil {
+		h.services.Telegram.SendMessage(chatID, "❌ This payment has not been fulfilled yet, so it has no receipt")
+		return
+	}
+
+	receipt := buildReceipt(payment)
+	if user, err := h.storer.GetUser(payment.UserID); err == nil && user.Username != "" {
+		receipt.Buyer = "@" + user.Username + " (" + payment.UserID + ")"
+	}
+
+	filename := services.FormatInvoiceNumber(receipt.InvoiceNumber) + ".pdf"
+	if err := h.services.Telegram.SendDocument(chatID, filename, h.services.Receipts.Generate(receipt), "🧾 Your receipt"); err != nil {
+		log.Printf("Failed to send receipt %s: %v", filename, err)
+		h.services.Telegram.SendMessage(chatID, "❌ Failed to send receipt")
+	}
+}
+
+// buildReceipt maps a stored payment to what is printed on its receipt
+func buildReceipt(payment *storer.Payment) services.Receipt {
+	receipt := services.Receipt{
+		InvoiceNumber: *payment.InvoiceNumber,
+		Date:          payment.ConfirmedAt,
+		Buyer:         "Telegram user " + payment.UserID,
+		Item:          services.PackName(max(payment.PhotoCount, 1)),
+		Reference:     payment.ID,
+	}
+	if receipt.Date.IsZero() {
+		receipt.Date = payment.CreatedAt
+	}
+
+	cents := func(amount int64) string { return fmt.Sprintf("%.2f", float64(amount)/100) }
+	receipt.Currency = strings.ToUpper(payment.Currency)
+	if receipt.Currency == "" {
+		receipt.Currency = strings.ToUpper(defaultCurrency)
+	}
+	receipt.Amount = cents(payment.Amount)
+	if payment.DiscountAmount > 0 {
+		receipt.Discount = cents(payment.DiscountAmount)
+		receipt.Price = cents(payment.Amount + payment.DiscountAmount)
+		if payment.GrossAmount > 0 {
+			receipt.Price = cents(payment.GrossAmount)
+		}
+	}
+	if payment.TipAmount > 0 {
+		receipt.Tip = cents(payment.TipAmount)
+	}
+
+	switch payment.Type {
+	case "stripe":
+		receipt.Provider = "Stripe"
+	case "telegram":
+		receipt.Provider = "Telegram Payments"
+		receipt.Reference = payment.TelegramChargeID + " / " + payment.ProviderChargeID
+	case "stars":
+		receipt.Provider = "Telegram Stars"
+		receipt.Reference = payment.TelegramChargeID
+		receipt.Amount = strconv.FormatInt(payment.Amount, 10)
+	case "tron":
+		receipt.Provider = "Tron"
+		receipt.Reference = payment.TxID
+		receipt.Amount = fmt.Sprintf("%.6f", float64(payment.Amount)/1e6)
+		receipt.Currency = "TRX" // orders before quoting were always TRX
+		if payment.Currency != "" {
+			receipt.Currency = strings.ToUpper(payment.Currency)
+		}
+	}
+
+	return receipt
+}
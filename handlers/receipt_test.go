@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"gobotcat/config"
+	"gobotcat/services"
+	"gobotcat/storer"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestBuildReceipt(t *testing.T) {
+	invoice := int64(7)
+	tests := []struct {
+		name    string
+		payment storer.Payment
+		want    services.Receipt
+	}{
+		{
+			name: "stripe with a discount",
+			payment: storer.Payment{Type: "stripe", ID: "cs_1", Amount: 799, GrossAmount: 999, DiscountAmount: 200,
+				Currency: "usd", InvoiceNumber: &invoice},
+			want: services.Receipt{Price: "9.99", Discount: "2.00", Amount: "7.99", Currency: "USD", Provider: "Stripe", Reference: "cs_1"},
+		},
+		{
+			name:    "stripe at full price",
+			payment: storer.Payment{Type: "stripe", ID: "cs_1", Amount: 999, GrossAmount: 999, Currency: "eur", InvoiceNumber: &invoice},
+			want:    services.Receipt{Amount: "9.99", Currency: "EUR", Provider: "Stripe", Reference: "cs_1"},
+		},
+		{
+			name:    "tron",
+			payment: storer.Payment{Type: "tron", ID: "tron-test", TxID: "abc", Amount: 39_961_000, Currency: "usdt", InvoiceNumber: &invoice},
+			want:    services.Receipt{Amount: "39.961000", Currency: "USDT", Provider: "Tron", Reference: "abc"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buildReceipt(&tt.payment)
+			if got.Price != tt.want.Price || got.Discount != tt.want.Discount || got.Amount != tt.want.Amount ||
+				got.Currency != tt.want.Currency || got.Provider != tt.want.Provider || got.Reference != tt.want.Reference {
+				t.Errorf("receipt = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleReceiptByTxID(t *testing.T) {
+	h, _, tg := newTestTronHandler(t, config.TronConfig{Asset: "TRX"})
+	h.services.Receipts = services.NewReceiptService(config.SellerInfo{Name: "GoBotCat Ltd"})
+	bot := NewBotHandler(h.services, "", h.storer, nil, config.CustomPriceConfig{})
+	saveTronPayment(t, h, storer.Payment{ID: "tron-test", Amount: 25_123_000, Status: "image_sent", TxID: "7c2d42"})
+	if _, err := h.storer.AssignInvoiceNumber("tron-test"); err != nil {
+		t.Fatalf("invoice number: %v", err)
+	}
+
+	bot.handleReceipt(testBuyerID, &tgbotapi.User{ID: testBuyerID}, "7c2d42")
+	if messages := tg.messages(testBuyerID); !strings.Contains(messages, "Your receipt") {
+		t.Errorf("no receipt sent for the TxID:\n%s", messages)
+	}
+
+	// Someone else's TxID is not found
+	bot.handleReceipt(7, &tgbotapi.User{ID: 7}, "7c2d42")
+	if messages := tg.messages(7); !strings.Contains(messages, "Receipt not found") {
+		t.Errorf("receipt of another buyer:\n%s", messages)
+	}
+}
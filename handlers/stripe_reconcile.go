@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/stripe/stripe-go/v78"
+	"gobotcat/storer"
+)
+
+// ReconcileReport summarizes one reconciliation run
+type ReconcileReport struct {
+	Sessions   int // Checkout Sessions listed from Stripe
+	Fulfilled  int // paid sessions that were never recorded and got fulfilled now
+	Expired    int // expired sessions recorded as expired
+	Mismatches int // recorded payments whose amount differs from Stripe
+}
+
+func (r ReconcileReport) String() string {
+	return fmt.Sprintf("sessions=%d fulfilled=%d expired=%d mismatches=%d", r.Sessions, r.Fulfilled, r.Expired, r.Mismatches)
+}
+
+// RunStripeReconciliation periodically reconciles Checkout Sessions created within lookback
+func (h *WebhookHandler) RunStripeReconciliation(interval, lookback time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		report, err := h.ReconcileStripe(now.Add(-lookback), now)
+		if err != nil {
+			log.Printf("[STRIPE %s] Reconciliation failed: %v", h.name, err)
+			continue
+		}
+		log.Printf("[STRIPE %s] Reconciliation done: %s", h.name, report)
+	}
+}
+
+// ReconcileStripe compares Checkout Sessions created in [from, to) with recorded payments.
+// Paid sessions we never recorded (missed webhooks) are fulfilled, expired ones are
+// recorded as expired and amount mismatches are reported to admins, once per session.
+func (h *WebhookHandler) ReconcileStripe(from, to time.Time) (*ReconcileReport, error) {
+	sessions, err := h.stripe.ListSessions(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconcileReport{Sessions: len(sessions)}
+	if len(sessions) == 0 {
+		return report, nil
+	}
+
+	ids := make([]string, 0, len(sessions))
+	for _, sess := range sessions {
+		ids = append(ids, sess.ID)
+	}
+	recorded, err := h.storer.GetPaymentsByID(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sess := range sessions {
+		payment, ok := recorded[sess.ID]
+
+		switch {
+		case ok && payment.Status != "expired" && payment.Amount != sess.AmountTotal:
+			report.Mismatches++
+			log.Printf("[STRIPE] Amount mismatch for %s: recorded %d, Stripe %d", sess.ID, payment.Amount, sess.AmountTotal)
+			// The lookback covers a session in many runs; admins hear about it in the first one only
+			first, err := h.storer.MarkMismatchAlerted(sess.ID)
+			if err != nil {
+				log.Printf("[STRIPE] Failed to flag mismatch for %s: %v", sess.ID, err)
+			} else if !first {
+				continue
+			}
+			h.services.Telegram.NotifyAdmins(fmt.Sprintf(
+				"⚠️ Stripe amount mismatch (%s)\nSession: %s\nUser: %s\nRecorded: %s\nStripe: %s",
+				h.name, sess.ID, payment.UserID, formatMoney(payment.Amount, payment.Currency), formatMoney(sess.AmountTotal, string(sess.Currency))))
+
+		case !ok && sess.Status == stripe.CheckoutSessionStatusComplete && sess.PaymentStatus == stripe.CheckoutSessionPaymentStatusPaid:
+			report.Fulfilled++
+			log.Printf("[STRIPE] Fulfilling missed session %s for user %s", sess.ID, sess.ClientReferenceID)
+			h.handleCheckoutSessionCompleted(*sess)
+
+		case !ok && sess.Status == stripe.CheckoutSessionStatusExpired:
+			report.Expired++
+			expired := &storer.Payment{
+				ID:       sess.ID,
+				UserID:   sess.ClientReferenceID,
+				Amount:   sess.AmountTotal,
+				Currency: string(sess.Currency),
+				Status:   "expired",
+			}
+			if err := h.storer.SavePayment(expired); err != nil {
+				log.Printf("[STRIPE] Failed to record expired session %s: %v", sess.ID, err)
+			}
+		}
+	}
+
+	return report, nil
+}
@@ -2,31 +2,43 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/stripe/stripe-go/v78"
-	"github.com/stripe/stripe-go/v78/webhook"
+	"gobotcat/config"
 	"gobotcat/services"
 	"gobotcat/storer"
 )
 
 type WebhookHandler struct {
-	services      *services.Services
-	storer        *storer.GormStorer
-	webhookSecret string
+	services       *services.Services
+	storer         *storer.GormStorer
+	stripe         *services.StripeService // Stripe account this endpoint belongs to
+	name           string
+	path           string
+	webhookSecrets []string
 }
 
-func NewWebhookHandler(svc *services.Services, storer *storer.GormStorer, webhookSecret string) *WebhookHandler {
+func NewWebhookHandler(svc *services.Services, storer *storer.GormStorer, endpoint config.StripeEndpoint) *WebhookHandler {
 	return &WebhookHandler{
-		services:      svc,
-		storer:        storer,
-		webhookSecret: webhookSecret,
+		services:       svc,
+		storer:         storer,
+		stripe:         svc.StripeAccounts[endpoint.Name],
+		name:           endpoint.Name,
+		path:           endpoint.WebhookPath,
+		webhookSecrets: endpoint.WebhookSecrets,
 	}
 }
 
+// Path returns the route this endpoint's webhooks are delivered to
+func (h *WebhookHandler) Path() string {
+	return h.path
+}
+
 func (h *WebhookHandler) HandleStripeWebhook(w http.ResponseWriter, r *http.Request) {
 	const MaxBodyBytes = int64(65536)
 	r.Body = http.MaxBytesReader(w, r.Body, MaxBodyBytes)
@@ -40,14 +52,14 @@ func (h *WebhookHandler) HandleStripeWebhook(w http.ResponseWriter, r *http.Requ
 
 	sig := r.Header.Get("Stripe-Signature")
 
-	event, err := webhook.ConstructEventWithOptions(body, sig, h.webhookSecret, webhook.ConstructEventOptions{
-		IgnoreAPIVersionMismatch: true,
-	})
+	event, secretIndex, err := h.stripe.ConstructWebhookEvent(body, sig, h.webhookSecrets)
 	if err != nil {
-		log.Printf("Webhook signature verification failed: %v\n", err)
+		log.Printf("[STRIPE %s] Webhook signature verification failed: %v\n", h.name, err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	// Once no event matches an old secret any more, it can be removed from the list
+	log.Printf("[STRIPE %s] Event %s verified with webhook secret #%d of %d", h.name, event.ID, secretIndex+1, len(h.webhookSecrets))
 
 	switch event.Type {
 	case "checkout.session.completed":
@@ -72,6 +84,12 @@ func (h *WebhookHandler) HandleStripeWebhook(w http.ResponseWriter, r *http.Requ
 }
 
 func (h *WebhookHandler) handleCheckoutSessionCompleted(sess stripe.CheckoutSession) {
+	// Stripe retries webhooks and reconciliation may have fulfilled the session already
+	if _, err := h.storer.GetPayment(sess.ID); err == nil {
+		log.Printf("Session %s already recorded, skipping", sess.ID)
+		return
+	}
+
 	// userID and chatID actualy the same, in telegram chat bot see you like a chatID, but for Stripe make more sence be use "userID" (chatID), or I make it wrong sorry))
 	userID := sess.ClientReferenceID //string
 	chatID, err := strconv.ParseInt(userID, 10, 64) // same but int
@@ -80,11 +98,30 @@ func (h *WebhookHandler) handleCheckoutSessionCompleted(sess stripe.CheckoutSess
 		return
 	}
 
+	// gross_amount is the list price before bot-side discounts, AmountSubtotal already has them applied
+	grossAmount := sess.AmountSubtotal
+	if gross, err := strconv.ParseInt(sess.Metadata["gross_amount"], 10, 64); err == nil {
+		grossAmount = gross
+	}
+
+	photoCount := int64(1)
+	if count, err := strconv.ParseInt(sess.Metadata["photo_count"], 10, 64); err == nil && count > 0 {
+		photoCount = count
+	}
+
+	tipAmount, _ := strconv.ParseInt(sess.Metadata["tip_amount"], 10, 64)
+
 	payment := &storer.Payment{
-		ID:     sess.ID,
-		UserID: userID,
-		Amount: sess.AmountTotal,
-		Status: "paid",
+		ID:             sess.ID,
+		UserID:         userID,
+		Amount:         sess.AmountTotal,
+		Currency:       string(sess.Currency),
+		GrossAmount:    grossAmount,
+		DiscountAmount: grossAmount - sess.AmountTotal,
+		PromoCode:      sess.Metadata["promo_code"],
+		PhotoCount:     photoCount,
+		TipAmount:      tipAmount,
+		Status:         "paid",
 	}
 	if err := h.storer.SavePayment(payment); err != nil {
 		log.Printf("Failed to save payment: %v", err)
@@ -92,24 +129,36 @@ func (h *WebhookHandler) handleCheckoutSessionCompleted(sess stripe.CheckoutSess
 		return
 	}
 
+	if payment.PromoCode != "" {
+		h.redeemPromo(userID, payment, sess.Metadata["promo_source"])
+	}
+
 	h.services.Telegram.SendMessage(chatID, "✅ Thank you! Your payment was successful.")
 
 	if sess.PaymentStatus == "paid" {
-		photo, err := h.storer.GetRandomPhoto()
-		if err != nil || photo == nil {
-			h.services.Telegram.SendMessage(chatID, "❌ No photos found")
-			h.storer.UpdatePaymentStatus(sess.ID, "failed")
-			return
-		}
+		deliverPhotos(h.services, h.storer, chatID, sess.ID, int(payment.PhotoCount))
+	}
+}
 
-		err = h.services.Telegram.SendImage(chatID, photo.FileID, "Here is your image!")
-		if err != nil {
-			log.Printf("Failed to send image: %v\n", err)
-			h.services.Telegram.SendMessage(chatID, "❌ Error sending image")
-			h.storer.UpdatePaymentStatus(sess.ID, "failed")
-			return
+// redeemPromo counts a bot-side promo code use and clears the code saved on the user
+func (h *WebhookHandler) redeemPromo(userID string, payment *storer.Payment, source string) {
+	if source == "bot" {
+		err := h.storer.RedeemPromoCode(payment.PromoCode, userID, payment.ID)
+		if errors.Is(err, storer.ErrPromoLimitReached) {
+			// Another checkout took the last use while this one was being paid; the discount is already charged
+			log.Printf("Promo code %s redeemed past its usage limit by payment %s of user %s", payment.PromoCode, payment.ID, userID)
+		} else if err != nil {
+			log.Printf("Failed to redeem promo code %s for payment %s: %v", payment.PromoCode, payment.ID, err)
 		}
+	}
 
-		h.storer.UpdatePaymentStatus(sess.ID, "image_sent")
+	user, err := h.storer.GetUser(userID)
+	if err != nil || user.PromoCode != payment.PromoCode {
+		return
+	}
+	user.PromoCode = ""
+	user.StripePromoID = ""
+	if err := h.storer.SaveUser(user); err != nil {
+		log.Printf("Failed to clear promo code for user %s: %v", userID, err)
 	}
 }
@@ -1,28 +1,40 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"strconv"
+	"strings"
 	"time"
 
+	"gobotcat/config"
 	"gobotcat/services"
 	"gobotcat/storer"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+const stripeImagePrice int64 = 999 // single photo in cents, also used for Telegram invoices
+
 type BotHandler struct {
-	services   *services.Services
-	webhookURL string
-	storer     *storer.GormStorer
+	services      *services.Services
+	webhookURL    string
+	storer        *storer.GormStorer
+	starsPrices   map[string]int64 // product -> price in Telegram Stars
+	customPrice   config.CustomPriceConfig
+	awaitingPrice map[int64]bool // chats asked to type an amount for "Name your price"
 }
 
-func NewBotHandler(svc *services.Services, webhookURL string, storer *storer.GormStorer) *BotHandler {
+func NewBotHandler(svc *services.Services, webhookURL string, storer *storer.GormStorer, starsPrices map[string]int64, customPrice config.CustomPriceConfig) *BotHandler {
 	return &BotHandler{
-		services:   svc,
-		webhookURL: webhookURL,
-		storer:     storer,
+		services:      svc,
+		webhookURL:    webhookURL,
+		storer:        storer,
+		starsPrices:   starsPrices,
+		customPrice:   customPrice,
+		awaitingPrice: make(map[int64]bool),
 	}
 }
 
@@ -34,6 +46,11 @@ func (h *BotHandler) HandleUpdates(updates tgbotapi.UpdatesChannel) {
 			continue
 		}
 
+		if update.PreCheckoutQuery != nil {
+			h.handlePreCheckout(update.PreCheckoutQuery)
+			continue
+		}
+
 		if update.Message == nil {
 			continue
 		}
@@ -41,6 +58,11 @@ func (h *BotHandler) HandleUpdates(updates tgbotapi.UpdatesChannel) {
 		chatID := update.Message.Chat.ID
 		userID := strconv.FormatInt(update.Message.From.ID, 10)
 
+		if update.Message.SuccessfulPayment != nil {
+			h.handleSuccessfulPayment(chatID, update.Message.From, update.Message.SuccessfulPayment)
+			continue
+		}
+
 		// Handle photos (higher priority than text)
 		if update.Message.Photo != nil && len(update.Message.Photo) > 0 {
 			if h.services.Telegram.IsAdmin(chatID){
@@ -66,13 +88,45 @@ func (h *BotHandler) HandleUpdates(updates tgbotapi.UpdatesChannel) {
 			continue
 		}
 
-		switch text {
-		case "/start":
+		if !update.Message.IsCommand() && h.awaitingPrice[chatID] {
+			h.handleCustomAmount(chatID, update.Message.From, text)
+			continue
+		}
+		delete(h.awaitingPrice, chatID)
+
+		switch update.Message.Command() {
+		case "start":
 			h.handleStart(chatID)
-		case "/pay":
-			h.handlePaymentMenu(chatID, userID)
-		case "/id":
+		case "pay":
+			h.handlePaymentMenu(chatID, update.Message.From)
+		case "id":
 			fmt.Println(chatID)
+		case "currency":
+			h.handleCurrency(chatID, userID, update.Message.CommandArguments())
+		case "receipt":
+			h.handleReceipt(chatID, update.Message.From, update.Message.CommandArguments())
+		case "promo":
+			h.handlePromo(chatID, update.Message.From, update.Message.CommandArguments())
+		case "lookup":
+			if h.services.Telegram.IsAdmin(chatID) {
+				h.handleLookup(chatID, update.Message.CommandArguments())
+			}
+		case "addpromo":
+			if h.services.Telegram.IsAdmin(chatID) {
+				h.handleAddPromo(chatID, update.Message.CommandArguments())
+			}
+		case "stats":
+			if h.services.Telegram.IsAdmin(chatID) {
+				h.handleStats(chatID)
+			}
+		case "refundstars":
+			if h.services.Telegram.IsAdmin(chatID) {
+				h.handleRefundStars(chatID, update.Message.CommandArguments())
+			}
+		case "tronreview":
+			if h.services.Telegram.IsAdmin(chatID) {
+				h.handleTronReview(chatID, update.Message.CommandArguments())
+			}
 		default:
 			h.services.Telegram.SendMessage(chatID, "Unknown command. Use /pay")
 		}
@@ -80,36 +134,131 @@ func (h *BotHandler) HandleUpdates(updates tgbotapi.UpdatesChannel) {
 }
 
 func (h *BotHandler) handleStart(chatID int64) {
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+	rows := [][]tgbotapi.InlineKeyboardButton{
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("Pay with Stripe", "pay_stripe"),
 			tgbotapi.NewInlineKeyboardButtonData("Pay with USDT", "pay_usdt"),
 		),
-	)
+	}
+	if row := h.telegramPaymentRow("Pay in Telegram", "Pay with Stars"); len(row) > 0 {
+		rows = append(rows, row)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Name your price", "pay_custom"),
+	))
 
 	msg := tgbotapi.NewMessage(chatID, "Welcome! Choose payment method to buy photos")
-	msg.ReplyMarkup = keyboard
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
 	h.services.Telegram.Bot().Send(msg)
 }
 
-func (h *BotHandler) handlePaymentMenu(chatID int64, userID string) {
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+// telegramPaymentRow holds the in-Telegram payment buttons: the invoice when a provider token is set
+// and Stars when a Stars price is configured. It is empty when neither is available.
+func (h *BotHandler) telegramPaymentRow(invoiceLabel, starsLabel string) []tgbotapi.InlineKeyboardButton {
+	var row []tgbotapi.InlineKeyboardButton
+	if h.services.Telegram.HasPaymentProvider() {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(invoiceLabel, "pay_telegram"))
+	}
+	if _, ok := h.starsPrices[invoicePayload]; ok {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(starsLabel, "pay_stars"))
+	}
+	return row
+}
+
+func (h *BotHandler) handlePaymentMenu(chatID int64, from *tgbotapi.User) {
+	currency := h.userCurrency(from)
+	price, _ := packPrice(currency, 1)
+
+	rows := [][]tgbotapi.InlineKeyboardButton{
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Stripe ($9.99)", "pay_stripe"),
-			tgbotapi.NewInlineKeyboardButtonData("USDT (10)", "pay_usdt"),
+			tgbotapi.NewInlineKeyboardButtonData("Stripe ("+formatMoney(price, currency)+")", "pay_stripe"),
+			tgbotapi.NewInlineKeyboardButtonData(h.services.Tron.Asset()+" ("+formatMoney(stripeImagePrice, invoiceCurrency)+")", "pay_usdt"),
 		),
+	}
+	stars := h.starsPrices[invoicePayload]
+	telegramRow := h.telegramPaymentRow(
+		"Telegram ("+formatMoney(stripeImagePrice, invoiceCurrency)+")",
+		"Stars ("+strconv.FormatInt(stars, 10)+" ⭐)",
 	)
+	if len(telegramRow) > 0 {
+		rows = append(rows, telegramRow)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Name your price", "pay_custom"),
+	))
 
 	msg := tgbotapi.NewMessage(chatID, "Select payment method:")
-	msg.ReplyMarkup = keyboard
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	h.services.Telegram.Bot().Send(msg)
+}
+
+// handlePackMenu lets the buyer pick how many photos to buy via Stripe
+func (h *BotHandler) handlePackMenu(chatID int64, from *tgbotapi.User) {
+	currency := h.userCurrency(from)
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, count := range packSizes {
+		price, _ := packPrice(currency, count)
+		label := fmt.Sprintf("%d photos — %s", count, formatMoney(price, currency))
+		if count == 1 {
+			label = "1 photo — " + formatMoney(price, currency)
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, "stripe_pack_"+strconv.FormatInt(count, 10)),
+		))
+	}
+
+	msg := tgbotapi.NewMessage(chatID, "How many photos would you like?")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
 	h.services.Telegram.Bot().Send(msg)
 }
 
 // Handle Stripe payment
-func (h *BotHandler) handleStripePayment(chatID int64, userID string) {
+func (h *BotHandler) handleStripePayment(chatID int64, from *tgbotapi.User, packData string) {
+	currency := h.userCurrency(from)
+	count, err := strconv.ParseInt(packData, 10, 64)
+	price, ok := packPrice(currency, count)
+	if err != nil || !ok {
+		h.services.Telegram.SendMessage(chatID, "Unknown pack size")
+		return
+	}
+
+	if available, err := h.storer.CountPhotos(); err != nil || available < count {
+		h.services.Telegram.SendMessage(chatID, "❌ Not enough photos for this pack right now. Please pick a smaller one.")
+		return
+	}
+
+	userID := strconv.FormatInt(from.ID, 10)
+	req := services.CheckoutRequest{
+		UserID:     userID,
+		Amount:     price,
+		Currency:   currency,
+		Locale:     checkoutLocale(from.LanguageCode),
+		PhotoCount: count,
+		Metadata: map[string]string{
+			"gross_amount": strconv.FormatInt(price, 10),
+			"photo_count":  strconv.FormatInt(count, 10),
+		},
+	}
+	if user, err := h.storer.GetUser(userID); err == nil && user.PromoCode != "" {
+		h.applyPromo(chatID, user, &req)
+	}
+
+	h.sendStripeCheckout(chatID, from, req)
+}
+
+// sendStripeCheckout links the buyer's Stripe Customer, creates the session and sends the pay button
+func (h *BotHandler) sendStripeCheckout(chatID int64, from *tgbotapi.User, req services.CheckoutRequest) {
 	h.services.Telegram.SendMessage(chatID, "⏳ Preparing your payment link... Please wait a moment")
 
-	paymentURL, err := h.services.Stripe.CreatePaymentSession(userID, 999, h.webhookURL)
+	customerID, err := h.ensureStripeCustomer(from)
+	if err != nil {
+		// Checkout still works without a Customer, it just won't be linked to history
+		log.Printf("Failed to get Stripe customer for user %s: %v", req.UserID, err)
+	}
+	req.CustomerID = customerID
+
+	paymentURL, err := h.services.Stripe.CreatePaymentSession(req, h.webhookURL)
 	if err != nil {
 		log.Printf("Failed to create payment session: %v", err)
 		h.services.Telegram.SendMessage(chatID, "❌ Failed to create payment session")
@@ -118,7 +267,7 @@ func (h *BotHandler) handleStripePayment(chatID int64, userID string) {
 
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonURL("Pay $9.99", paymentURL),
+			tgbotapi.NewInlineKeyboardButtonURL("Pay "+formatMoney(req.Amount, req.Currency), paymentURL),
 		),
 	)
 
@@ -131,28 +280,39 @@ func (h *BotHandler) handleStripePayment(chatID int64, userID string) {
 func (h *BotHandler) handleUSDTPayment(chatID int64, userID string) {
 	h.services.Telegram.SendMessage(chatID, "⏳ Preparing your payment address... Please wait a moment")
 
-	mainAddress := h.services.Tron.GetMainAddress()
-	if mainAddress == "" {
-		log.Printf("ERROR: Tron main address not configured in TRON_MAIN_ADDRESS env var")
+	if !h.services.Tron.HasDepositWallet() && h.services.Tron.GetMainAddress() == "" {
+		log.Printf("ERROR: neither TRON_XPUB nor TRON_MAIN_ADDRESS is configured")
 		h.services.Telegram.SendMessage(chatID, "❌ USDT payment is not configured. Contact admin.")
 		return
 	}
 
-	payment := h.createOrUpdateTronPayment(userID, mainAddress)
-	if payment == nil {
+	payment, err := h.createTronPayment(userID)
+	if errors.Is(err, storer.ErrNoFreeAmount) {
+		log.Printf("[TRON] All payment amounts for %s are reserved", h.services.Tron.GetMainAddress())
+		h.services.Telegram.SendMessage(chatID, "❌ Too many open crypto orders right now, please try again later.")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save payment: %v", err)
 		h.services.Telegram.SendMessage(chatID, "Failed to create payment")
 		return
 	}
 
 	// Send payment address to user
-	tokenName := "TRX"
-	tokenAmount := "10"
+	tokenName := h.services.Tron.Asset()
+	tokenAmount := formatTronAmount(payment.Amount)
 	message := "Send exactly " + tokenAmount + " " + tokenName + " to this Tron address:\n\n" +
-		"`" + mainAddress + "`\n\n" +
-		"Network: Tron (Shasta Testnet)\n" +
-		"Amount: " + tokenAmount + " " + tokenName + "\n" +
-		"Expires in: 24 hours\n\n" +
-		"We'll notify you when payment is confirmed."
+		"`" + payment.Address + "`\n\n" +
+		"Network: " + h.services.Tron.NetworkName() + "\n" +
+		"Amount: " + tokenAmount + " " + tokenName + " (" + fmt.Sprintf("$%.2f", payment.AmountUSD) + ")\n" +
+		"Rate: 1 " + tokenName + " = $" + strconv.FormatFloat(payment.QuotedRate, 'f', -1, 64) + ", locked until the order expires\n" +
+		"Expires in: 24 hours\n\n"
+	if h.services.Tron.HasDepositWallet() {
+		message += "This address is only for this order.\n"
+	} else {
+		message += "⚠️ The amount identifies your order, send it exactly as shown.\n"
+	}
+	message += "We'll notify you when payment is confirmed."
 
 	h.services.Telegram.SendMessage(chatID, message)
 }
@@ -168,58 +328,372 @@ func (h *BotHandler) handleCallback(query *tgbotapi.CallbackQuery) {
 	// Handle different callback data
 	switch query.Data {
 	case "pay_stripe":
-		h.handleStripePayment(chatID, userID)
+		h.handlePackMenu(chatID, query.From)
 	case "pay_usdt":
 		h.handleUSDTPayment(chatID, userID)
+	case "pay_telegram":
+		h.handleTelegramPayment(chatID)
+	case "pay_stars":
+		h.handleStarsPayment(chatID)
+	case "pay_custom":
+		h.handleCustomPrice(chatID, query.From)
 	default:
+		if pack, ok := strings.CutPrefix(query.Data, "stripe_pack_"); ok {
+			h.handleStripePayment(chatID, query.From, pack)
+			return
+		}
+		if currency, ok := strings.CutPrefix(query.Data, "currency_"); ok {
+			h.handleCurrency(chatID, userID, currency)
+			return
+		}
 		h.services.Telegram.SendMessage(chatID, "Unknown action")
 	}
 }
 
-func (h *BotHandler) handlePhotoUpload(photo *storer.Photo) error{
-	err := h.storer.SavePhoto(photo)
+// ensureStripeCustomer returns the Stripe Customer ID for a Telegram user, creating it on first checkout
+func (h *BotHandler) ensureStripeCustomer(from *tgbotapi.User) (string, error) {
+	userID := strconv.FormatInt(from.ID, 10)
+
+	user, err := h.storer.GetUser(userID)
 	if err != nil {
-		log.Printf("Failed to save photo: %v", err)
-		return err
+		user = &storer.User{ID: userID}
+	}
+
+	if user.StripeCustomerID == "" {
+		customerID, err := h.services.Stripe.CreateCustomer(userID, from.UserName)
+		if err != nil {
+			return "", err
+		}
+		user.StripeCustomerID = customerID
+		user.Username = from.UserName
+		if err := h.storer.SaveUser(user); err != nil {
+			return "", err
+		}
+		return customerID, nil
+	}
+
+	if user.Username != from.UserName {
+		if err := h.services.Stripe.UpdateCustomerUsername(user.StripeCustomerID, from.UserName); err != nil {
+			log.Printf("Failed to update Stripe customer %s: %v", user.StripeCustomerID, err)
+		}
+		user.Username = from.UserName
+		if err := h.storer.SaveUser(user); err != nil {
+			log.Printf("Failed to save user %s: %v", userID, err)
+		}
+	}
+
+	return user.StripeCustomerID, nil
+}
+
+// handleLookup resolves a Stripe customer (cus_...) or checkout session (cs_...) back to the Telegram user
+func (h *BotHandler) handleLookup(chatID int64, id string) {
+	id = strings.TrimSpace(id)
+
+	var telegramID, customerID string
+	switch {
+	case strings.HasPrefix(id, "cus_"):
+		customerID = id
+		if user, err := h.storer.GetUserByStripeCustomerID(id); err == nil {
+			telegramID = user.ID
+		} else {
+			cust, err := h.services.Stripe.GetCustomer(id)
+			if err != nil {
+				h.services.Telegram.SendMessage(chatID, "❌ Customer not found: "+err.Error())
+				return
+			}
+			telegramID = cust.Metadata["telegram_id"]
+		}
+	case strings.HasPrefix(id, "cs_"):
+		sess, err := h.services.Stripe.GetSession(id)
+		if err != nil {
+			h.services.Telegram.SendMessage(chatID, "❌ Session not found: "+err.Error())
+			return
+		}
+		telegramID = sess.ClientReferenceID
+		if sess.Customer != nil {
+			customerID = sess.Customer.ID
+		}
+	default:
+		h.services.Telegram.SendMessage(chatID, "Usage: /lookup <cus_... | cs_...>")
+		return
+	}
+
+	if telegramID == "" {
+		h.services.Telegram.SendMessage(chatID, "❌ No Telegram user linked to "+id)
+		return
+	}
+
+	message := "Telegram ID: " + telegramID
+	if user, err := h.storer.GetUser(telegramID); err == nil {
+		if user.Username != "" {
+			message += "\nUsername: @" + user.Username
+		}
+		if customerID == "" {
+			customerID = user.StripeCustomerID
+		}
+	}
+	if customerID != "" {
+		message += "\nStripe customer: " + customerID
+	}
+
+	h.services.Telegram.SendMessage(chatID, message)
+}
+
+// handlePromo saves a promo code on the user so it is applied on their next Stripe checkout.
+// Bot-side codes are checked first, then active Stripe promotion codes.
+func (h *BotHandler) handlePromo(chatID int64, from *tgbotapi.User, args string) {
+	code := strings.TrimSpace(args)
+	if code == "" {
+		h.services.Telegram.SendMessage(chatID, "Usage: /promo CODE")
+		return
 	}
 
+	userID := strconv.FormatInt(from.ID, 10)
+	user, err := h.storer.GetUser(userID)
+	if err != nil {
+		user = &storer.User{ID: userID, Username: from.UserName}
+	}
+
+	if promo, err := h.storer.GetPromoCode(strings.ToUpper(code)); err == nil {
+		if err := h.validatePromoCode(promo, userID); err != nil {
+			h.services.Telegram.SendMessage(chatID, "❌ "+err.Error())
+			return
+		}
+		user.PromoCode = promo.Code
+		user.StripePromoID = ""
+		if err := h.storer.SaveUser(user); err != nil {
+			log.Printf("Failed to save promo code for user %s: %v", userID, err)
+			h.services.Telegram.SendMessage(chatID, "❌ Failed to apply promo code")
+			return
+		}
+		h.services.Telegram.SendMessage(chatID, "✅ Promo code "+promo.Code+" applied: "+describePromo(promo)+" on your next payment. Use /pay")
+		return
+	}
+
+	stripePromo, err := h.services.Stripe.FindPromotionCode(code)
+	if err != nil {
+		log.Printf("Promo code %q not found: %v", code, err)
+		h.services.Telegram.SendMessage(chatID, "❌ Unknown or expired promo code")
+		return
+	}
+
+	user.PromoCode = stripePromo.Code
+	user.StripePromoID = stripePromo.ID
+	if err := h.storer.SaveUser(user); err != nil {
+		log.Printf("Failed to save promo code for user %s: %v", userID, err)
+		h.services.Telegram.SendMessage(chatID, "❌ Failed to apply promo code")
+		return
+	}
+	h.services.Telegram.SendMessage(chatID, "✅ Promo code "+stripePromo.Code+" will be applied at Stripe checkout. Use /pay")
+}
+
+// applyPromo discounts a checkout with the promo code saved on the user
+func (h *BotHandler) applyPromo(chatID int64, user *storer.User, req *services.CheckoutRequest) {
+	if user.StripePromoID != "" {
+		req.PromotionCodeID = user.StripePromoID
+		req.Metadata["promo_code"] = user.PromoCode
+		req.Metadata["promo_source"] = "stripe"
+		return
+	}
+
+	promo, err := h.storer.GetPromoCode(user.PromoCode)
+	if err == nil {
+		err = h.validatePromoCode(promo, user.ID)
+	}
+	if err != nil {
+		log.Printf("Promo code %s not applied for user %s: %v", user.PromoCode, user.ID, err)
+		h.services.Telegram.SendMessage(chatID, "⚠️ Promo code "+user.PromoCode+" is no longer valid, charging full price")
+		return
+	}
+
+	discount := promoDiscount(promo, req.Amount, req.Currency)
+	req.Amount -= discount
+	req.NoPromotionCodes = true // a Stripe code on top would stack both discounts
+	req.Metadata["promo_code"] = promo.Code
+	req.Metadata["promo_source"] = "bot"
+	req.Metadata["bot_discount"] = strconv.FormatInt(discount, 10)
+}
+
+// validatePromoCode checks expiry and usage limits of a bot-side promo code
+func (h *BotHandler) validatePromoCode(promo *storer.PromoCode, userID string) error {
+	if promo.ExpiresAt > 0 && time.Now().Unix() > promo.ExpiresAt {
+		return fmt.Errorf("promo code %s has expired", promo.Code)
+	}
+	if promo.MaxUses > 0 && promo.Uses >= promo.MaxUses {
+		return fmt.Errorf("promo code %s has reached its usage limit", promo.Code)
+	}
+	if promo.MaxUsesPerUser > 0 {
+		count, err := h.storer.CountPromoRedemptions(promo.Code, userID)
+		if err != nil {
+			return fmt.Errorf("failed to check promo code %s", promo.Code)
+		}
+		if count >= promo.MaxUsesPerUser {
+			return fmt.Errorf("you have already used promo code %s", promo.Code)
+		}
+	}
 	return nil
 }
 
-func (h *BotHandler) createOrUpdateTronPayment(userID, address string) *storer.Payment {
-	existingPayment, err := h.storer.GetTronPaymentByAddress(address)
-	
-	if err == nil && existingPayment != nil {
-		existingPayment.UserID = userID
-		existingPayment.Amount = 10_000_000 // 10 TRX with 6 decimals
-		existingPayment.AmountUSD = 10.0
-		existingPayment.Status = "pending"
-		existingPayment.TxID = ""
-		existingPayment.CreatedAt = time.Now()
-		existingPayment.ExpiresAt = time.Now().Unix() + 86400 // 24 hours
-		
-		if err := h.storer.UpdateTronPayment(existingPayment); err != nil {
-			log.Printf("Failed to update payment: %v", err)
-			return nil
-		}
-		return existingPayment
+// handleAddPromo creates or replaces a bot-side promo code.
+// Usage: /addpromo CODE <20% | 2.50> [max_uses] [max_per_user] [days_valid]
+func (h *BotHandler) handleAddPromo(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) < 2 {
+		h.services.Telegram.SendMessage(chatID, "Usage: /addpromo CODE <20% | 2.50> [max_uses] [max_per_user] [days_valid]")
+		return
 	}
-	
-	payment := &storer.Payment{
-		UserID:    userID,
-		Type:      "tron",
-		Address:   address,
-		Amount:    10_000_000, // 10 TRX with 6 decimals
-		AmountUSD: 10.0,
-		Status:    "pending",
-		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Unix() + 86400, // 24 hours
-	}
-
-	if err := h.storer.SaveTronPayment(payment); err != nil {
-		log.Printf("Failed to save payment: %v", err)
-		return nil
+
+	promo := &storer.PromoCode{Code: strings.ToUpper(fields[0])}
+
+	if percent, ok := strings.CutSuffix(fields[1], "%"); ok {
+		value, err := strconv.ParseInt(percent, 10, 64)
+		if err != nil || value <= 0 || value > 100 {
+			h.services.Telegram.SendMessage(chatID, "❌ Percent off must be between 1% and 100%")
+			return
+		}
+		promo.PercentOff = value
+	} else {
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || value <= 0 {
+			h.services.Telegram.SendMessage(chatID, "❌ Amount off must be a positive number of dollars")
+			return
+		}
+		promo.AmountOff = int64(math.Round(value * 100))
 	}
-	
-	return payment
+
+	limits := make([]int64, 3)
+	for i, field := range fields[2:] {
+		if i >= len(limits) {
+			break
+		}
+		value, err := strconv.ParseInt(field, 10, 64)
+		if err != nil || value < 0 {
+			h.services.Telegram.SendMessage(chatID, "❌ Limits must be non-negative integers")
+			return
+		}
+		limits[i] = value
+	}
+	promo.MaxUses = limits[0]
+	promo.MaxUsesPerUser = limits[1]
+	if limits[2] > 0 {
+		promo.ExpiresAt = time.Now().Add(time.Duration(limits[2]) * 24 * time.Hour).Unix()
+	}
+
+	if existing, err := h.storer.GetPromoCode(promo.Code); err == nil {
+		promo.Uses = existing.Uses
+		promo.CreatedAt = existing.CreatedAt
+	}
+
+	if err := h.storer.SavePromoCode(promo); err != nil {
+		log.Printf("Failed to save promo code: %v", err)
+		h.services.Telegram.SendMessage(chatID, "❌ Failed to save promo code")
+		return
+	}
+
+	h.services.Telegram.SendMessage(chatID, "✅ Promo code "+promo.Code+" saved: "+describePromo(promo))
+}
+
+// handleStats shows gross vs net revenue for card payments and crypto totals in USD
+func (h *BotHandler) handleStats(chatID int64) {
+	var message []string
+	for _, paymentType := range []string{"stripe", "telegram"} {
+		stats, err := h.storer.GetPaymentStats(paymentType)
+		if err != nil {
+			log.Printf("Failed to get %s payment stats: %v", paymentType, err)
+			h.services.Telegram.SendMessage(chatID, "❌ Failed to get stats")
+			return
+		}
+		for _, row := range stats {
+			message = append(message, fmt.Sprintf(
+				"%s payments (%s): %d\nGross: %s\nDiscounts: %s\nTips: %s\nNet: %s",
+				paymentType, strings.ToUpper(row.Currency), row.Count,
+				formatMoney(row.Gross, row.Currency), formatMoney(row.Discount, row.Currency),
+				formatMoney(row.Tips, row.Currency), formatMoney(row.Net, row.Currency)))
+		}
+	}
+
+	if stars, err := h.storer.GetPaymentStats("stars"); err == nil {
+		for _, row := range stars {
+			message = append(message, fmt.Sprintf("stars payments: %d\nNet: %d ⭐", row.Count, row.Net))
+		}
+	}
+	if tron, err := h.storer.GetPaymentStats("tron"); err == nil {
+		for _, row := range tron {
+			message = append(message, fmt.Sprintf("tron payments (%s): %d\nReceived: %s %s\nUSD at quoted rates: $%.2f",
+				strings.ToUpper(row.Currency), row.Count, formatTronAmount(row.Net), strings.ToUpper(row.Currency), row.USD))
+		}
+	}
+	if len(message) == 0 {
+		message = append(message, "No payments yet")
+	}
+
+	h.services.Telegram.SendMessage(chatID, strings.Join(message, "\n\n"))
+}
+
+// promoDiscount returns the discount in the checkout currency, never taking the amount
+// below Stripe's minimum. Fixed discounts are set in USD and scaled by the single photo price.
+func promoDiscount(promo *storer.PromoCode, amount int64, currency string) int64 {
+	discount := fromUSD(promo.AmountOff, currency)
+	if promo.PercentOff > 0 {
+		discount = amount * promo.PercentOff / 100
+	}
+	if minAmount := currencies[currency].MinAmount; amount-discount < minAmount {
+		discount = amount - minAmount
+	}
+	return max(discount, 0)
+}
+
+func describePromo(promo *storer.PromoCode) string {
+	description := formatMoney(promo.AmountOff, defaultCurrency) + " off"
+	if promo.PercentOff > 0 {
+		description = strconv.FormatInt(promo.PercentOff, 10) + "% off"
+	}
+	if promo.ExpiresAt > 0 {
+		description += ", valid until " + time.Unix(promo.ExpiresAt, 0).UTC().Format("2006-01-02")
+	}
+	return description
+}
+
+// handleCurrency sets the currency used for Stripe checkout; without an argument it shows a picker
+func (h *BotHandler) handleCurrency(chatID int64, userID, args string) {
+	currency := strings.ToLower(strings.TrimSpace(args))
+	if currency == "" {
+		var buttons []tgbotapi.InlineKeyboardButton
+		for _, code := range []string{"usd", "eur", "gbp", "pln", "brl"} {
+			buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(strings.ToUpper(code), "currency_"+code))
+		}
+		msg := tgbotapi.NewMessage(chatID, "Choose your currency:")
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(buttons)
+		h.services.Telegram.Bot().Send(msg)
+		return
+	}
+
+	if _, ok := packPrices[currency]; !ok {
+		h.services.Telegram.SendMessage(chatID, "❌ Unsupported currency. Use /currency to pick one")
+		return
+	}
+
+	user, err := h.storer.GetUser(userID)
+	if err != nil {
+		user = &storer.User{ID: userID}
+	}
+	user.Currency = currency
+	if err := h.storer.SaveUser(user); err != nil {
+		log.Printf("Failed to save currency for user %s: %v", userID, err)
+		h.services.Telegram.SendMessage(chatID, "❌ Failed to save currency")
+		return
+	}
+
+	price, _ := packPrice(currency, 1)
+	h.services.Telegram.SendMessage(chatID, "✅ Prices are now shown in "+strings.ToUpper(currency)+": 1 photo costs "+formatMoney(price, currency)+". Use /pay")
+}
+
+func (h *BotHandler) handlePhotoUpload(photo *storer.Photo) error{
+	err := h.storer.SavePhoto(photo)
+	if err != nil {
+		log.Printf("Failed to save photo: %v", err)
+		return err
+	}
+
+	return nil
 }
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"gobotcat/config"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestPaymentMenusOfferConfiguredTelegramPayments(t *testing.T) {
+	tests := []struct {
+		name        string
+		starsPrices map[string]int64
+		want        []string
+		notWant     []string
+	}{
+		{
+			name:    "no provider token and no Stars price",
+			want:    []string{"pay_stripe", "pay_usdt", "pay_custom"},
+			notWant: []string{"pay_telegram", "pay_stars"},
+		},
+		{
+			name:        "Stars price configured",
+			starsPrices: map[string]int64{invoicePayload: 500},
+			want:        []string{"pay_stars"},
+			notWant:     []string{"pay_telegram"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _, tg := newTestTronHandler(t, config.TronConfig{Asset: "TRX"})
+			bot := NewBotHandler(h.services, "", h.storer, tt.starsPrices, config.CustomPriceConfig{})
+
+			bot.handleStart(testBuyerID)
+			bot.handlePaymentMenu(testBuyerID, &tgbotapi.User{ID: testBuyerID})
+
+			tg.mu.Lock()
+			keyboards := tg.keyboards[testBuyerID]
+			tg.mu.Unlock()
+			if len(keyboards) != 2 {
+				t.Fatalf("%d menus sent, want 2", len(keyboards))
+			}
+			for i, keyboard := range keyboards {
+				for _, data := range tt.want {
+					if !strings.Contains(keyboard, `"`+data+`"`) {
+						t.Errorf("menu %d has no %s button: %s", i, data, keyboard)
+					}
+				}
+				for _, data := range tt.notWant {
+					if strings.Contains(keyboard, `"`+data+`"`) {
+						t.Errorf("menu %d offers %s: %s", i, data, keyboard)
+					}
+				}
+			}
+		})
+	}
+}
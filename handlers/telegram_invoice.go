@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gobotcat/services"
+	"gobotcat/storer"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	invoiceCurrency = "USD"
+	invoicePayload  = "image_pack" // identifies the product in pre_checkout_query and successful_payment
+)
+
+// Handle native Telegram payment: the invoice is paid without leaving the chat
+func (h *BotHandler) handleTelegramPayment(chatID int64) {
+	if !h.services.Telegram.HasPaymentProvider() {
+		log.Printf("ERROR: Telegram payment provider not configured in TELEGRAM_PROVIDER_TOKEN env var")
+		h.services.Telegram.SendMessage(chatID, "❌ Telegram payments are not configured. Contact admin.")
+		return
+	}
+
+	err := h.services.Telegram.SendInvoice(chatID, "Image Pack", "A random photo, delivered right here in the chat",
+		invoicePayload, invoiceCurrency, stripeImagePrice)
+	if err != nil {
+		log.Printf("Failed to send invoice: %v", err)
+		h.services.Telegram.SendMessage(chatID, "❌ Failed to create invoice")
+	}
+}
+
+// Handle Telegram Stars payment
+func (h *BotHandler) handleStarsPayment(chatID int64) {
+	stars, ok := h.starsPrices[invoicePayload]
+	if !ok {
+		log.Printf("ERROR: no Stars price for %s in STARS_PRICES env var", invoicePayload)
+		h.services.Telegram.SendMessage(chatID, "❌ Stars payment is not configured. Contact admin.")
+		return
+	}
+
+	err := h.services.Telegram.SendStarsInvoice(chatID, "Image Pack", "A random photo, delivered right here in the chat",
+		invoicePayload, stars)
+	if err != nil {
+		log.Printf("Failed to send Stars invoice: %v", err)
+		h.services.Telegram.SendMessage(chatID, "❌ Failed to create invoice")
+	}
+}
+
+// invoicePrice returns the current price of a product in the invoice currency
+func (h *BotHandler) invoicePrice(payload, currency string) (int64, bool) {
+	if payload != invoicePayload {
+		return 0, false
+	}
+	switch currency {
+	case services.StarsCurrency:
+		stars, ok := h.starsPrices[payload]
+		return stars, ok
+	case invoiceCurrency:
+		return stripeImagePrice, true
+	}
+	return 0, false
+}
+
+// handlePreCheckout validates the order right before Telegram charges the buyer
+func (h *BotHandler) handlePreCheckout(query *tgbotapi.PreCheckoutQuery) {
+	ok, errorMessage := true, ""
+
+	price, known := h.invoicePrice(query.InvoicePayload, query.Currency)
+	switch {
+	case !known:
+		ok, errorMessage = false, "This invoice is no longer valid. Please use /pay again."
+	case int64(query.TotalAmount) != price:
+		ok, errorMessage = false, "The price has changed. Please use /pay again."
+	default:
+		count, err := h.storer.CountPhotos()
+		if err != nil || count == 0 {
+			ok, errorMessage = false, "Sorry, no photos are available right now."
+		}
+	}
+
+	if !ok {
+		log.Printf("Rejected pre-checkout %s from user %d: %s", query.ID, query.From.ID, errorMessage)
+	}
+	if err := h.services.Telegram.AnswerPreCheckoutQuery(query.ID, ok, errorMessage); err != nil {
+		log.Printf("Failed to answer pre-checkout query %s: %v", query.ID, err)
+	}
+}
+
+// handleSuccessfulPayment records a native Telegram or Stars payment and delivers the photo
+func (h *BotHandler) handleSuccessfulPayment(chatID int64, from *tgbotapi.User, sp *tgbotapi.SuccessfulPayment) {
+	userID := strconv.FormatInt(from.ID, 10)
+
+	payment := &storer.Payment{
+		ID:               sp.TelegramPaymentChargeID,
+		UserID:           userID,
+		Amount:           int64(sp.TotalAmount),
+		Currency:         strings.ToLower(sp.Currency),
+		GrossAmount:      int64(sp.TotalAmount),
+		PhotoCount:       1,
+		Status:           "paid",
+		TelegramChargeID: sp.TelegramPaymentChargeID,
+		ProviderChargeID: sp.ProviderPaymentChargeID,
+		ConfirmedAt:      time.Now(),
+	}
+	save := h.storer.SaveTelegramPayment
+	if sp.Currency == services.StarsCurrency {
+		save = h.storer.SaveStarsPayment
+	}
+	if err := save(payment); err != nil {
+		log.Printf("Failed to save payment: %v", err)
+		h.services.Telegram.SendMessage(chatID, "❌ Payment recorded but failed to process. Contact admin.")
+		return
+	}
+
+	h.services.Telegram.SendMessage(chatID, "✅ Thank you! Your payment was successful.")
+
+	deliverPhotos(h.services, h.storer, chatID, payment.ID, 1)
+}
+
+// handleRefundStars refunds a Stars payment by its telegram_payment_charge_id
+func (h *BotHandler) handleRefundStars(chatID int64, args string) {
+	chargeID := strings.TrimSpace(args)
+	if chargeID == "" {
+		h.services.Telegram.SendMessage(chatID, "Usage: /refundstars <telegram_payment_charge_id>")
+		return
+	}
+
+	payment, err := h.storer.GetStarsPayment(chargeID)
+	if err != nil {
+		h.services.Telegram.SendMessage(chatID, "❌ Stars payment not found: "+chargeID)
+		return
+	}
+	if payment.Status == "refunded" {
+		h.services.Telegram.SendMessage(chatID, "Payment "+chargeID+" is already refunded")
+		return
+	}
+
+	userID, err := strconv.ParseInt(payment.UserID, 10, 64)
+	if err != nil {
+		log.Printf("Failed to parse userID: %v", err)
+		h.services.Telegram.SendMessage(chatID, "❌ Invalid user on payment "+chargeID)
+		return
+	}
+
+	if err := h.services.Telegram.RefundStarPayment(userID, chargeID); err != nil {
+		log.Printf("Failed to refund Stars payment %s: %v", chargeID, err)
+		h.services.Telegram.SendMessage(chatID, "❌ Refund failed: "+err.Error())
+		return
+	}
+
+	if err := h.storer.UpdatePaymentStatus(payment.ID, "refunded"); err != nil {
+		log.Printf("Failed to mark payment %s refunded: %v", payment.ID, err)
+	}
+
+	h.services.Telegram.SendMessage(userID, "↩️ Your payment of "+strconv.FormatInt(payment.Amount, 10)+" ⭐ has been refunded.")
+	h.services.Telegram.SendMessage(chatID, "✅ Refunded "+strconv.FormatInt(payment.Amount, 10)+" ⭐ to user "+payment.UserID)
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"gobotcat/storer"
+)
+
+// Tron orders are priced like a card purchase and quoted in TRX/USDT at the current rate.
+// With a deposit wallet every order gets its own derived address; on the shared main address
+// a unique offset of 0.001-0.999 identifies exactly one order that transfers can still be matched to
+const (
+	tronAmountStep  int64 = 1_000 // 0.001
+	tronAmountSlots int64 = 999
+)
+
+// createTronPayment quotes the photo price in the configured asset and reserves the payment.
+// The quote is locked for the 24 hour payment window.
+func (h *BotHandler) createTronPayment(userID string) (*storer.Payment, error) {
+	asset := h.services.Tron.Asset()
+	quote, err := h.services.Rates.Quote(asset)
+	if err != nil {
+		return nil, err
+	}
+
+	payment := &storer.Payment{
+		ID:         newTronPaymentID(),
+		UserID:     userID,
+		Amount:     quoteTronAmount(stripeImagePrice, quote.Rate),
+		Currency:   strings.ToLower(asset),
+		QuotedRate: quote.Rate,
+		ExpiresAt:  time.Now().Unix() + 86400, // 24 hours
+	}
+	payment.AmountUSD = tronAmountUSD(payment.Amount, quote.Rate)
+	log.Printf("[TRON] Quoted %s %s for payment %s at %g USD (%s)", formatTronAmount(payment.Amount), asset, payment.ID, quote.Rate, quote.Source)
+
+	if h.services.Tron.HasDepositWallet() {
+		if err := h.storer.ReserveTronDepositPayment(payment, h.services.Tron.DepositAddress); err != nil {
+			return nil, err
+		}
+		log.Printf("[TRON] Payment %s uses deposit address %s (index %d)", payment.ID, payment.Address, *payment.DerivationIndex)
+		return payment, nil
+	}
+
+	payment.Address = h.services.Tron.GetMainAddress()
+	if err := h.storer.ReserveTronPayment(payment, tronAmountStep, tronAmountSlots, h.services.Tron.LateWindow()); err != nil {
+		return nil, err
+	}
+
+	// The unique offset is part of what the buyer pays, so revenue is counted from the final amount
+	payment.AmountUSD = tronAmountUSD(payment.Amount, quote.Rate)
+	if err := h.storer.UpdateTronPayment(payment); err != nil {
+		log.Printf("[TRON] Failed to record USD amount of payment %s: %v", payment.ID, err)
+	}
+	return payment, nil
+}
+
+// quoteTronAmount converts a USD price in cents to the smallest unit (6 decimals),
+// rounded up to a whole tronAmountStep so buyers never see more than 3 decimals
+func quoteTronAmount(cents int64, rate float64) int64 {
+	amount := int64(math.Ceil(float64(cents) / 100 / rate * 1e6))
+	return (amount + tronAmountStep - 1) / tronAmountStep * tronAmountStep
+}
+
+// tronAmountUSD is the USD value of amount at the quoted rate, rounded to cents
+func tronAmountUSD(amount int64, rate float64) float64 {
+	return math.Round(float64(amount)/1e6*rate*100) / 100
+}
+
+// handleTronReview settles a late or underpaid Tron payment: approve fulfills it once its transfer
+// is confirmed, reject leaves the received funds for a manual refund
+func (h *BotHandler) handleTronReview(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) != 2 || (fields[1] != "approve" && fields[1] != "reject") {
+		h.services.Telegram.SendMessage(chatID, "Usage: /tronreview <payment_id> approve|reject")
+		return
+	}
+	approve := fields[1] == "approve"
+
+	payment, err := h.storer.ResolveTronReview(fields[0], approve)
+	if err != nil {
+		log.Printf("[TRON] Failed to resolve review of %s: %v", fields[0], err)
+		h.services.Telegram.SendMessage(chatID, "❌ No Tron payment in review: "+fields[0])
+		return
+	}
+
+	userID, _ := strconv.ParseInt(payment.UserID, 10, 64)
+	asset := h.services.Tron.Asset()
+	if approve {
+		h.services.Telegram.SendMessage(userID, "✅ Your payment was approved. Your photo will be sent once the transfer is confirmed.")
+		h.services.Telegram.SendMessage(chatID, "✅ Approved "+payment.ID+", it is fulfilled after confirmation")
+		return
+	}
+	h.services.Telegram.SendMessage(userID, "↩️ Your payment of "+formatTronAmount(payment.Received)+" "+asset+
+		" could not be accepted for this order. An admin will contact you about a refund.")
+	h.services.Telegram.SendMessage(chatID, "✅ Rejected "+payment.ID+": refund "+formatTronAmount(payment.Received)+" "+asset+" to "+payment.FromAddress)
+}
+
+// newTronPaymentID returns a random payment ID, e.g. tron_3f9a0c1d2b4e5f60
+func newTronPaymentID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "tron_" + hex.EncodeToString(b)
+}
+
+// formatTronAmount formats an amount in the smallest unit (6 decimals) without trailing zeros
+func formatTronAmount(amount int64) string {
+	return strconv.FormatFloat(float64(amount)/1e6, 'f', -1, 64)
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gobotcat/config"
+	"gobotcat/services"
+	"gobotcat/storer"
+)
+
+func TestCreateTronPaymentReservesDistinctAmounts(t *testing.T) {
+	h, _, _ := newTestTronHandler(t, config.TronConfig{Asset: "TRX"})
+	h.services.Rates = services.NewCachedRates("static", nil, services.StaticRates{"TRX": 0.25}, time.Minute)
+	bot := NewBotHandler(h.services, "", h.storer, nil, config.CustomPriceConfig{})
+
+	// 9.99 USD at 0.25 is 39.96 TRX, plus the offset of the first free slot
+	first, err := bot.createTronPayment("1")
+	if err != nil {
+		t.Fatalf("first order: %v", err)
+	}
+	second, err := bot.createTronPayment("2")
+	if err != nil {
+		t.Fatalf("second order: %v", err)
+	}
+	if first.Amount != 39_961_000 || second.Amount != 39_962_000 || first.Address != testMainAddress {
+		t.Fatalf("amounts %d and %d to %s", first.Amount, second.Amount, first.Address)
+	}
+	if stored := findTronPayment(t, h, second.ID); stored.Amount != second.Amount || stored.AmountUSD != 9.99 {
+		t.Errorf("stored order: %+v", stored)
+	}
+
+	// A late transfer for the expired first order must not pay for a new one
+	first.Status = "expired"
+	first.ExpiresAt = time.Now().Unix() - 600
+	h.storer.UpdateTronPayment(first)
+	if third, err := bot.createTronPayment("3"); err != nil || third.Amount != 39_963_000 {
+		t.Fatalf("order after the first expired: %+v, err %v", third, err)
+	}
+}
+
+func TestReserveTronPaymentSlots(t *testing.T) {
+	h, _, _ := newTestTronHandler(t, config.TronConfig{Asset: "TRX"})
+	reserve := func(id, address string, slots int64) (*storer.Payment, error) {
+		payment := &storer.Payment{ID: id, UserID: "42", Amount: 25_000_000, Address: address, ExpiresAt: time.Now().Unix() + 86400}
+		return payment, h.storer.ReserveTronPayment(payment, tronAmountStep, slots, 72*time.Hour)
+	}
+
+	first, err := reserve("a", testMainAddress, 1)
+	if err != nil || first.Amount != 25_001_000 {
+		t.Fatalf("first order: amount %d, err %v", first.Amount, err)
+	}
+
+	// Two orders compete for the only slot: the second one cannot be told apart and is refused
+	if _, err := reserve("b", testMainAddress, 1); !errors.Is(err, storer.ErrNoFreeAmount) {
+		t.Fatalf("second order with one slot = %v, want ErrNoFreeAmount", err)
+	}
+	// Another address has its own slots
+	if other, err := reserve("c", testTokenAddress, 1); err != nil || other.Amount != 25_001_000 {
+		t.Fatalf("order on another address: amount %d, err %v", other.Amount, err)
+	}
+	if second, err := reserve("d", testMainAddress, 2); err != nil || second.Amount != 25_002_000 {
+		t.Fatalf("second order with two slots: amount %d, err %v", second.Amount, err)
+	}
+
+	// An expired order can still be paid late, so its amount stays taken for the late window
+	first.Status = "expired"
+	first.ExpiresAt = time.Now().Unix() - 600
+	if err := h.storer.UpdateTronPayment(first); err != nil {
+		t.Fatalf("expire: %v", err)
+	}
+	if next, err := reserve("e", testMainAddress, 3); err != nil || next.Amount != 25_003_000 {
+		t.Fatalf("order after the first expired: amount %d, err %v", next.Amount, err)
+	}
+
+	// Past the late window no transfer reaches it any more and the amount is free again
+	first.ExpiresAt = time.Now().Unix() - 73*3600
+	if err := h.storer.UpdateTronPayment(first); err != nil {
+		t.Fatalf("age: %v", err)
+	}
+	if next, err := reserve("f", testMainAddress, 3); err != nil || next.Amount != 25_001_000 {
+		t.Fatalf("order after the late window: amount %d, err %v", next.Amount, err)
+	}
+	if released := findTronPayment(t, h, "a"); released.ReservedAmount != nil {
+		t.Errorf("old order still holds %d", *released.ReservedAmount)
+	}
+
+	// The database refuses a second payment holding a taken amount, even past ReserveTronPayment
+	taken := int64(25_002_000)
+	duplicate := &storer.Payment{ID: "g", UserID: "42", Amount: taken, ReservedAmount: &taken, Status: "pending", Address: testMainAddress}
+	if err := h.storer.SaveTronPayment(duplicate); err == nil {
+		t.Errorf("saved a second payment holding %d", taken)
+	}
+}
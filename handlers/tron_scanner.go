@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"gobotcat/storer"
+)
+
+const (
+	tronBlockTime     = 3 * time.Second // one block every 3 seconds
+	tronReorgDepth    = 20              // recent blocks scanned again each pass; Tron blocks are final after 19
+	tronScanMaxBlocks = 1000            // blocks per pass, so catching up after downtime takes several passes
+)
+
+// scanBlocks reads the blocks added since the last pass and credits every transfer to a watched
+// address. The last scanned block is saved in the database, so a restart resumes where it stopped.
+// The last tronReorgDepth blocks are scanned again to pick up transfers a reorg moved; transfers
+// credited before are recognized by their TxID.
+// It returns an error if TronGrid could not be read, so the caller can back off.
+func (h *TronWebhookHandler) scanBlocks(watched []storer.Payment) error {
+	name := "tron:" + h.services.Tron.Network() + ":" + h.services.Tron.Asset()
+
+	head, err := h.services.Tron.GetLatestBlock()
+	if err != nil {
+		return fmt.Errorf("latest block: %w", err)
+	}
+	cursor, err := h.storer.GetScanCursor(name)
+	if err != nil {
+		log.Printf("[TRON] Failed to read scan cursor: %v", err)
+		return nil
+	}
+
+	if len(watched) == 0 {
+		// Nothing to match, and new payments only take transfers made after them
+		if head > cursor {
+			h.storer.SaveScanCursor(name, head)
+		}
+		return nil
+	}
+
+	addresses := make(map[string]bool)
+	oldest := time.Now()
+	for _, payment := range watched {
+		addresses[payment.Address] = true
+		if payment.CreatedAt.Before(oldest) {
+			oldest = payment.CreatedAt
+		}
+	}
+	saved := cursor
+	if cursor == 0 {
+		// First run: start from about when the oldest watched payment was created
+		cursor = max(head-int64(time.Since(oldest)/tronBlockTime), 1)
+	}
+
+	from := max(cursor+1-tronReorgDepth, 1)
+	to := min(head, from+tronScanMaxBlocks-1)
+	transfers, last, scanErr := h.services.Tron.GetBlockTransfers(from, to)
+	if scanErr != nil {
+		scanErr = fmt.Errorf("blocks %d-%d: %w", from, to, scanErr)
+	}
+
+	for _, transfer := range transfers {
+		if !addresses[transfer.To] {
+			continue
+		}
+		if payment := h.creditTransfer(transfer, watched); payment != nil {
+			replacePayment(watched, payment)
+		}
+	}
+
+	// Also saved on a first run that found no newer blocks, or the next pass would start from the head again
+	if last > saved {
+		if err := h.storer.SaveScanCursor(name, last); err != nil {
+			log.Printf("[TRON] Failed to save scan cursor: %v", err)
+		}
+	}
+	log.Printf("[TRON] Scanned blocks %d-%d of %d for %d addresses", from, last, head, len(addresses))
+	return scanErr
+}
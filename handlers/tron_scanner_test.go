@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"gobotcat/config"
+	"gobotcat/services/trontest"
+)
+
+const testScanCursor = "tron:custom:TRX"
+
+// scanCursor returns the last block the TRX scanner saved
+func scanCursor(t *testing.T, h *TronWebhookHandler) int64 {
+	t.Helper()
+
+	cursor, err := h.storer.GetScanCursor(testScanCursor)
+	if err != nil {
+		t.Fatalf("scan cursor: %v", err)
+	}
+	return cursor
+}
+
+func TestScanBlocksResumesAfterRestart(t *testing.T) {
+	h, chain, tg := newTestTronHandler(t, config.TronConfig{Asset: "TRX"})
+	saveTestTronPayment(t, h, 25_123_000)
+
+	if _, err := checkTronPayment(t, h); err != nil {
+		t.Fatalf("first pass: %v", err)
+	}
+	chain.MineBlocks(50)
+	chain.TransferTRX(testBuyerAddress, testMainAddress, 25_123_000)
+	head := chain.MineBlocks(50)
+	if payment, err := checkTronPayment(t, h); err != nil || payment.Received != 25_123_000 {
+		t.Fatalf("transfer: received %d, err %v", payment.Received, err)
+	}
+	if cursor := scanCursor(t, h); cursor != head {
+		t.Fatalf("cursor = %d, want %d", cursor, head)
+	}
+
+	// A new handler on the same database goes on from the saved block, rescanning only the reorg depth
+	restarted := NewTronWebhookHandler(h.services, h.storer, config.TronWebhookConfig{})
+	reads := len(chain.BlockReads())
+	head = chain.MineBlocks(5)
+	if err := restarted.checkPendingPayments(); err != nil {
+		t.Fatalf("pass after restart: %v", err)
+	}
+	if first := chain.BlockReads()[reads]; first[0] != head-5+1-tronReorgDepth {
+		t.Errorf("restarted scan read from block %d, want %d", first[0], head-5+1-tronReorgDepth)
+	}
+	if cursor := scanCursor(t, restarted); cursor != head {
+		t.Errorf("cursor after restart = %d, want %d", cursor, head)
+	}
+	if payment := findTronPayment(t, h, "tron-test"); payment.Received != 25_123_000 {
+		t.Errorf("received %d after restart, the transfer was credited again", payment.Received)
+	}
+	if n := strings.Count(tg.messages(testBuyerID), "Payment detected"); n != 1 {
+		t.Errorf("buyer was told about the transfer %d times", n)
+	}
+}
+
+func TestScanBlocksRescansReorgDepth(t *testing.T) {
+	h, chain, tg := newTestTronHandler(t, config.TronConfig{Asset: "TRX"})
+	saveTestTronPayment(t, h, 25_123_000)
+
+	head := chain.MineBlocks(30)
+	if payment, err := checkTronPayment(t, h); err != nil || payment.Status != "pending" || scanCursor(t, h) != head {
+		t.Fatalf("empty blocks: status %s, cursor %d, err %v", payment.Status, scanCursor(t, h), err)
+	}
+
+	// A reorg puts the transfer into a block the scanner already passed
+	chain.Reorg(5)
+	chain.TransferTRX(testBuyerAddress, testMainAddress, 25_123_000)
+	if chain.MineBlocks(5) != head {
+		t.Fatalf("head moved in the reorg")
+	}
+	payment, err := checkTronPayment(t, h)
+	if err != nil || payment.Status != "confirming" || payment.BlockNumber != head-4 {
+		t.Fatalf("after the reorg: %+v, err %v", payment, err)
+	}
+	if n := strings.Count(tg.messages(testBuyerID), "Payment detected"); n != 1 {
+		t.Errorf("buyer was told about the transfer %d times", n)
+	}
+}
+
+func TestScanBlocksCatchesUpInSteps(t *testing.T) {
+	h, chain, _ := newTestTronHandler(t, config.TronConfig{Asset: "TRX"})
+	saveTestTronPayment(t, h, 25_123_000)
+	if _, err := checkTronPayment(t, h); err != nil {
+		t.Fatalf("first pass: %v", err)
+	}
+
+	// After downtime the scanner reads at most tronScanMaxBlocks per pass, the transfer is in the last block
+	chain.MineBlocks(2499)
+	chain.TransferTRX(testBuyerAddress, testMainAddress, 25_123_000)
+	head := chain.MineBlocks(1)
+
+	cursor := int64(trontest.StartBlock)
+	for pass := 1; cursor < head; pass++ {
+		if pass > 3 {
+			t.Fatalf("cursor at %d of %d after 3 passes", cursor, head)
+		}
+		reads := len(chain.BlockReads())
+		payment, err := checkTronPayment(t, h)
+		if err != nil {
+			t.Fatalf("pass %d: %v", pass, err)
+		}
+
+		from, to := cursor+1-tronReorgDepth, min(cursor+1-tronReorgDepth+tronScanMaxBlocks-1, head)
+		newReads := chain.BlockReads()[reads:]
+		if newReads[0][0] != from || newReads[len(newReads)-1][1] != to+1 {
+			t.Errorf("pass %d read %d-%d, want %d-%d", pass, newReads[0][0], newReads[len(newReads)-1][1]-1, from, to)
+		}
+		if cursor = scanCursor(t, h); cursor != to {
+			t.Fatalf("pass %d: cursor %d, want %d", pass, cursor, to)
+		}
+		want := "pending"
+		if to == head {
+			want = "confirming"
+		}
+		if payment.Status != want {
+			t.Errorf("pass %d: status %s, want %s", pass, payment.Status, want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+
+	"gobotcat/services"
+)
+
+// SweepDeposits moves confirmed deposits on derived addresses to the cold wallet.
+// With dryRun it only returns the plan; otherwise swept payments get their sweep TxID.
+func (h *TronWebhookHandler) SweepDeposits(sweeper *services.TronSweeper, dryRun bool) (*services.SweepPlan, error) {
+	payments, err := h.storer.GetUnsweptTronDeposits()
+	if err != nil {
+		return nil, fmt.Errorf("load deposits: %w", err)
+	}
+
+	deposits := make([]services.SweepDeposit, 0, len(payments))
+	for _, payment := range payments {
+		deposits = append(deposits, services.SweepDeposit{
+			PaymentID: payment.ID,
+			Index:     *payment.DerivationIndex,
+			Address:   payment.Address,
+		})
+	}
+
+	plan, err := sweeper.Plan(deposits)
+	if err != nil || dryRun {
+		return plan, err
+	}
+
+	execErr := sweeper.Execute(plan)
+	for _, step := range plan.Steps {
+		if step.Kind != "sweep" || step.TxID == "" {
+			continue
+		}
+		if err := h.storer.MarkTronPaymentSwept(step.PaymentID, step.TxID); err != nil {
+			log.Printf("[TRON] Failed to record sweep %s of payment %s: %v", step.TxID, step.PaymentID, err)
+		}
+	}
+	return plan, execErr
+}
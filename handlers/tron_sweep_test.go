@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"gobotcat/config"
+	"gobotcat/services"
+	"gobotcat/services/trontest"
+	"gobotcat/storer"
+)
+
+const (
+	testSweepXPrv = "xprv9z1pB5qPNYGMVrGcyXt97Jwd9GBRoNFdhJfxqbHjzrBKeN1GMETuGFi1c73SQkP8kkKz5MVoMtLRcsDWggUcaPF32AXN4qNsNWBoJbaHcQ7"
+	testColdAddr  = testMainAddress
+)
+
+// newTestSweeper returns a sweeper into testColdAddr; without a signer it can only plan
+func newTestSweeper(t *testing.T, h *TronWebhookHandler, signer services.TronSigner) *services.TronSweeper {
+	t.Helper()
+
+	sweeper, err := services.NewTronSweeper(h.services.Tron, signer, testColdAddr)
+	if err != nil {
+		t.Fatalf("sweeper: %v", err)
+	}
+	return sweeper
+}
+
+func newTestSigner(t *testing.T) *services.LocalTronSigner {
+	t.Helper()
+
+	signer, err := services.NewLocalTronSigner(testSweepXPrv, strings.Repeat("11", 32))
+	if err != nil {
+		t.Fatalf("signer: %v", err)
+	}
+	return signer
+}
+
+// saveDeposit saves a payment on deposit address index of the test wallet and returns the address
+func saveDeposit(t *testing.T, h *TronWebhookHandler, signer *services.LocalTronSigner, id string, index int64, status string) string {
+	t.Helper()
+
+	address, err := signer.DepositAddress(index)
+	if err != nil {
+		t.Fatalf("deposit address %d: %v", index, err)
+	}
+	saveTronPayment(t, h, storer.Payment{ID: id, Amount: 10_000_000, Address: address, DerivationIndex: &index, Status: status})
+	return address
+}
+
+func TestSweepDepositsTRX(t *testing.T) {
+	h, chain, _ := newTestTronHandler(t, config.TronConfig{Asset: "TRX"})
+	signer := newTestSigner(t)
+
+	// The first deposit pays its fee with free bandwidth, the third burns TRX for it
+	paid := saveDeposit(t, h, signer, "paid", 1, "image_sent")
+	chain.SetBalance(paid, 10_000_000)
+	chain.SetResources(paid, 600, 0)
+	review := saveDeposit(t, h, signer, "review", 2, "review")
+	chain.SetBalance(review, 10_000_000)
+	rejected := saveDeposit(t, h, signer, "rejected", 3, "rejected")
+	chain.SetBalance(rejected, 3_000_000)
+
+	plan, err := h.SweepDeposits(newTestSweeper(t, h, signer), false)
+	if err != nil {
+		t.Fatalf("sweep: %v\n%s", err, plan)
+	}
+
+	broadcasts := chain.Broadcasts()
+	want := []struct {
+		from   string
+		amount int64
+	}{{paid, 10_000_000}, {rejected, 3_000_000 - 270*trontest.BandwidthPrice}}
+	if len(broadcasts) != len(want) {
+		t.Fatalf("broadcast %d transactions, want %d: %+v", len(broadcasts), len(want), broadcasts)
+	}
+	for i, w := range want {
+		b := broadcasts[i]
+		if b.Contract != "TransferContract" || b.From != w.from || b.To != testColdAddr || b.Amount.Int64() != w.amount {
+			t.Errorf("broadcast %d = %+v, want %d sun from %s", i, b, w.amount, w.from)
+		}
+	}
+	if plan.TotalFee() != 270*trontest.BandwidthPrice {
+		t.Errorf("total fee = %d", plan.TotalFee())
+	}
+
+	// Swept payments are recorded; a payment in review waits for the admin's decision
+	for id, txID := range map[string]string{"paid": broadcasts[0].TxID, "rejected": broadcasts[1].TxID, "review": ""} {
+		if payment := findTronPayment(t, h, id); payment.SweepTxID != txID {
+			t.Errorf("%s: sweep tx %q, want %q", id, payment.SweepTxID, txID)
+		}
+	}
+	if plan, err := h.SweepDeposits(newTestSweeper(t, h, signer), false); err != nil || len(plan.Steps) != 0 {
+		t.Errorf("second sweep: %v, err %v", plan, err)
+	}
+}
+
+func TestSweepDepositsTokenWithTopUp(t *testing.T) {
+	h, chain, _ := newTestTronHandler(t, config.TronConfig{Asset: "USDT", TokenContract: testTokenAddress, TokenDecimals: 6})
+	signer := newTestSigner(t)
+
+	// A fresh deposit has neither TRX nor resources: the fee wallet activates it and pays for the transfer
+	empty := saveDeposit(t, h, signer, "empty", 1, "confirmed")
+	chain.SetTokenBalance(empty, big.NewInt(25_000_000))
+	// This one has enough energy and bandwidth of its own
+	staked := saveDeposit(t, h, signer, "staked", 2, "image_sent")
+	chain.SetBalance(staked, 1_000_000)
+	chain.SetResources(staked, 600, 20_000)
+	chain.SetTokenBalance(staked, big.NewInt(5_000_000))
+
+	plan, err := h.SweepDeposits(newTestSweeper(t, h, signer), false)
+	if err != nil {
+		t.Fatalf("sweep: %v\n%s", err, plan)
+	}
+
+	fee := int64(trontest.TransferEnergy*trontest.EnergyPrice + 350*trontest.BandwidthPrice)
+	topUpFee := int64(270*trontest.BandwidthPrice + trontest.CreateAccountFee)
+	if plan.TotalFee() != fee+topUpFee {
+		t.Errorf("total fee = %d, want %d\n%s", plan.TotalFee(), fee+topUpFee, plan)
+	}
+
+	broadcasts := chain.Broadcasts()
+	if len(broadcasts) != 3 {
+		t.Fatalf("broadcast %d transactions, want 3: %+v", len(broadcasts), broadcasts)
+	}
+	topUp, first, second := broadcasts[0], broadcasts[1], broadcasts[2]
+	if topUp.Contract != "TransferContract" || topUp.From != signer.FeeAddress() || topUp.To != empty || topUp.Amount.Int64() != fee+fee/10 {
+		t.Errorf("top-up = %+v, want %d sun to %s", topUp, fee+fee/10, empty)
+	}
+	if first.Contract != "TriggerSmartContract" || first.From != empty || first.To != testColdAddr ||
+		first.Amount.Int64() != 25_000_000 || first.FeeLimit != 2*fee+1_000_000 || first.Block <= topUp.Block {
+		t.Errorf("sweep after the top-up in block %d = %+v", topUp.Block, first)
+	}
+	if second.From != staked || second.Amount.Int64() != 5_000_000 || second.FeeLimit != 1_000_000 {
+		t.Errorf("sweep of the staked deposit = %+v", second)
+	}
+	if payment := findTronPayment(t, h, "empty"); payment.SweepTxID != first.TxID {
+		t.Errorf("empty deposit recorded sweep %q, want %q", payment.SweepTxID, first.TxID)
+	}
+}
+
+func TestSweepDepositsDryRun(t *testing.T) {
+	h, chain, _ := newTestTronHandler(t, config.TronConfig{Asset: "USDT", TokenContract: testTokenAddress, TokenDecimals: 6})
+	empty := saveDeposit(t, h, newTestSigner(t), "empty", 1, "confirmed")
+	chain.SetTokenBalance(empty, big.NewInt(25_000_000))
+
+	// Without keys the plan still shows the top-up the fee wallet would send
+	plan, err := h.SweepDeposits(newTestSweeper(t, h, nil), true)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if len(plan.Steps) != 2 || plan.Steps[0].Kind != "top-up" || plan.Steps[1].Kind != "sweep" {
+		t.Fatalf("plan:\n%s", plan)
+	}
+	if !strings.Contains(plan.String(), "<fee wallet> -> "+empty) || !strings.Contains(plan.String(), "25.000000 USDT") {
+		t.Errorf("plan does not show the top-up and sweep:\n%s", plan)
+	}
+	if n := chain.Requests("/wallet/broadcasttransaction"); n != 0 {
+		t.Errorf("dry run broadcast %d transactions", n)
+	}
+	if payment := findTronPayment(t, h, "empty"); payment.SweepTxID != "" {
+		t.Errorf("dry run recorded sweep %q", payment.SweepTxID)
+	}
+}
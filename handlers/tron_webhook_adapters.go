@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"gobotcat/services"
+)
+
+// TronWebhookAdapter normalizes the notifications of one provider into TronWebhookPayloads.
+// The payload only says which transaction to look at: amounts and addresses are checked on chain.
+// decimals are those of the configured asset, for providers that send amounts in whole units.
+type TronWebhookAdapter interface {
+	Parse(body []byte, decimals int) ([]TronWebhookPayload, error)
+}
+
+// tronWebhookAdapters are the formats accepted by TRON_WEBHOOK_PROVIDER
+var tronWebhookAdapters = map[string]TronWebhookAdapter{
+	"generic":        genericTronAdapter{},
+	"tatum":          tatumTronAdapter{},
+	"trongrid-event": tronGridEventAdapter{},
+}
+
+// genericTronAdapter accepts TronWebhookPayload as is, a single object or an array
+type genericTronAdapter struct{}
+
+func (genericTronAdapter) Parse(body []byte, decimals int) ([]TronWebhookPayload, error) {
+	if trimmed := strings.TrimSpace(string(body)); strings.HasPrefix(trimmed, "[") {
+		var payloads []TronWebhookPayload
+		if err := json.Unmarshal(body, &payloads); err != nil {
+			return nil, err
+		}
+		return payloads, nil
+	}
+
+	var payload TronWebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	return []TronWebhookPayload{payload}, nil
+}
+
+// tatumTronAdapter reads Tatum address notifications, which give the amount in whole units
+type tatumTronAdapter struct{}
+
+func (tatumTronAdapter) Parse(body []byte, decimals int) ([]TronWebhookPayload, error) {
+	var event struct {
+		Address        string `json:"address"`
+		CounterAddress string `json:"counterAddress"`
+		Amount         string `json:"amount"`
+		TxID           string `json:"txId"`
+		BlockNumber    int64  `json:"blockNumber"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+
+	// Outgoing transfers have a negative amount
+	if strings.HasPrefix(event.Amount, "-") {
+		return nil, nil
+	}
+	amount, err := parseTronUnits(event.Amount, decimals)
+	if err != nil {
+		return nil, err
+	}
+	return []TronWebhookPayload{{
+		TxID:     event.TxID,
+		From:     event.CounterAddress,
+		To:       event.Address,
+		Amount:   amount,
+		BlockNum: event.BlockNumber,
+	}}, nil
+}
+
+// tronGridEventAdapter reads TRC-20 Transfer events as delivered by a TronGrid event subscription
+type tronGridEventAdapter struct{}
+
+func (tronGridEventAdapter) Parse(body []byte, decimals int) ([]TronWebhookPayload, error) {
+	var event struct {
+		TransactionID string `json:"transaction_id"`
+		BlockNumber   int64  `json:"block_number"`
+		EventName     string `json:"event_name"`
+		Result        struct {
+			From  string `json:"from"`
+			To    string `json:"to"`
+			Value string `json:"value"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	if event.EventName != "Transfer" {
+		return nil, nil
+	}
+
+	amount, ok := new(big.Int).SetString(event.Result.Value, 10)
+	if !ok || amount.Sign() < 0 {
+		return nil, fmt.Errorf("invalid transfer value %q", event.Result.Value)
+	}
+	return []TronWebhookPayload{{
+		TxID:     event.TransactionID,
+		From:     eventAddress(event.Result.From),
+		To:       eventAddress(event.Result.To),
+		Amount:   amount,
+		BlockNum: event.BlockNumber,
+	}}, nil
+}
+
+// eventAddress converts the 0x-prefixed 20-byte addresses of contract events to Base58
+func eventAddress(address string) string {
+	if hexAddr, ok := strings.CutPrefix(address, "0x"); ok && len(hexAddr) == 40 {
+		if converted, err := services.TronAddressFromHex("41" + hexAddr); err == nil {
+			return converted
+		}
+	}
+	return address
+}
+
+// parseTronUnits converts a decimal amount such as "9.5" to the smallest unit of an asset with decimals places.
+// Only plain non-negative decimals are accepted: no sign, exponent or empty amount.
+func parseTronUnits(value string, decimals int) (*big.Int, error) {
+	whole, fraction, _ := strings.Cut(strings.TrimSpace(value), ".")
+	digits := whole + fraction
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return nil, fmt.Errorf("invalid amount %q", value)
+	}
+	if len(fraction) > decimals {
+		return nil, fmt.Errorf("amount %q has more than %d decimals", value, decimals)
+	}
+	units, _ := new(big.Int).SetString(digits+strings.Repeat("0", decimals-len(fraction)), 10)
+	return units, nil
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestParseTronUnits(t *testing.T) {
+	tests := []struct {
+		value    string
+		decimals int
+		want     string // empty when the value is rejected
+	}{
+		{"9.5", 6, "9500000"},
+		{"10", 6, "10000000"},
+		{" 0.000001 ", 6, "1"},
+		{"1.", 6, "1000000"},
+		{".5", 6, "500000"},
+		{"10.004", 18, "10004000000000000000"},
+		{"7", 0, "7"},
+		{"", 6, ""},
+		{".", 6, ""},
+		{"0.0000001", 6, ""},
+		{"1.5", 0, ""},
+		{"-1", 6, ""},
+		{"-0.5", 6, ""},
+		{"+1", 6, ""},
+		{"1e6", 6, ""},
+		{"1.2.3", 6, ""},
+		{"1,5", 6, ""},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTronUnits(tt.value, tt.decimals)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("parseTronUnits(%q, %d) = %s, want an error", tt.value, tt.decimals, got)
+			}
+			continue
+		}
+		if err != nil || got.String() != tt.want {
+			t.Errorf("parseTronUnits(%q, %d) = %v, %v, want %s", tt.value, tt.decimals, got, err, tt.want)
+		}
+	}
+}
+
+func TestTronWebhookAdapters(t *testing.T) {
+	const txID = "7c2d4206c03a883dd9066d620335dc1be272a8dc733cfa3f6d10308faa37facc"
+
+	tests := []struct {
+		name     string
+		provider string
+		body     string
+		want     []TronWebhookPayload
+		invalid  bool
+	}{
+		{
+			name:     "generic object",
+			provider: "generic",
+			body:     `{"txID":"` + txID + `","from":"` + testBuyerAddress + `","to":"` + testMainAddress + `","amount":25123000,"blockNumber":1001}`,
+			want:     []TronWebhookPayload{{TxID: txID, From: testBuyerAddress, To: testMainAddress, Amount: big.NewInt(25_123_000), BlockNum: 1001}},
+		},
+		{
+			name:     "generic array",
+			provider: "generic",
+			body:     ` [{"txID":"a","amount":1},{"txID":"b","amount":2}]`,
+			want:     []TronWebhookPayload{{TxID: "a", Amount: big.NewInt(1)}, {TxID: "b", Amount: big.NewInt(2)}},
+		},
+		{
+			name:     "generic amount as a string",
+			provider: "generic",
+			body:     `{"txID":"a","amount":"1"}`,
+			invalid:  true,
+		},
+		{
+			name:     "tatum incoming",
+			provider: "tatum",
+			body:     `{"address":"` + testMainAddress + `","counterAddress":"` + testBuyerAddress + `","amount":"25.123","txId":"` + txID + `","blockNumber":1001}`,
+			want:     []TronWebhookPayload{{TxID: txID, From: testBuyerAddress, To: testMainAddress, Amount: big.NewInt(25_123_000), BlockNum: 1001}},
+		},
+		{
+			name:     "tatum outgoing",
+			provider: "tatum",
+			body:     `{"address":"` + testMainAddress + `","amount":"-25.123","txId":"` + txID + `"}`,
+		},
+		{
+			name:     "tatum without amount",
+			provider: "tatum",
+			body:     `{"address":"` + testMainAddress + `","txId":"` + txID + `"}`,
+			invalid:  true,
+		},
+		{
+			name:     "tatum with too many decimals",
+			provider: "tatum",
+			body:     `{"address":"` + testMainAddress + `","amount":"1.0000001","txId":"` + txID + `"}`,
+			invalid:  true,
+		},
+		{
+			name:     "trongrid transfer event",
+			provider: "trongrid-event",
+			body: `{"transaction_id":"` + txID + `","block_number":1001,"event_name":"Transfer","result":{` +
+				`"from":"0xc8599111f29c1e1e061265b4af93ea1f274ad78a","to":"0xea51342dabbb928ae1e576bd39eff8aaf070a8c6","value":"10004000"}}`,
+			want: []TronWebhookPayload{{TxID: txID, From: testBuyerAddress, To: testMainAddress, Amount: big.NewInt(10_004_000), BlockNum: 1001}},
+		},
+		{
+			name:     "trongrid approval event",
+			provider: "trongrid-event",
+			body:     `{"transaction_id":"` + txID + `","event_name":"Approval","result":{"value":"1"}}`,
+		},
+		{
+			name:     "trongrid negative value",
+			provider: "trongrid-event",
+			body:     `{"transaction_id":"` + txID + `","event_name":"Transfer","result":{"value":"-1"}}`,
+			invalid:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tronWebhookAdapters[tt.provider].Parse([]byte(tt.body), 6)
+			if tt.invalid {
+				if err == nil {
+					t.Fatalf("accepted: %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d payloads, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, want := range tt.want {
+				p := got[i]
+				if p.TxID != want.TxID || p.From != want.From || p.To != want.To || p.BlockNum != want.BlockNum || p.Amount.Cmp(want.Amount) != 0 {
+					t.Errorf("payload %d = %+v, want %+v", i, p, want)
+				}
+			}
+		})
+	}
+}
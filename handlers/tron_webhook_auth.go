@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers of a signed Tron webhook request. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" with TRON_WEBHOOK_SECRET; the timestamp is in Unix seconds.
+const (
+	tronSignatureHeader = "X-Tron-Signature"
+	tronTimestampHeader = "X-Tron-Timestamp"
+)
+
+var (
+	errWebhookSignature = errors.New("signature does not match")
+	errWebhookTimestamp = errors.New("timestamp missing or outside the tolerance")
+	errWebhookReplay    = errors.New("request already processed")
+)
+
+// tronWebhookVerifier checks request signatures and rejects replays of a request within the tolerance
+type tronWebhookVerifier struct {
+	secrets   []string
+	tolerance time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time // signature -> when it stops being acceptable
+}
+
+func newTronWebhookVerifier(secrets []string, tolerance time.Duration) *tronWebhookVerifier {
+	return &tronWebhookVerifier{
+		secrets:   secrets,
+		tolerance: tolerance,
+		seen:      make(map[string]time.Time),
+	}
+}
+
+// verify checks signature and timestamp of body and records the signature so it is accepted only once
+func (v *tronWebhookVerifier) verify(body []byte, timestamp, signature string, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errWebhookTimestamp
+	}
+	signedAt := time.Unix(ts, 0)
+	if now.Sub(signedAt) > v.tolerance || signedAt.Sub(now) > v.tolerance {
+		return errWebhookTimestamp
+	}
+
+	given, err := hex.DecodeString(signature)
+	if err != nil {
+		return errWebhookSignature
+	}
+	valid := false
+	for _, secret := range v.secrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		if hmac.Equal(given, mac.Sum(nil)) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return errWebhookSignature
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for sig, expires := range v.seen {
+		if now.After(expires) {
+			delete(v.seen, sig)
+		}
+	}
+	key := hex.EncodeToString(given)
+	if _, ok := v.seen[key]; ok {
+		return errWebhookReplay
+	}
+	v.seen[key] = signedAt.Add(v.tolerance)
+	return nil
+}
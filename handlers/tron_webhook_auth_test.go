@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gobotcat/config"
+)
+
+// signTronWebhook signs body the way a notification provider does
+func signTronWebhook(secret string, body []byte, at time.Time) (timestamp, signature string) {
+	timestamp = strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return timestamp, hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestTronWebhookVerifier(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	body := []byte(`{"txID":"aa"}`)
+
+	tests := []struct {
+		name   string
+		body   []byte
+		secret string
+		at     time.Time
+		sign   func(timestamp, signature string) (string, string)
+		want   error
+	}{
+		{name: "current secret", secret: "new", at: now},
+		{name: "previous secret while rotating", secret: "old", at: now},
+		{name: "retired secret", secret: "retired", at: now, want: errWebhookSignature},
+		{name: "within the tolerance", secret: "new", at: now.Add(-4 * time.Minute)},
+		{name: "clock of the sender ahead", secret: "new", at: now.Add(4 * time.Minute)},
+		{name: "too old", secret: "new", at: now.Add(-6 * time.Minute), want: errWebhookTimestamp},
+		{name: "too far ahead", secret: "new", at: now.Add(6 * time.Minute), want: errWebhookTimestamp},
+		{name: "tampered body", body: []byte(`{"txID":"bb"}`), secret: "new", at: now, want: errWebhookSignature},
+		{name: "timestamp changed after signing", secret: "new", at: now, want: errWebhookSignature,
+			sign: func(timestamp, signature string) (string, string) {
+				return strconv.FormatInt(now.Unix()+1, 10), signature
+			}},
+		{name: "no timestamp", secret: "new", at: now, want: errWebhookTimestamp,
+			sign: func(timestamp, signature string) (string, string) { return "", signature }},
+		{name: "timestamp in milliseconds", secret: "new", at: now, want: errWebhookTimestamp,
+			sign: func(timestamp, signature string) (string, string) { return timestamp + "000", signature }},
+		{name: "no signature", secret: "new", at: now, want: errWebhookSignature,
+			sign: func(timestamp, signature string) (string, string) { return timestamp, "" }},
+		{name: "signature not hex", secret: "new", at: now, want: errWebhookSignature,
+			sign: func(timestamp, signature string) (string, string) { return timestamp, "sha256=" + signature }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newTronWebhookVerifier([]string{"new", "old"}, 5*time.Minute)
+			timestamp, signature := signTronWebhook(tt.secret, body, tt.at)
+			if tt.sign != nil {
+				timestamp, signature = tt.sign(timestamp, signature)
+			}
+			received := body
+			if tt.body != nil {
+				received = tt.body
+			}
+			if err := v.verify(received, timestamp, signature, now); !errors.Is(err, tt.want) {
+				t.Errorf("verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTronWebhookVerifierReplay(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	v := newTronWebhookVerifier([]string{"secret"}, 5*time.Minute)
+	body := []byte(`{"txID":"aa"}`)
+
+	timestamp, signature := signTronWebhook("secret", body, now)
+	if err := v.verify(body, timestamp, signature, now); err != nil {
+		t.Fatalf("first delivery: %v", err)
+	}
+	if err := v.verify(body, timestamp, signature, now.Add(time.Minute)); !errors.Is(err, errWebhookReplay) {
+		t.Errorf("replay = %v, want errWebhookReplay", err)
+	}
+	// Upper-case hex is the same signature
+	if err := v.verify(body, timestamp, strings.ToUpper(signature), now.Add(time.Minute)); !errors.Is(err, errWebhookReplay) {
+		t.Errorf("replay in upper case = %v, want errWebhookReplay", err)
+	}
+
+	// The same body signed again is a new request
+	timestamp, signature = signTronWebhook("secret", body, now.Add(time.Second))
+	if err := v.verify(body, timestamp, signature, now.Add(time.Minute)); err != nil {
+		t.Errorf("new delivery of the same body: %v", err)
+	}
+
+	// Once past the tolerance a replay fails on its timestamp, and the next request forgets the old signatures
+	later := now.Add(10 * time.Minute)
+	if err := v.verify(body, timestamp, signature, later); !errors.Is(err, errWebhookTimestamp) {
+		t.Errorf("late replay = %v, want errWebhookTimestamp", err)
+	}
+	timestamp, signature = signTronWebhook("secret", body, later)
+	if err := v.verify(body, timestamp, signature, later); err != nil {
+		t.Errorf("later delivery: %v", err)
+	}
+	if len(v.seen) != 1 {
+		t.Errorf("%d signatures kept, want only the latest", len(v.seen))
+	}
+}
+
+func TestHandleTronWebhook(t *testing.T) {
+	h, chain, tg := newTestTronHandler(t, config.TronConfig{Asset: "TRX"})
+	h.verifier = newTronWebhookVerifier([]string{"secret"}, 5*time.Minute)
+	h.adapter = genericTronAdapter{}
+	saveTestTronPayment(t, h, 25_123_000)
+
+	txID := chain.TransferTRX(testBuyerAddress, testMainAddress, 25_123_000)
+	chain.MineBlocks(1)
+
+	post := func(body string, sign bool) int {
+		req := httptest.NewRequest(http.MethodPost, "/webhook/tron", bytes.NewBufferString(body))
+		if sign {
+			timestamp, signature := signTronWebhook("secret", []byte(body), time.Now())
+			req.Header.Set(tronTimestampHeader, timestamp)
+			req.Header.Set(tronSignatureHeader, signature)
+		}
+		rec := httptest.NewRecorder()
+		h.HandleTronWebhook(rec, req)
+		return rec.Code
+	}
+
+	notification := `{"txID":"` + txID + `","to":"` + testMainAddress + `","amount":25123000}`
+	if code := post(notification, false); code != http.StatusUnauthorized {
+		t.Errorf("unsigned request: status %d", code)
+	}
+
+	// The chain has another amount than the notification claims, so nothing is credited
+	if code := post(`{"txID":"`+txID+`","to":"`+testMainAddress+`","amount":25000000}`, true); code != http.StatusOK {
+		t.Errorf("mismatched notification: status %d", code)
+	}
+	if payment, _ := h.storer.FindPayment("tron-test"); payment.Status != "pending" {
+		t.Fatalf("credited from a notification the chain does not confirm: %+v", payment)
+	}
+
+	if code := post(notification, true); code != http.StatusOK {
+		t.Fatalf("signed notification: status %d", code)
+	}
+	payment, _ := h.storer.FindPayment("tron-test")
+	if payment.TxID != txID || payment.FromAddress != testBuyerAddress || payment.Received != 25_123_000 {
+		t.Fatalf("after the notification: %+v", payment)
+	}
+	if !strings.Contains(tg.messages(testBuyerID), "Payment detected") {
+		t.Errorf("buyer was not told about the transfer:\n%s", tg.messages(testBuyerID))
+	}
+
+	if code := post(`{"amount":1}`, true); code != http.StatusBadRequest {
+		t.Errorf("notification without txID: status %d", code)
+	}
+	if code := post(`not json`, true); code != http.StatusBadRequest {
+		t.Errorf("malformed notification: status %d", code)
+	}
+}
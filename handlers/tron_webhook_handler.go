@@ -2,11 +2,18 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"math/big"
 	"net/http"
+	"slices"
+	"sort"
 	"strconv"
 	"time"
 
+	"gobotcat/config"
 	"gobotcat/services"
 	"gobotcat/storer"
 )
@@ -16,192 +23,472 @@ import (
 type TronWebhookHandler struct {
 	services *services.Services   // Services
 	storer   *storer.GormStorer   // Database store for payment and photo records
+	verifier *tronWebhookVerifier // nil when no TRON_WEBHOOK_SECRET is set, which disables the webhook
+	adapter  TronWebhookAdapter   // normalizes the configured provider's notifications
 }
 
 // TronWebhookPayload represents a payment notification received from Tron webhook or polling
 type TronWebhookPayload struct {
-	TxID      string `json:"txID"`        // Transaction ID on blockchain
-	From      string `json:"from"`        // Sender's Tron address
-	To        string `json:"to"`          // Recipient's Tron address (our payment address)
-	Amount    int64  `json:"amount"`      // Transaction amount in smallest units (sun)
-	Confirmed bool   `json:"confirmed"`   // Whether transaction has sufficient confirmations
-	BlockNum  int64  `json:"blockNumber"` // Block number containing the transaction
+	TxID      string   `json:"txID"`        // Transaction ID on blockchain
+	From      string   `json:"from"`        // Sender's Tron address
+	To        string   `json:"to"`          // Recipient's Tron address (our payment address)
+	Amount    *big.Int `json:"amount"`      // Transaction amount in smallest units of the asset (sun for TRX)
+	Confirmed bool     `json:"confirmed"`   // Whether transaction has sufficient confirmations
+	BlockNum  int64    `json:"blockNumber"` // Block number containing the transaction
 }
 
 // NewTronWebhookHandler creates a new handler for Tron payment events
 func NewTronWebhookHandler(
 	svc *services.Services,
 	storer *storer.GormStorer,
+	webhook config.TronWebhookConfig,
 ) *TronWebhookHandler {
-	return &TronWebhookHandler{
+	h := &TronWebhookHandler{
 		services: svc,
 		storer:   storer,
+		adapter:  tronWebhookAdapters[webhook.Provider],
 	}
+	if len(webhook.Secrets) > 0 {
+		h.verifier = newTronWebhookVerifier(webhook.Secrets, webhook.Tolerance)
+	} else {
+		log.Printf("[TRON] TRON_WEBHOOK_SECRET is not set, /webhook/tron is disabled")
+	}
+	return h
 }
 
-// HandleTronWebhook processes incoming Tron transaction webhook notifications
-// NOTE: This is currently not active - we use polling (CheckPendingPayments) instead
-// To use this in production: set up a webhook service like Blockhive or use a blockchain listener
+// HandleTronWebhook processes signed transfer notifications from a Tron notification provider.
+// Requests must carry a valid X-Tron-Signature for their X-Tron-Timestamp and are accepted once.
+// The notification only names the transaction: it is looked up on chain and credited with the
+// on-chain sender, recipient and amount. Polling (CheckPendingPayments) keeps running alongside.
 func (h *TronWebhookHandler) HandleTronWebhook(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-
-	var payload TronWebhookPayload
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "invalid payload"})
+	if h.verifier == nil {
+		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	// Get payment record by recipient address
-	payment, err := h.storer.GetTronPaymentByAddress(payload.To)
+	const MaxBodyBytes = int64(65536)
+	r.Body = http.MaxBytesReader(w, r.Body, MaxBodyBytes)
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("Payment not found for address %s: %v", payload.To, err)
-		w.WriteHeader(http.StatusOK) // Still return 200 to not retry webhook
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	if payment == nil {
-		log.Printf("No payment found for address %s", payload.To)
-		w.WriteHeader(http.StatusOK)
+
+	if err := h.verifier.verify(body, r.Header.Get(tronTimestampHeader), r.Header.Get(tronSignatureHeader), time.Now()); err != nil {
+		log.Printf("[TRON] Webhook rejected from %s: %v", r.RemoteAddr, err)
+		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
-	// Convert payment amount from smallest units to display units (6 decimals for TRX/USDT)
-	amountDisplay := float64(payload.Amount) / 1e6
-	
-	// Update payment record with transaction details from webhook
-	payment.TxID = payload.TxID
-	payment.Amount = payload.Amount
-	payment.BlockNumber = payload.BlockNum
-	payment.Status = "confirmed"
-	payment.ConfirmedAt = time.Now()
-	payment.Confirmations = 25 // Assume webhook indicates sufficient confirmations
+	payloads, err := h.adapter.Parse(body, h.services.Tron.Decimals())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid payload"})
+		return
+	}
 
-	if err := h.storer.UpdateTronPayment(payment); err != nil {
-		log.Printf("Failed to update payment: %v", err)
+	watched, err := h.storer.GetWatchedTronPayments(time.Now().Add(-h.services.Tron.LateWindow()))
+	if err != nil {
+		log.Printf("[TRON] Failed to get watched payments: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	// Send notification to user via Telegram
-	userID, _ := strconv.ParseInt(payment.UserID, 10, 64)
-	h.services.Telegram.SendMessage(userID, 
-		"✅ Payment received! "+
-		"Amount: "+strconv.FormatFloat(amountDisplay, 'f', 2, 64)+" USDT\n"+
-		"TxID: "+payload.TxID)
+	for _, payload := range payloads {
+		if payload.TxID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "missing txID"})
+			return
+		}
+
+		transfer, err := h.services.Tron.WithContext(r.Context()).GetTransaction(payload.TxID)
+		if err != nil {
+			log.Printf("[TRON] Webhook transfer %s not verified on chain: %v", payload.TxID, err)
+			continue
+		}
+		if transfer.To != payload.To || payload.Amount == nil || transfer.Amount.Cmp(payload.Amount) != 0 {
+			log.Printf("[TRON] Webhook transfer %s claims %d to %s, chain has %d to %s", payload.TxID, payload.Amount, payload.To, transfer.Amount, transfer.To)
+			continue
+		}
 
+		if payment := h.creditTransfer(*transfer, watched); payment != nil {
+			replacePayment(watched, payment)
+		} else {
+			log.Printf("[TRON] Webhook transfer %s of %d to %s matches no payment", transfer.TxID, transfer.Amount, transfer.To)
+		}
+	}
+	h.advanceConfirmations()
+
+	// 200 even for unmatched transfers, so the provider does not retry them
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
-// CheckPendingPayments continuously polls for payment confirmations on pending addresses
-// TESTNET MODE: Uses polling every 30 seconds (simple approach for testing)
-// NOTE: This is a polling approach suitable for testnet only. For production:
-// - TODO: Replace with webhooks or blockchain event subscriptions for better efficiency
-// - TODO: Increase polling interval to 5-10 minutes to reduce API calls on mainnet
-// - TODO: Add exponential backoff for failed checks
-// - TODO: Implement state machine for payment status transitions
-// - TODO: Add proper error logging and monitoring for stuck payments
+// CheckPendingPayments continuously scans new blocks for transfers to the addresses of open payments
+// Transfers are credited to payments (see creditTransfer); each transaction is credited at most once.
+// A paid payment goes "seen" -> "confirming" -> "confirmed" as blocks are added on top of it.
+// NOTE: Polling every 30 seconds; after failed scans it waits 1, 2, 4 ... up to 16 ticks.
 func (h *TronWebhookHandler) CheckPendingPayments() {
 	// TODO: Make polling interval configurable (currently 30 seconds for testing)
-	// Note: For mainnet, increase to 300-600 seconds to avoid excessive API usage
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
+	failures, skip := 0, 0
 	for range ticker.C {
-		log.Printf("[TRON] Checking pending payments...")
-		
-		// Fetch all pending payments from database
-		payments, err := h.storer.GetPendingTronPayments()
-		if err != nil {
-			log.Printf("[TRON] Failed to get pending payments: %v", err)
+		if skip > 0 {
+			skip--
 			continue
 		}
 
-		log.Printf("[TRON] Found %d pending payments", len(payments))
+		if err := h.checkPendingPayments(); err != nil {
+			failures++
+			skip = 1<<min(failures-1, 4) - 1
+			log.Printf("[TRON] Scan failed (%d in a row), next try in %d ticks: %v", failures, skip+1, err)
+		} else {
+			failures = 0
+		}
+	}
+}
 
-		for _, payment := range payments {
-			log.Printf("[TRON] Checking payment for address %s, expected amount: %d sun", payment.Address, payment.Amount)
-			
-			// Check if address expired (24-hour TTL for payment addresses)
-			if time.Now().Unix()-payment.CreatedAt.Unix() > 86400 {
-				log.Printf("[TRON] Payment expired for address %s", payment.Address)
-				payment.Status = "expired"
-				h.storer.UpdateTronPayment(&payment)
-				continue
-			}
+// checkPendingPayments is one pass of CheckPendingPayments: it expires overdue payments, credits
+// transfers in new blocks and counts confirmations. It returns the scan error so the caller can back off.
+func (h *TronWebhookHandler) checkPendingPayments() error {
+	log.Printf("[TRON] Checking pending payments...")
+
+	// Payments still open, plus recently expired or paid ones that late or repeated transfers can reach
+	watched, err := h.storer.GetWatchedTronPayments(time.Now().Add(-h.services.Tron.LateWindow()))
+	if err != nil {
+		log.Printf("[TRON] Failed to get watched payments: %v", err)
+		return nil
+	}
+
+	log.Printf("[TRON] Watching %d payments", len(watched))
 
-			// Query Tron blockchain for current balance on payment address
-			balance, err := h.services.Tron.CheckBalance(payment.Address)
-			if err != nil {
-				log.Printf("[TRON] Failed to check balance for %s: %v", payment.Address, err)
-				continue
+	for i := range watched {
+		h.expirePayment(&watched[i])
+	}
+
+	scanErr := h.scanBlocks(watched)
+	h.advanceConfirmations()
+	return scanErr
+}
+
+// expirePayment closes a payment whose 24 hour window is over. A pending payment becomes "expired"
+// and can still be paid late; a partly paid one goes to admin review.
+func (h *TronWebhookHandler) expirePayment(payment *storer.Payment) {
+	if time.Now().Unix() <= payment.ExpiresAt {
+		return
+	}
+
+	userID, _ := strconv.ParseInt(payment.UserID, 10, 64)
+	asset := h.services.Tron.Asset()
+	switch payment.Status {
+	case "pending":
+		log.Printf("[TRON] Payment %s expired for address %s", payment.ID, payment.Address)
+		payment.Status = "expired"
+		h.storer.UpdateTronPayment(payment)
+	case "underpaid":
+		log.Printf("[TRON] Payment %s expired with %d of %d received", payment.ID, payment.Received, payment.Amount)
+		payment.Status = "review"
+		h.storer.UpdateTronPayment(payment)
+		h.services.Telegram.SendMessage(userID, fmt.Sprintf(
+			"⌛ Your order expired with %s of %s %s received.\nAn admin will review it and either send your photo or refund you.",
+			formatTronAmount(payment.Received), formatTronAmount(payment.Amount), asset))
+		h.services.Telegram.NotifyAdmins(fmt.Sprintf(
+			"⚠️ Tron payment %s expired underpaid: %s of %s %s received from %s.\n/tronreview %s approve|reject",
+			payment.ID, formatTronAmount(payment.Received), formatTronAmount(payment.Amount), asset, payment.FromAddress, payment.ID))
+	}
+}
+
+// notifyTransferSeen tells the buyer their transfer was found and how deep it has to get
+func (h *TronWebhookHandler) notifyTransferSeen(payment *storer.Payment) {
+	userID, _ := strconv.ParseInt(payment.UserID, 10, 64)
+	h.services.Telegram.SendMessage(userID, fmt.Sprintf(
+		"👀 Payment detected: %s %s\nTxID: %s\n\nWaiting for %d confirmations before your photo is sent.",
+		formatTronAmount(payment.Amount), h.services.Tron.Asset(), payment.TxID, h.services.Tron.Confirmations()))
+}
+
+// advanceConfirmations counts confirmations of seen transfers as head block minus the transfer's current block,
+// moving payments from "seen" to "confirming" and fulfilling them once they reach the configured depth
+func (h *TronWebhookHandler) advanceConfirmations() {
+	payments, err := h.storer.GetConfirmingTronPayments()
+	if err != nil {
+		log.Printf("[TRON] Failed to get confirming payments: %v", err)
+		return
+	}
+	if len(payments) == 0 {
+		return
+	}
+
+	head, err := h.services.Tron.GetLatestBlock()
+	if err != nil {
+		log.Printf("[TRON] Failed to get latest block: %v", err)
+		return
+	}
+	required := h.services.Tron.Confirmations()
+
+	for _, payment := range payments {
+		// Looked up every time: after a reorg the transfer may sit in another block or in none
+		block, err := h.services.Tron.GetTransactionBlock(payment.TxID)
+		if err != nil {
+			log.Printf("[TRON] Failed to get block of %s: %v", payment.TxID, err)
+			continue
+		}
+		if block != payment.BlockNumber && payment.BlockNumber != 0 {
+			log.Printf("[TRON] Transfer %s of payment %s moved from block %d to %d", payment.TxID, payment.ID, payment.BlockNumber, block)
+		}
+		if block == 0 {
+			// Not in a block yet, or dropped by a reorg: start counting again once it is included
+			if payment.BlockNumber != 0 {
+				h.storer.UpdateTronConfirmations(payment.ID, 0, 0, "seen")
 			}
+			continue
+		}
+
+		confirmations := head - block
+		if confirmations < 0 {
+			confirmations = 0
+		}
+		status := "confirming"
+		if confirmations >= required {
+			status = "confirmed"
+		}
+
+		updated, err := h.storer.UpdateTronConfirmations(payment.ID, block, confirmations, status)
+		if err != nil {
+			log.Printf("[TRON] Failed to update confirmations of %s: %v", payment.ID, err)
+			continue
+		}
+		log.Printf("[TRON] Payment %s: %d/%d confirmations", payment.ID, confirmations, required)
+
+		if status == "confirmed" && updated {
+			payment.BlockNumber = block
+			payment.Confirmations = confirmations
+			payment.Status = status
+			h.fulfillTronPayment(&payment)
+		}
+	}
+}
+
+// matchTransfer finds the payment a transfer of amount (in payment units) belongs to among payments created before it.
+// A deposit address belongs to a single payment, which gets every transfer to it. On the shared
+// main address the amount identifies the payment: the oldest pending one with exactly that amount,
+// then an underpaid one topped up from the same sender, then an expired one with exactly that amount
+// (a late payment), then one already paid with the same amount and sender (a repeated payment).
+// Partial first payments to the shared address cannot be attributed and are left for the admins.
+func matchTransfer(transfer services.TronTransaction, amount int64, watched []storer.Payment) *storer.Payment {
+	var candidates []storer.Payment
+	for _, payment := range watched {
+		if payment.Address == transfer.To && transfer.Timestamp >= payment.CreatedAt.Unix() {
+			candidates = append(candidates, payment)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].CreatedAt.Before(candidates[j].CreatedAt) })
 
-			log.Printf("[TRON] Balance for %s: %d sun (expected: %d)", payment.Address, balance.Amount, payment.Amount)
-
-			// If received amount matches or exceeds expected amount, mark as confirmed
-			if balance.Amount >= payment.Amount && payment.Amount > 0 {
-				log.Printf("[TRON] Payment received! Processing confirmation...")
-				
-				// TESTNET MODE: Simple confirmation by balance check
-				// NOTE: This simple confirmation by balance works for testing but has limitations:
-				// - Cannot distinguish between different incoming transactions on the same address
-				// - Cannot verify exact transaction amount if multiple payments received
-				// - No on-chain confirmation verification
-				// TODO: For mainnet, implement proper transaction verification with:
-				// - Real TxID tracking from blockchain transaction details
-				// - Multiple block confirmation requirements
-				// - Exact transaction amount validation with hash verification
-				
-				// Generate a synthetic TxID for testnet (in production, get from blockchain)
-				if payment.TxID == "" {
-					payment.TxID = "testnet-" + strconv.FormatInt(time.Now().Unix(), 10)
-				}
-				
-				payment.Status = "confirmed"
-				payment.ConfirmedAt = time.Now()
-				payment.Confirmations = 25 // Hardcoded for testing, should check actual confirmations on mainnet
-
-				if err := h.storer.UpdateTronPayment(&payment); err != nil {
-					log.Printf("[TRON] Failed to update payment: %v", err)
-					continue
-				}
-
-				log.Printf("[TRON] Payment confirmed! TxID: %s", payment.TxID)
-
-				// Notify user via Telegram that payment was received and confirmed
-				userID, _ := strconv.ParseInt(payment.UserID, 10, 64)
-				amount := float64(payment.Amount) / 1e6
-				h.services.Telegram.SendMessage(userID,
-					"✅ Payment confirmed!\n"+
-					"Amount: "+strconv.FormatFloat(amount, 'f', 2, 64)+" TRX\n"+
-					"TxID: "+payment.TxID)
-
-				// Get random photo from database and send to user
-				photo, err := h.storer.GetRandomPhoto()
-				if err != nil {
-					log.Printf("[TRON] Failed to get random photo: %v", err)
-					continue
-				}
-
-				if photo == nil {
-					log.Printf("[TRON] No photos available in database for user %s", payment.UserID)
-					h.services.Telegram.SendMessage(userID, "Sorry, no photos available right now.")
-					continue
-				}
-
-				// Send photo to user
-				err = h.services.Telegram.SendImage(userID, photo.FileID, "Your reward photo for the payment!")
-				if err != nil {
-					log.Printf("[TRON] Failed to send photo to user %s: %v", payment.UserID, err)
-					continue
-				}
-
-				log.Printf("[TRON] Photo sent successfully to user %s", payment.UserID)
+	rules := []func(payment storer.Payment) bool{
+		func(payment storer.Payment) bool {
+			return payment.DerivationIndex != nil
+		},
+		func(payment storer.Payment) bool {
+			return payment.Status == "pending" && payment.Amount == amount
+		},
+		func(payment storer.Payment) bool {
+			return payment.Status == "underpaid" && payment.FromAddress == transfer.From
+		},
+		func(payment storer.Payment) bool {
+			return payment.Status == "expired" && payment.Amount == amount
+		},
+		func(payment storer.Payment) bool {
+			return payment.Amount == amount && payment.FromAddress == transfer.From
+		},
+	}
+	for _, rule := range rules {
+		for _, payment := range candidates {
+			if rule(payment) {
+				return &payment
 			}
 		}
 	}
+	return nil
+}
+
+// creditTransfer records transfer on the payment it belongs to, moves the payment on and tells the buyer.
+// It returns the updated payment, or nil if nothing matches or the transfer was already credited.
+// Amounts are credited in payment units; a transfer too large to store, like one that matches no
+// payment, is reported to the admins.
+func (h *TronWebhookHandler) creditTransfer(transfer services.TronTransaction, watched []storer.Payment) *storer.Payment {
+	amount, err := transfer.PaymentUnits()
+	if err != nil {
+		log.Printf("[TRON] Transfer %s to %s not credited: %v", transfer.TxID, transfer.To, err)
+		h.reportUnmatched(transfer, watched)
+		return nil
+	}
+
+	match := matchTransfer(transfer, amount, watched)
+	if match == nil {
+		h.reportUnmatched(transfer, watched)
+		return nil
+	}
+	previous := match.Status
+
+	payment, err := h.storer.CreditTronTransfer(match.ID, &storer.TronTransfer{
+		TxID:        transfer.TxID,
+		FromAddress: transfer.From,
+		Amount:      amount,
+		BlockNumber: transfer.BlockNumber,
+	}, h.transferStatus)
+	if errors.Is(err, storer.ErrTransferClaimed) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("[TRON] Failed to credit transfer %s to payment %s: %v", transfer.TxID, match.ID, err)
+		return nil
+	}
+
+	log.Printf("[TRON] Transfer %s of %d from %s (block %d) credited to payment %s: %d/%d received, %s -> %s",
+		transfer.TxID, amount, transfer.From, transfer.BlockNumber, payment.ID, payment.Received, payment.Amount, previous, payment.Status)
+	h.notifyCredit(payment, previous, amount)
+	return payment
+}
+
+// reportUnmatched asks the admins to settle a transfer to a watched address that no payment took,
+// e.g. a partial payment to the shared address. Each transfer is reported once, however often
+// its block is scanned.
+func (h *TronWebhookHandler) reportUnmatched(transfer services.TronTransaction, watched []storer.Payment) {
+	if !slices.ContainsFunc(watched, func(payment storer.Payment) bool { return payment.Address == transfer.To }) {
+		return
+	}
+
+	amount := "0"
+	if transfer.Amount != nil {
+		amount = transfer.Amount.String()
+	}
+	recorded, err := h.storer.RecordUnmatchedTronTransfer(&storer.UnmatchedTronTransfer{
+		TxID:        transfer.TxID,
+		ToAddress:   transfer.To,
+		FromAddress: transfer.From,
+		Amount:      amount,
+		BlockNumber: transfer.BlockNumber,
+	})
+	if err != nil {
+		log.Printf("[TRON] Failed to record unmatched transfer %s: %v", transfer.TxID, err)
+	}
+	if !recorded {
+		return
+	}
+
+	display := services.FormatUnits(transfer.Amount, transfer.Decimals)
+	if units, err := transfer.PaymentUnits(); err == nil {
+		display = formatTronAmount(units)
+	}
+	log.Printf("[TRON] Transfer %s of %s from %s to %s matches no payment", transfer.TxID, amount, transfer.From, transfer.To)
+	h.services.Telegram.NotifyAdmins(fmt.Sprintf(
+		"⚠️ Tron transfer matches no order: %s %s from %s to %s.\nTxID: %s\nRefund it or settle it with the buyer by hand.",
+		display, h.services.Tron.Asset(), transfer.From, transfer.To, transfer.TxID))
+}
+
+// transferStatus decides where a payment goes after a transfer was added to payment.Received.
+// Open payments are "underpaid" until paid in full; expired ones paid in full within the late grace
+// are accepted, anything else late goes to review. Payments already paid or in review keep their status.
+func (h *TronWebhookHandler) transferStatus(payment *storer.Payment) string {
+	paid := payment.Received >= payment.Amount
+	switch payment.Status {
+	case "pending", "underpaid":
+		if !paid {
+			return "underpaid"
+		}
+		return "seen"
+	case "expired":
+		if paid && time.Now().Before(time.Unix(payment.ExpiresAt, 0).Add(h.services.Tron.LateGrace())) {
+			return "seen"
+		}
+		return "review"
+	}
+	return payment.Status
+}
+
+// notifyCredit tells the buyer, and for anything that needs a decision the admins, what a transfer did
+func (h *TronWebhookHandler) notifyCredit(payment *storer.Payment, previous string, amount int64) {
+	userID, _ := strconv.ParseInt(payment.UserID, 10, 64)
+	asset := h.services.Tron.Asset()
+
+	switch {
+	case payment.Status == "underpaid":
+		from := ""
+		if payment.DerivationIndex == nil {
+			from = " from the same wallet"
+		}
+		h.services.Telegram.SendMessage(userID, fmt.Sprintf(
+			"⚠️ Received %s %s, but the order is %s %s.\nPlease send the remaining %s %s to the same address%s before the order expires.",
+			formatTronAmount(payment.Received), asset, formatTronAmount(payment.Amount), asset,
+			formatTronAmount(payment.Amount-payment.Received), asset, from))
+
+	case payment.Status == "seen" && previous != "seen":
+		if previous == "expired" {
+			h.services.Telegram.SendMessage(userID, "⏰ Your payment arrived after the order expired, but it was still accepted.")
+		}
+		h.notifyTransferSeen(payment)
+		if payment.Credit > 0 {
+			h.notifyOverpayment(payment)
+		}
+
+	case payment.Status == "review" && previous == "expired":
+		h.services.Telegram.SendMessage(userID, fmt.Sprintf(
+			"⏰ We received %s %s after your order expired.\nAn admin will review it and either send your photo or refund you.",
+			formatTronAmount(payment.Received), asset))
+		h.services.Telegram.NotifyAdmins(fmt.Sprintf(
+			"⚠️ Late Tron payment %s: %s of %s %s received from %s after expiry.\n/tronreview %s approve|reject",
+			payment.ID, formatTronAmount(payment.Received), formatTronAmount(payment.Amount), asset, payment.FromAddress, payment.ID))
+
+	case previous == "review":
+		h.services.Telegram.SendMessage(userID, fmt.Sprintf(
+			"Received another %s %s for your order under review, the admin will take it into account.",
+			formatTronAmount(amount), asset))
+		h.services.Telegram.NotifyAdmins(fmt.Sprintf(
+			"Tron payment %s in review received another %s %s, now %s of %s %s",
+			payment.ID, formatTronAmount(amount), asset, formatTronAmount(payment.Received), formatTronAmount(payment.Amount), asset))
+
+	default:
+		// Another transfer for an order that is already paid
+		h.services.Telegram.SendMessage(userID, fmt.Sprintf(
+			"💰 We received another %s %s for an order that was already paid.", formatTronAmount(amount), asset))
+		h.notifyOverpayment(payment)
+	}
+}
+
+// notifyOverpayment tells the buyer their overpayment is kept as credit and asks the admins to settle it
+func (h *TronWebhookHandler) notifyOverpayment(payment *storer.Payment) {
+	userID, _ := strconv.ParseInt(payment.UserID, 10, 64)
+	asset := h.services.Tron.Asset()
+	h.services.Telegram.SendMessage(userID, fmt.Sprintf(
+		"You paid %s %s more than the order price. It is recorded as a credit on your order, contact an admin for a refund.",
+		formatTronAmount(payment.Credit), asset))
+	h.services.Telegram.NotifyAdmins(fmt.Sprintf(
+		"💰 Tron payment %s overpaid: credit of %s %s to refund to %s",
+		payment.ID, formatTronAmount(payment.Credit), asset, payment.FromAddress))
+}
+
+// fulfillTronPayment notifies the buyer and delivers the photos of a confirmed payment
+func (h *TronWebhookHandler) fulfillTronPayment(payment *storer.Payment) {
+	userID, _ := strconv.ParseInt(payment.UserID, 10, 64)
+	h.services.Telegram.SendMessage(userID,
+		"✅ Payment confirmed!\n"+
+			"Amount: "+formatTronAmount(payment.Amount)+" "+h.services.Tron.Asset()+"\n"+
+			"TxID: "+payment.TxID)
+
+	deliverPhotos(h.services, h.storer, userID, payment.ID, int(max(payment.PhotoCount, 1)))
+}
+
+// replacePayment swaps the copy of payment in payments for its updated version
+func replacePayment(payments []storer.Payment, payment *storer.Payment) {
+	for i := range payments {
+		if payments[i].ID == payment.ID {
+			payments[i] = *payment
+			return
+		}
+	}
 }
@@ -0,0 +1,540 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gobotcat/config"
+	"gobotcat/services"
+	"gobotcat/services/trontest"
+	"gobotcat/storer"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const (
+	testMainAddress  = "TXLAQ63Xg1NAzckPwKHvzw7CSEmLMEqcdj"
+	testBuyerAddress = "TUEZSdKsoDHQMeZwihtdoBiN46zxhGWYdH"
+	testTokenAddress = "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t"
+	testBuyerID      = 42
+	testAdminID      = 5147599417                           // the bot admin NotifyAdmins writes to
+	testDepositAddr  = "TSeJkUh4Qv67VNFwY8LaAxERygNdy6NQZK" // deposit address 1 of the test wallet
+)
+
+// testTelegram is a local Bot API that accepts every call and records the texts sent to each chat
+type testTelegram struct {
+	mu         sync.Mutex
+	sent       map[int64][]string
+	keyboards  map[int64][]string // reply_markup of each message, empty without one
+	failPhotos bool               // answer sendPhoto with an error
+}
+
+func (tg *testTelegram) messages(chatID int64) string {
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	return strings.Join(tg.sent[chatID], "\n---\n")
+}
+
+func newTestTelegram(t *testing.T) (*services.TelegramService, *testTelegram) {
+	t.Helper()
+
+	tg := &testTelegram{sent: make(map[int64][]string), keyboards: make(map[int64][]string)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseMultipartForm(1 << 20) // documents are uploaded as multipart, everything else as a form
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/getMe") {
+			fmt.Fprint(w, `{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"Test","username":"test_bot"}}`)
+			return
+		}
+
+		chatID, _ := strconv.ParseInt(r.Form.Get("chat_id"), 10, 64)
+		tg.mu.Lock()
+		if tg.failPhotos && strings.HasSuffix(r.URL.Path, "/sendPhoto") {
+			tg.mu.Unlock()
+			fmt.Fprint(w, `{"ok":false,"error_code":400,"description":"Bad Request: wrong file identifier"}`)
+			return
+		}
+		tg.sent[chatID] = append(tg.sent[chatID], r.Form.Get("text")+r.Form.Get("caption"))
+		tg.keyboards[chatID] = append(tg.keyboards[chatID], r.Form.Get("reply_markup"))
+		tg.mu.Unlock()
+		fmt.Fprintf(w, `{"ok":true,"result":{"message_id":1,"date":0,"chat":{"id":%d,"type":"private"}}}`, chatID)
+	}))
+	t.Cleanup(server.Close)
+
+	telegram, err := services.NewTelegramServiceWithEndpoint("test-token", "", server.URL+"/bot%s/%s")
+	if err != nil {
+		t.Fatalf("telegram: %v", err)
+	}
+	return telegram, tg
+}
+
+// newTestTronHandler wires the Tron handler to a fake TronGrid, a recording Telegram and a fresh database
+func newTestTronHandler(t *testing.T, tron config.TronConfig) (*TronWebhookHandler, *trontest.Server, *testTelegram) {
+	t.Helper()
+
+	chain := trontest.NewServer(t)
+	tron.Network = "custom"
+	tron.RPCURL = chain.URL
+	tron.Confirmations = 19
+	tron.RateLimit = 1000
+	tron.LateGrace = time.Hour
+	tron.LateWindow = 72 * time.Hour
+	tronService, err := services.NewTronService("", testMainAddress, tron)
+	if err != nil {
+		t.Fatalf("tron service: %v", err)
+	}
+	telegram, tg := newTestTelegram(t)
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("database: %v", err)
+	}
+	appStorer := storer.NewGormStorer(db)
+	appStorer.SavePhoto(&storer.Photo{FileID: "photo-1"})
+
+	svc := &services.Services{Tron: tronService, Telegram: telegram}
+	return NewTronWebhookHandler(svc, appStorer, config.TronWebhookConfig{}), chain, tg
+}
+
+func saveTestTronPayment(t *testing.T, h *TronWebhookHandler, amount int64) {
+	t.Helper()
+	saveTronPayment(t, h, storer.Payment{ID: "tron-test", Amount: amount})
+}
+
+// saveTronPayment saves payment for the test buyer; by default it is pending on the main address for 24 hours
+func saveTronPayment(t *testing.T, h *TronWebhookHandler, payment storer.Payment) {
+	t.Helper()
+
+	payment.UserID = strconv.Itoa(testBuyerID)
+	if payment.Status == "" {
+		payment.Status = "pending"
+	}
+	if payment.Address == "" {
+		payment.Address = testMainAddress
+	}
+	if payment.ExpiresAt == 0 {
+		payment.ExpiresAt = time.Now().Unix() + 86400
+	}
+	if err := h.storer.SaveTronPayment(&payment); err != nil {
+		t.Fatalf("save payment %s: %v", payment.ID, err)
+	}
+}
+
+// findTronPayment returns payment id as stored
+func findTronPayment(t *testing.T, h *TronWebhookHandler, id string) *storer.Payment {
+	t.Helper()
+
+	payment, err := h.storer.FindPayment(id)
+	if err != nil {
+		t.Fatalf("payment %s: %v", id, err)
+	}
+	return payment
+}
+
+// checkTronPayment runs one polling pass and returns the payment as stored afterwards
+func checkTronPayment(t *testing.T, h *TronWebhookHandler) (*storer.Payment, error) {
+	t.Helper()
+
+	err := h.checkPendingPayments()
+	payment, getErr := h.storer.FindPayment("tron-test")
+	if getErr != nil {
+		t.Fatalf("payment of buyer: %v", getErr)
+	}
+	return payment, err
+}
+
+func TestCheckPendingPaymentsConfirmsTRXTransfer(t *testing.T) {
+	h, chain, tg := newTestTronHandler(t, config.TronConfig{Asset: "TRX"})
+	saveTestTronPayment(t, h, 25_123_000)
+
+	payment, err := checkTronPayment(t, h)
+	if err != nil || payment.Status != "pending" {
+		t.Fatalf("before the transfer: status %s, err %v", payment.Status, err)
+	}
+
+	// A transfer of another amount belongs to no order on the shared address
+	unmatched := chain.TransferTRX(testBuyerAddress, testMainAddress, 25_000_000)
+	txID := chain.TransferTRX(testBuyerAddress, testMainAddress, 25_123_000)
+	block := chain.MineBlocks(1)
+
+	// TronGrid rejects the pass; nothing is credited and the error reaches the backoff
+	chain.FailRequests(http.StatusBadRequest)
+	if payment, err = checkTronPayment(t, h); err == nil || payment.Status != "pending" {
+		t.Fatalf("failed scan: status %s, err %v", payment.Status, err)
+	}
+
+	payment, err = checkTronPayment(t, h)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if payment.Status != "confirming" || payment.TxID != txID || payment.BlockNumber != block || payment.FromAddress != testBuyerAddress {
+		t.Fatalf("after the transfer: %+v", payment)
+	}
+	if !strings.Contains(tg.messages(testBuyerID), "Payment detected: 25.123 TRX") {
+		t.Errorf("buyer was not told about the transfer:\n%s", tg.messages(testBuyerID))
+	}
+	alert := "matches no order: 25 TRX from " + testBuyerAddress + " to " + testMainAddress + ".\nTxID: " + unmatched
+	if admin := tg.messages(testAdminID); !strings.Contains(admin, alert) {
+		t.Errorf("admins were not told about the unmatched transfer:\n%s", admin)
+	}
+
+	chain.MineBlocks(18)
+	if payment, _ = checkTronPayment(t, h); payment.Status != "confirming" || payment.Confirmations != 18 {
+		t.Fatalf("18 blocks deep: status %s, %d confirmations", payment.Status, payment.Confirmations)
+	}
+
+	chain.MineBlocks(1)
+	if payment, _ = checkTronPayment(t, h); payment.Status != "image_sent" || payment.InvoiceNumber == nil {
+		t.Fatalf("19 blocks deep: status %s, invoice %v", payment.Status, payment.InvoiceNumber)
+	}
+	messages := tg.messages(testBuyerID)
+	if !strings.Contains(messages, "Payment confirmed!") || !strings.Contains(messages, "Here is your image!") {
+		t.Errorf("buyer did not get the photo:\n%s", messages)
+	}
+	// Its block was scanned again on every pass, the alert went out once
+	if n := strings.Count(tg.messages(testAdminID), "matches no order"); n != 1 {
+		t.Errorf("unmatched transfer reported %d times", n)
+	}
+}
+
+func TestCheckPendingPaymentsRecordsFailedDelivery(t *testing.T) {
+	h, chain, tg := newTestTronHandler(t, config.TronConfig{Asset: "TRX"})
+	saveTestTronPayment(t, h, 25_123_000)
+	tg.failPhotos = true
+
+	chain.TransferTRX(testBuyerAddress, testMainAddress, 25_123_000)
+	chain.MineBlocks(20)
+
+	payment, err := checkTronPayment(t, h)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if payment.Status != "failed" || payment.InvoiceNumber != nil {
+		t.Fatalf("photo not sent: status %s, invoice %v", payment.Status, payment.InvoiceNumber)
+	}
+	if !strings.Contains(tg.messages(testBuyerID), "Error sending image") {
+		t.Errorf("buyer was not told the delivery failed:\n%s", tg.messages(testBuyerID))
+	}
+}
+
+func TestCheckPendingPaymentsCreditsTokenTransfers(t *testing.T) {
+	h, chain, tg := newTestTronHandler(t, config.TronConfig{Asset: "USDT", TokenContract: testTokenAddress, TokenDecimals: 6})
+	saveTestTronPayment(t, h, 10_004_000)
+
+	reverted := chain.TransferToken(testTokenAddress, testBuyerAddress, testMainAddress, big.NewInt(10_004_000))
+	chain.FailTransaction(reverted)
+	chain.MineBlocks(1)
+	if payment, err := checkTronPayment(t, h); err != nil || payment.Status != "pending" {
+		t.Fatalf("reverted transfer: status %s, err %v", payment.Status, err)
+	}
+
+	// The exact amount pays the order; paying it again from the same wallet is kept as credit
+	chain.TransferToken(testTokenAddress, testBuyerAddress, testMainAddress, big.NewInt(10_004_000))
+	chain.MineBlocks(1)
+	payment, err := checkTronPayment(t, h)
+	if err != nil || payment.Status != "confirming" || payment.Received != 10_004_000 {
+		t.Fatalf("token transfer: %+v, err %v", payment, err)
+	}
+
+	chain.TransferToken(testTokenAddress, testBuyerAddress, testMainAddress, big.NewInt(10_004_000))
+	chain.MineBlocks(1)
+	payment, err = checkTronPayment(t, h)
+	if err != nil || payment.Received != 20_008_000 || payment.Credit != 10_004_000 {
+		t.Fatalf("repeated transfer: %+v, err %v", payment, err)
+	}
+	if !strings.Contains(tg.messages(testBuyerID), "another 10.004 USDT") {
+		t.Errorf("buyer was not told about the overpayment:\n%s", tg.messages(testBuyerID))
+	}
+}
+
+func TestCheckPendingPaymentsCreditsEighteenDecimalToken(t *testing.T) {
+	h, chain, _ := newTestTronHandler(t, config.TronConfig{Asset: "USDT", TokenContract: testTokenAddress, TokenDecimals: 18})
+	saveTestTronPayment(t, h, 10_004_000)
+
+	// A transfer too large for a payment amount is skipped instead of wrapping around
+	chain.TransferToken(testTokenAddress, testBuyerAddress, testMainAddress, new(big.Int).Lsh(big.NewInt(1), 200))
+	// 10.004 tokens, more than an int64 in the smallest unit
+	txID := chain.TransferToken(testTokenAddress, testBuyerAddress, testMainAddress, services.FromPaymentUnits(10_004_000, 18))
+	chain.MineBlocks(1)
+
+	payment, err := checkTronPayment(t, h)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if payment.Status != "confirming" || payment.TxID != txID || payment.Received != 10_004_000 {
+		t.Fatalf("after the transfer: %+v", payment)
+	}
+}
+
+func TestMatchTransfer(t *testing.T) {
+	created := time.Unix(1_700_000_000, 0)
+	index := int64(1)
+	payment := func(id, status string, amount int64, from string) storer.Payment {
+		return storer.Payment{ID: id, Status: status, Amount: amount, FromAddress: from, Address: testMainAddress, CreatedAt: created}
+	}
+	transfer := services.TronTransaction{From: testBuyerAddress, To: testMainAddress, Timestamp: created.Unix() + 60}
+
+	tests := []struct {
+		name     string
+		transfer services.TronTransaction
+		amount   int64
+		watched  []storer.Payment
+		want     string // ID of the matched payment, empty for none
+	}{
+		{
+			name:    "exact amount",
+			amount:  25_123_000,
+			watched: []storer.Payment{payment("a", "pending", 25_122_000, ""), payment("b", "pending", 25_123_000, "")},
+			want:    "b",
+		},
+		{
+			name:    "oldest pending payment first",
+			amount:  25_123_000,
+			watched: []storer.Payment{{ID: "new", Status: "pending", Amount: 25_123_000, Address: testMainAddress, CreatedAt: created.Add(time.Second)}, payment("old", "pending", 25_123_000, "")},
+			want:    "old",
+		},
+		{
+			name:    "partial payment to the shared address",
+			amount:  25_000_000,
+			watched: []storer.Payment{payment("a", "pending", 25_123_000, "")},
+		},
+		{
+			name:     "transfer older than the payment",
+			transfer: services.TronTransaction{From: testBuyerAddress, To: testMainAddress, Timestamp: created.Unix() - 1},
+			amount:   25_123_000,
+			watched:  []storer.Payment{payment("a", "pending", 25_123_000, "")},
+		},
+		{
+			name:     "transfer to another address",
+			transfer: services.TronTransaction{From: testBuyerAddress, To: testTokenAddress, Timestamp: created.Unix() + 60},
+			amount:   25_123_000,
+			watched:  []storer.Payment{payment("a", "pending", 25_123_000, "")},
+		},
+		{
+			name:     "any amount to a deposit address",
+			transfer: services.TronTransaction{From: testBuyerAddress, To: testDepositAddr, Timestamp: created.Unix() + 60},
+			amount:   1_000_000,
+			watched: []storer.Payment{{ID: "deposit", Status: "pending", Amount: 25_123_000, Address: testDepositAddr,
+				DerivationIndex: &index, CreatedAt: created}},
+			want: "deposit",
+		},
+		{
+			name:    "top-up from the same sender",
+			amount:  123_000,
+			watched: []storer.Payment{payment("a", "underpaid", 25_123_000, testBuyerAddress)},
+			want:    "a",
+		},
+		{
+			name:    "top-up from another sender",
+			amount:  123_000,
+			watched: []storer.Payment{payment("a", "underpaid", 25_123_000, testMainAddress)},
+		},
+		{
+			name:    "pending payment before an expired one",
+			amount:  25_123_000,
+			watched: []storer.Payment{payment("late", "expired", 25_123_000, ""), {ID: "open", Status: "pending", Amount: 25_123_000, Address: testMainAddress, CreatedAt: created.Add(time.Second)}},
+			want:    "open",
+		},
+		{
+			name:    "late payment",
+			amount:  25_123_000,
+			watched: []storer.Payment{payment("late", "expired", 25_123_000, "")},
+			want:    "late",
+		},
+		{
+			name:    "repeated payment from the same sender",
+			amount:  25_123_000,
+			watched: []storer.Payment{payment("paid", "confirming", 25_123_000, testBuyerAddress)},
+			want:    "paid",
+		},
+		{
+			name:    "same amount from another sender after the order was paid",
+			amount:  25_123_000,
+			watched: []storer.Payment{payment("paid", "image_sent", 25_123_000, testMainAddress)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.transfer.To == "" {
+				tt.transfer = transfer
+			}
+			got := ""
+			if match := matchTransfer(tt.transfer, tt.amount, tt.watched); match != nil {
+				got = match.ID
+			}
+			if got != tt.want {
+				t.Errorf("matched %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPendingPaymentsCreditsTransferOnce(t *testing.T) {
+	h, chain, tg := newTestTronHandler(t, config.TronConfig{Asset: "TRX"})
+	// An expired order with the same amount could also take the transfer as a late payment
+	saveTronPayment(t, h, storer.Payment{ID: "tron-expired", Amount: 25_123_000, Status: "expired", ExpiresAt: time.Now().Unix() - 600})
+	saveTestTronPayment(t, h, 25_123_000)
+
+	txID := chain.TransferTRX(testBuyerAddress, testMainAddress, 25_123_000)
+	chain.MineBlocks(1)
+
+	// The block is scanned again on the next pass, which must not credit it twice
+	for range 2 {
+		if err := h.checkPendingPayments(); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+	}
+
+	// Neither does a webhook notification for the same transfer
+	transfer, err := h.services.Tron.GetTransaction(txID)
+	if err != nil {
+		t.Fatalf("transaction: %v", err)
+	}
+	watched, _ := h.storer.GetWatchedTronPayments(time.Now().Add(-time.Hour))
+	if payment := h.creditTransfer(*transfer, watched); payment != nil {
+		t.Errorf("credited again: %+v", payment)
+	}
+	_, err = h.storer.CreditTronTransfer("tron-expired", &storer.TronTransfer{TxID: txID, Amount: 25_123_000}, h.transferStatus)
+	if !errors.Is(err, storer.ErrTransferClaimed) {
+		t.Errorf("crediting a claimed transfer = %v, want ErrTransferClaimed", err)
+	}
+
+	if payment := findTronPayment(t, h, "tron-test"); payment.Received != 25_123_000 || payment.Credit != 0 || payment.TxID != txID {
+		t.Errorf("paid order: %+v", payment)
+	}
+	if payment := findTronPayment(t, h, "tron-expired"); payment.Status != "expired" || payment.Received != 0 {
+		t.Errorf("expired order took the transfer: %+v", payment)
+	}
+	if n := strings.Count(tg.messages(testBuyerID), "Payment detected"); n != 1 {
+		t.Errorf("buyer told %d times about one transfer", n)
+	}
+}
+
+func TestCheckPendingPaymentsSettlesAmountsAndLatePayments(t *testing.T) {
+	now := time.Now().Unix()
+	deposit := int64(1)
+
+	tests := []struct {
+		name      string
+		payment   storer.Payment
+		transfers []int64 // sent one per pass, in sun
+		expire    bool    // let the order expire after the transfers
+		status    string
+		received  int64
+		credit    int64
+		buyer     string // part of what the buyer is told
+		admin     string // part of what the admins are told, empty when they hear nothing
+	}{
+		{
+			name:      "underpaid, then topped up",
+			payment:   storer.Payment{Amount: 25_000_000, Address: testDepositAddr, DerivationIndex: &deposit},
+			transfers: []int64{10_000_000, 15_000_000},
+			status:    "confirming",
+			received:  25_000_000,
+			buyer:     "Please send the remaining 15 TRX to the same address before",
+		},
+		{
+			name:      "underpaid when the order expires",
+			payment:   storer.Payment{Amount: 25_000_000, Address: testDepositAddr, DerivationIndex: &deposit},
+			transfers: []int64{10_000_000},
+			expire:    true,
+			status:    "review",
+			received:  10_000_000,
+			buyer:     "Your order expired with 10 of 25 TRX received",
+			admin:     "expired underpaid",
+		},
+		{
+			name:      "overpaid",
+			payment:   storer.Payment{Amount: 25_000_000, Address: testDepositAddr, DerivationIndex: &deposit},
+			transfers: []int64{30_000_000},
+			status:    "confirming",
+			received:  30_000_000,
+			credit:    5_000_000,
+			buyer:     "You paid 5 TRX more than the order price",
+			admin:     "overpaid: credit of 5 TRX",
+		},
+		{
+			name:      "underpaid on the shared address",
+			payment:   storer.Payment{Amount: 10_000_000},
+			transfers: []int64{9_500_000},
+			status:    "pending",
+			admin:     "matches no order: 9.5 TRX from " + testBuyerAddress,
+		},
+		{
+			name:      "late within the grace period",
+			payment:   storer.Payment{Amount: 25_123_000, ExpiresAt: now - 600},
+			transfers: []int64{25_123_000},
+			status:    "confirming",
+			received:  25_123_000,
+			buyer:     "arrived after the order expired, but it was still accepted",
+		},
+		{
+			name:      "late after the grace period",
+			payment:   storer.Payment{Amount: 25_123_000, ExpiresAt: now - 2*3600},
+			transfers: []int64{25_123_000},
+			status:    "review",
+			received:  25_123_000,
+			buyer:     "after your order expired",
+			admin:     "Late Tron payment",
+		},
+		{
+			name:      "late after the late window",
+			payment:   storer.Payment{Amount: 25_123_000, ExpiresAt: now - 73*3600},
+			transfers: []int64{25_123_000},
+			status:    "expired",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, chain, tg := newTestTronHandler(t, config.TronConfig{Asset: "TRX"})
+			tt.payment.ID = "tron-test"
+			saveTronPayment(t, h, tt.payment)
+			address := findTronPayment(t, h, "tron-test").Address
+
+			// The first pass expires orders that are already overdue
+			if err := h.checkPendingPayments(); err != nil {
+				t.Fatalf("first pass: %v", err)
+			}
+			for _, amount := range tt.transfers {
+				chain.TransferTRX(testBuyerAddress, address, amount)
+				chain.MineBlocks(1)
+				if err := h.checkPendingPayments(); err != nil {
+					t.Fatalf("scan: %v", err)
+				}
+			}
+			if tt.expire {
+				payment := findTronPayment(t, h, "tron-test")
+				payment.ExpiresAt = time.Now().Unix() - 1
+				h.storer.UpdateTronPayment(payment)
+				if err := h.checkPendingPayments(); err != nil {
+					t.Fatalf("pass after expiry: %v", err)
+				}
+			}
+
+			payment := findTronPayment(t, h, "tron-test")
+			if payment.Status != tt.status || payment.Received != tt.received || payment.Credit != tt.credit {
+				t.Errorf("status %s, received %d, credit %d; want %s, %d, %d",
+					payment.Status, payment.Received, payment.Credit, tt.status, tt.received, tt.credit)
+			}
+			if buyer := tg.messages(testBuyerID); !strings.Contains(buyer, tt.buyer) {
+				t.Errorf("buyer was not told %q:\n%s", tt.buyer, buyer)
+			}
+			admin := tg.messages(testAdminID)
+			if (tt.admin == "" && admin != "") || !strings.Contains(admin, tt.admin) {
+				t.Errorf("admins were told:\n%s\nwant %q", admin, tt.admin)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// BIP-32 public derivation for per-order Tron deposit addresses.
+// The server only ever holds the account-level extended public key
+// (m/44'/195'/0', 195 being Tron's BIP-44 coin type) and derives the
+// receiving chain m/44'/195'/0'/0/i from it, so no private key is needed.
+// Private derivation is only used by the sweep subcommand, which holds
+// the xprv while it signs and broadcasts, so it is a hot wallet while it runs.
+// Curve arithmetic is done by the decred secp256k1 package.
+
+const hardenedOffset = 0x80000000
+
+var (
+	xpubVersion = []byte{0x04, 0x88, 0xb2, 0x1e}
+	xprvVersion = []byte{0x04, 0x88, 0xad, 0xe4}
+)
+
+var ErrInvalidExtendedKey = errors.New("invalid extended key")
+
+// ExtendedPublicKey is a BIP-32 xpub
+type ExtendedPublicKey struct {
+	depth       byte
+	childNumber uint32
+	chainCode   []byte
+	key         *secp256k1.PublicKey
+}
+
+// ParseExtendedPublicKey decodes a Base58Check "xpub..." string
+func ParseExtendedPublicKey(xpub string) (*ExtendedPublicKey, error) {
+	raw, err := base58CheckDecode(xpub)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidExtendedKey, err)
+	}
+	if len(raw) != 78 {
+		return nil, fmt.Errorf("%w: %d bytes", ErrInvalidExtendedKey, len(raw))
+	}
+	if !bytes.Equal(raw[:4], xpubVersion) {
+		return nil, fmt.Errorf("%w: not an xpub (version %x)", ErrInvalidExtendedKey, raw[:4])
+	}
+
+	key, err := secp256k1.ParsePubKey(raw[45:78])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidExtendedKey, err)
+	}
+
+	k := &ExtendedPublicKey{
+		depth:       raw[4],
+		childNumber: binary.BigEndian.Uint32(raw[9:13]),
+		chainCode:   append([]byte{}, raw[13:45]...),
+		key:         key,
+	}
+	return k, nil
+}
+
+// Child derives the non-hardened child key at index (CKDpub)
+func (k *ExtendedPublicKey) Child(index uint32) (*ExtendedPublicKey, error) {
+	if index >= hardenedOffset {
+		return nil, fmt.Errorf("hardened child %d cannot be derived from a public key", index-hardenedOffset)
+	}
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(k.key.SerializeCompressed())
+	binary.Write(mac, binary.BigEndian, index)
+	sum := mac.Sum(nil)
+
+	// child = tweak·G + parent
+	var tweak secp256k1.ModNScalar
+	if overflow := tweak.SetByteSlice(sum[:32]); overflow {
+		return nil, fmt.Errorf("child %d is invalid, use the next index", index)
+	}
+	var tweakPoint, parent, child secp256k1.JacobianPoint
+	secp256k1.ScalarBaseMultNonConst(&tweak, &tweakPoint)
+	k.key.AsJacobian(&parent)
+	secp256k1.AddNonConst(&tweakPoint, &parent, &child)
+	if (child.X.IsZero() && child.Y.IsZero()) || child.Z.IsZero() {
+		return nil, fmt.Errorf("child %d is invalid, use the next index", index)
+	}
+	child.ToAffine()
+
+	derived := &ExtendedPublicKey{
+		depth:       k.depth + 1,
+		childNumber: index,
+		chainCode:   sum[32:],
+		key:         secp256k1.NewPublicKey(&child.X, &child.Y),
+	}
+	return derived, nil
+}
+
+// TronAddress returns the Tron address of this key
+func (k *ExtendedPublicKey) TronAddress() string {
+	return tronAddressFromPublicKey(k.key)
+}
+
+// DepositAddress derives the receiving address m/.../0/index from an account-level xpub
+func (k *ExtendedPublicKey) DepositAddress(index uint32) (string, error) {
+	external, err := k.Child(0)
+	if err != nil {
+		return "", err
+	}
+	child, err := external.Child(index)
+	if err != nil {
+		return "", err
+	}
+	return child.TronAddress(), nil
+}
+
+// ExtendedPrivateKey is a BIP-32 xprv
+type ExtendedPrivateKey struct {
+	depth       byte
+	childNumber uint32
+	chainCode   []byte
+	key         *secp256k1.PrivateKey
+}
+
+// ParseExtendedPrivateKey decodes a Base58Check "xprv..." string
+func ParseExtendedPrivateKey(xprv string) (*ExtendedPrivateKey, error) {
+	raw, err := base58CheckDecode(xprv)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidExtendedKey, err)
+	}
+	if len(raw) != 78 {
+		return nil, fmt.Errorf("%w: %d bytes", ErrInvalidExtendedKey, len(raw))
+	}
+	if !bytes.Equal(raw[:4], xprvVersion) {
+		return nil, fmt.Errorf("%w: not an xprv (version %x)", ErrInvalidExtendedKey, raw[:4])
+	}
+	if raw[45] != 0 {
+		return nil, fmt.Errorf("%w: malformed private key", ErrInvalidExtendedKey)
+	}
+
+	var key secp256k1.ModNScalar
+	if overflow := key.SetByteSlice(raw[46:78]); overflow || key.IsZero() {
+		return nil, fmt.Errorf("%w: private key out of range", ErrInvalidExtendedKey)
+	}
+
+	return &ExtendedPrivateKey{
+		depth:       raw[4],
+		childNumber: binary.BigEndian.Uint32(raw[9:13]),
+		chainCode:   append([]byte{}, raw[13:45]...),
+		key:         secp256k1.NewPrivateKey(&key),
+	}, nil
+}
+
+// Child derives the child key at index (CKDpriv); indexes from hardenedOffset are hardened
+func (k *ExtendedPrivateKey) Child(index uint32) (*ExtendedPrivateKey, error) {
+	mac := hmac.New(sha512.New, k.chainCode)
+	if index >= hardenedOffset {
+		mac.Write([]byte{0})
+		mac.Write(k.key.Serialize())
+	} else {
+		mac.Write(k.publicKey().SerializeCompressed())
+	}
+	binary.Write(mac, binary.BigEndian, index)
+	sum := mac.Sum(nil)
+
+	// child = tweak + parent (mod n)
+	var child secp256k1.ModNScalar
+	if overflow := child.SetByteSlice(sum[:32]); overflow {
+		return nil, fmt.Errorf("child %d is invalid, use the next index", index)
+	}
+	if child.Add(&k.key.Key).IsZero() {
+		return nil, fmt.Errorf("child %d is invalid, use the next index", index)
+	}
+
+	return &ExtendedPrivateKey{
+		depth:       k.depth + 1,
+		childNumber: index,
+		chainCode:   sum[32:],
+		key:         secp256k1.NewPrivateKey(&child),
+	}, nil
+}
+
+func (k *ExtendedPrivateKey) publicKey() *secp256k1.PublicKey {
+	return k.key.PubKey()
+}
+
+// TronAddress returns the Tron address of this key
+func (k *ExtendedPrivateKey) TronAddress() string {
+	return tronAddressFromPublicKey(k.publicKey())
+}
+
+// DepositKey derives the key of receiving address m/.../0/index from an account-level xprv
+func (k *ExtendedPrivateKey) DepositKey(index uint32) (*ExtendedPrivateKey, error) {
+	external, err := k.Child(0)
+	if err != nil {
+		return nil, err
+	}
+	return external.Child(index)
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+func TestKeccak256(t *testing.T) {
+	vectors := map[string]string{
+		"":    "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470",
+		"abc": "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45",
+	}
+	// 200 bytes spans two 136-byte blocks
+	long := make([]byte, 200)
+	for i := range long {
+		long[i] = 0xa3
+	}
+	vectors[string(long)] = "3a57666b048777f2c953dc4456f45a2588e1cb6f2da760122d530ac2ce607d4a"
+
+	for in, want := range vectors {
+		if got := hex.EncodeToString(keccak256([]byte(in))); got != want {
+			t.Errorf("keccak256(%d bytes) = %s, want %s", len(in), got, want)
+		}
+	}
+}
+
+// BIP-32 test vector 1, chain m/0H/1/2H and its public children
+func TestExtendedPublicKeyChild(t *testing.T) {
+	key, err := ParseExtendedPublicKey("xpub6D4BDPcP2GT577Vvch3R8wDkScZWzQzMMUm3PWbmWvVJrZwQY4VUNgqFJPMM3No2dFDFGTsxxpG5uJh7n7epu4trkrX7x7DogT5Uv6fcLW5")
+	if err != nil {
+		t.Fatalf("ParseExtendedPublicKey: %v", err)
+	}
+
+	steps := []struct {
+		index     uint32
+		publicKey string
+		chainCode string
+	}{
+		{2, "02e8445082a72f29b75ca48748a914df60622a609cacfce8ed0e35804560741d29", "cfb71883f01676f587d023cc53a35bc7f88f724b1f8c2892ac1275ac822a3edd"},
+		{1000000000, "022a471424da5e657499d1ff51cb43c47481a03b1e77f951fe64cec9f5a48f7011", "c783e67b921d2beb8f6b389cc646d7263b4145701dadd2161548a8b078e65e9e"},
+	}
+	for _, step := range steps {
+		key, err = key.Child(step.index)
+		if err != nil {
+			t.Fatalf("Child(%d): %v", step.index, err)
+		}
+		if got := hex.EncodeToString(key.key.SerializeCompressed()); got != step.publicKey {
+			t.Errorf("Child(%d) public key = %s, want %s", step.index, got, step.publicKey)
+		}
+		if got := hex.EncodeToString(key.chainCode); got != step.chainCode {
+			t.Errorf("Child(%d) chain code = %s, want %s", step.index, got, step.chainCode)
+		}
+	}
+
+	if _, err := key.Child(hardenedOffset); err == nil {
+		t.Errorf("hardened child derived from a public key")
+	}
+}
+
+// Account key m/44'/195'/0' of the mnemonic "abandon abandon ... about", as exported by Tron wallets
+func TestDepositAddress(t *testing.T) {
+	key, err := ParseExtendedPublicKey("xpub6D1AabNHCupeiLM65ZR9UStMhJ1vCpyV4XbZdyhMZBiJXALQtmn9p42VTQckoHVn8WNqS7dqnJokZHAHcHGoaQgmv8D45oNUKx6DZMNZBCd")
+	if err != nil {
+		t.Fatalf("ParseExtendedPublicKey: %v", err)
+	}
+
+	want := []string{
+		"TUEZSdKsoDHQMeZwihtdoBiN46zxhGWYdH",
+		"TSeJkUh4Qv67VNFwY8LaAxERygNdy6NQZK",
+		"TYJPRrdB5APNeRs4R7fYZSwW3TcrTKw2gx",
+	}
+	for index, address := range want {
+		got, err := key.DepositAddress(uint32(index))
+		if err != nil {
+			t.Fatalf("DepositAddress(%d): %v", index, err)
+		}
+		if got != address {
+			t.Errorf("DepositAddress(%d) = %s, want %s", index, got, address)
+		}
+	}
+}
+
+func TestParseExtendedPublicKeyErrors(t *testing.T) {
+	invalid := []string{
+		"",
+		"xpub6D1AabNHCupeiLM65ZR9UStMhJ1vCpyV4XbZdyhMZBiJXALQtmn9p42VTQckoHVn8WNqS7dqnJokZHAHcHGoaQgmv8D45oNUKx6DZMNZBCe", // checksum
+		"xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi", // private key
+		"TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t", // an address, not a key
+	}
+	for _, xpub := range invalid {
+		if _, err := ParseExtendedPublicKey(xpub); !errors.Is(err, ErrInvalidExtendedKey) {
+			t.Errorf("ParseExtendedPublicKey(%.12s...) error = %v, want ErrInvalidExtendedKey", xpub, err)
+		}
+	}
+}
+
+func TestExtendedPrivateKeyChild(t *testing.T) {
+	// BIP-32 test vector 1 master key, derived along m/0H/1/2H/2
+	key, err := ParseExtendedPrivateKey("xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi")
+	if err != nil {
+		t.Fatalf("ParseExtendedPrivateKey: %v", err)
+	}
+	for _, index := range []uint32{hardenedOffset, 1, hardenedOffset + 2, 2} {
+		if key, err = key.Child(index); err != nil {
+			t.Fatalf("Child(%d): %v", index, err)
+		}
+	}
+	if got := hex.EncodeToString(key.publicKey().SerializeCompressed()); got != "02e8445082a72f29b75ca48748a914df60622a609cacfce8ed0e35804560741d29" {
+		t.Errorf("m/0H/1/2H/2 public key = %s", got)
+	}
+
+	// Account key m/44'/195'/0' of "abandon ... about", the private side of TestDepositAddress
+	account, err := ParseExtendedPrivateKey("xprv9z1pB5qPNYGMVrGcyXt97Jwd9GBRoNFdhJfxqbHjzrBKeN1GMETuGFi1c73SQkP8kkKz5MVoMtLRcsDWggUcaPF32AXN4qNsNWBoJbaHcQ7")
+	if err != nil {
+		t.Fatalf("ParseExtendedPrivateKey: %v", err)
+	}
+	deposit, err := account.DepositKey(0)
+	if err != nil {
+		t.Fatalf("DepositKey: %v", err)
+	}
+	if got := hex.EncodeToString(deposit.key.Serialize()); got != "b5a4cea271ff424d7c31dc12a3e43e401df7a40d7412a15750f3f0b6b5449a28" {
+		t.Errorf("m/44'/195'/0'/0/0 private key = %s", got)
+	}
+	if got := deposit.TronAddress(); got != "TUEZSdKsoDHQMeZwihtdoBiN46zxhGWYdH" {
+		t.Errorf("m/44'/195'/0'/0/0 address = %s", got)
+	}
+
+	if _, err := ParseExtendedPrivateKey("xpub6D1AabNHCupeiLM65ZR9UStMhJ1vCpyV4XbZdyhMZBiJXALQtmn9p42VTQckoHVn8WNqS7dqnJokZHAHcHGoaQgmv8D45oNUKx6DZMNZBCd"); !errors.Is(err, ErrInvalidExtendedKey) {
+		t.Errorf("xpub accepted as xprv: %v", err)
+	}
+}
+
+func TestECDSASign(t *testing.T) {
+	// Deterministic nonce vector: private key 1, sha256("Satoshi Nakamoto")
+	hash := sha256.Sum256([]byte("Satoshi Nakamoto"))
+	sig := signTronHash(secp256k1.PrivKeyFromBytes([]byte{1}), hash[:])
+	want := "934b1ea10a4b3c1757e2b0c017d0b6143ce3c9a7e6a4a49860d7a6ab210ee3d8" +
+		"2442ce9d2b916064108014783e923ec36b49743e2ffa1c4496f01a512aafd9e5" + "1c"
+	if got := hex.EncodeToString(sig); got != want {
+		t.Errorf("signature = %s, want %s", got, want)
+	}
+
+	// Signer over the deposit key of TestDepositAddress
+	signer, err := NewLocalTronSigner("xprv9z1pB5qPNYGMVrGcyXt97Jwd9GBRoNFdhJfxqbHjzrBKeN1GMETuGFi1c73SQkP8kkKz5MVoMtLRcsDWggUcaPF32AXN4qNsNWBoJbaHcQ7", "")
+	if err != nil {
+		t.Fatalf("NewLocalTronSigner: %v", err)
+	}
+	txID, _ := hex.DecodeString("a7ed6fd0c8d4c1f4b0bfc4f2e4e94a5e8a2a8a7d4b6b87c0e51cf8e2c3d9f001")
+	sig, err = signer.SignDeposit(0, txID)
+	if err != nil {
+		t.Fatalf("SignDeposit: %v", err)
+	}
+	want = "053db101c05ce418aebe22f666074625c95906d3d6c128bb0aa0f5b51d9815f9" +
+		"4d65c53fc1ff109077a0727bce06ba0e45a6f8fb6c457952cdd5afe8049a7b5a" + "1b"
+	if got := hex.EncodeToString(sig); got != want {
+		t.Errorf("deposit signature = %s, want %s", got, want)
+	}
+	if _, err := signer.SignFee(txID); err == nil {
+		t.Errorf("signed with a fee wallet that is not configured")
+	}
+}
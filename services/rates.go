@@ -0,0 +1,226 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gobotcat/config"
+)
+
+// RateProvider returns how many US dollars one unit of a crypto asset is worth
+type RateProvider interface {
+	USDRate(asset string) (float64, error)
+}
+
+// RateQuote is a rate as handed out for an order
+type RateQuote struct {
+	Asset  string
+	Rate   float64 // USD per unit
+	Source string  // provider name, or "static" when the fallback was used
+	At     time.Time
+}
+
+// NewRatesFromConfig builds the configured provider behind a cache with the static rates as fallback
+func NewRatesFromConfig(cfg config.RatesConfig) *CachedRates {
+	var source RateProvider
+	switch cfg.Source {
+	case "coingecko":
+		source = NewCoinGeckoRates("https://api.coingecko.com")
+	case "binance":
+		source = NewBinanceRates("https://api.binance.com")
+	}
+	return NewCachedRates(cfg.Source, source, StaticRates(cfg.Static), cfg.CacheTTL)
+}
+
+// ===== Cache =====
+
+// CachedRates keeps each rate for ttl. When the source fails it serves the last rate for
+// up to another ttl, then falls back to the static rates. Requests for an asset while its rate
+// is being fetched wait for that fetch instead of starting another; other assets are not blocked.
+type CachedRates struct {
+	name     string
+	source   RateProvider // nil uses the static rates only
+	fallback StaticRates
+	ttl      time.Duration
+
+	mu       sync.Mutex
+	rates    map[string]RateQuote
+	fetching map[string]*rateFetch
+}
+
+// rateFetch is a request to the source in progress, done is closed once quote and err are set
+type rateFetch struct {
+	done  chan struct{}
+	quote RateQuote
+	err   error
+}
+
+func NewCachedRates(name string, source RateProvider, fallback StaticRates, ttl time.Duration) *CachedRates {
+	return &CachedRates{
+		name:     name,
+		source:   source,
+		fallback: fallback,
+		ttl:      ttl,
+		rates:    make(map[string]RateQuote),
+		fetching: make(map[string]*rateFetch),
+	}
+}
+
+// Quote returns the current USD rate of asset and where it came from
+func (c *CachedRates) Quote(asset string) (RateQuote, error) {
+	c.mu.Lock()
+	cached, ok := c.rates[asset]
+	if ok && time.Since(cached.At) < c.ttl {
+		c.mu.Unlock()
+		return cached, nil
+	}
+	if c.source == nil {
+		c.mu.Unlock()
+		return c.static(asset)
+	}
+
+	fetch, running := c.fetching[asset]
+	if !running {
+		fetch = &rateFetch{done: make(chan struct{})}
+		c.fetching[asset] = fetch
+	}
+	c.mu.Unlock()
+
+	if running {
+		<-fetch.done
+	} else {
+		// The request is made without holding the lock, so a slow source only delays this asset
+		rate, err := c.source.USDRate(asset)
+		if err == nil && rate <= 0 {
+			err = fmt.Errorf("invalid rate %v", rate)
+		}
+		fetch.quote, fetch.err = RateQuote{Asset: asset, Rate: rate, Source: c.name, At: time.Now()}, err
+		c.mu.Lock()
+		if err == nil {
+			c.rates[asset] = fetch.quote
+		}
+		delete(c.fetching, asset)
+		c.mu.Unlock()
+		close(fetch.done)
+	}
+
+	if fetch.err == nil {
+		return fetch.quote, nil
+	}
+	if !running {
+		log.Printf("[RATES] %s rate for %s unavailable: %v", c.name, asset, fetch.err)
+	}
+	if ok && time.Since(cached.At) < 2*c.ttl {
+		return cached, nil
+	}
+	return c.static(asset)
+}
+
+func (c *CachedRates) static(asset string) (RateQuote, error) {
+	rate, err := c.fallback.USDRate(asset)
+	if err != nil {
+		return RateQuote{}, err
+	}
+	return RateQuote{Asset: asset, Rate: rate, Source: "static", At: time.Now()}, nil
+}
+
+func (c *CachedRates) USDRate(asset string) (float64, error) {
+	quote, err := c.Quote(asset)
+	return quote.Rate, err
+}
+
+// ===== Static =====
+
+// StaticRates are fixed rates from configuration, e.g. {"TRX": 0.25, "USDT": 1}
+type StaticRates map[string]float64
+
+func (r StaticRates) USDRate(asset string) (float64, error) {
+	rate, ok := r[asset]
+	if !ok || rate <= 0 {
+		return 0, fmt.Errorf("no static rate for %s", asset)
+	}
+	return rate, nil
+}
+
+// ===== CoinGecko =====
+
+var coinGeckoIDs = map[string]string{
+	"TRX":  "tron",
+	"USDT": "tether",
+}
+
+// CoinGeckoRates reads /api/v3/simple/price, no API key needed
+type CoinGeckoRates struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewCoinGeckoRates(baseURL string) *CoinGeckoRates {
+	return &CoinGeckoRates{baseURL: baseURL, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (r *CoinGeckoRates) USDRate(asset string) (float64, error) {
+	id, ok := coinGeckoIDs[asset]
+	if !ok {
+		return 0, fmt.Errorf("coingecko: unknown asset %s", asset)
+	}
+
+	var result map[string]map[string]float64
+	if err := getJSON(r.client, r.baseURL+"/api/v3/simple/price?vs_currencies=usd&ids="+id, &result); err != nil {
+		return 0, fmt.Errorf("coingecko: %w", err)
+	}
+	rate, ok := result[id]["usd"]
+	if !ok {
+		return 0, fmt.Errorf("coingecko: no usd price for %s", id)
+	}
+	return rate, nil
+}
+
+// ===== Binance =====
+
+// BinanceRates reads the spot ticker against USDT, which it treats as one dollar
+type BinanceRates struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewBinanceRates(baseURL string) *BinanceRates {
+	return &BinanceRates{baseURL: baseURL, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (r *BinanceRates) USDRate(asset string) (float64, error) {
+	if asset == "USDT" {
+		return 1, nil
+	}
+
+	var result struct {
+		Symbol string `json:"symbol"`
+		Price  string `json:"price"`
+	}
+	if err := getJSON(r.client, r.baseURL+"/api/v3/ticker/price?symbol="+asset+"USDT", &result); err != nil {
+		return 0, fmt.Errorf("binance: %w", err)
+	}
+	rate, err := strconv.ParseFloat(result.Price, 64)
+	if err != nil {
+		return 0, fmt.Errorf("binance: bad price %q for %s", result.Price, result.Symbol)
+	}
+	return rate, nil
+}
+
+func getJSON(client *http.Client, url string, out interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testRates is a scripted RateProvider that counts its calls
+type testRates struct {
+	mu    sync.Mutex
+	rates map[string]float64
+	err   error
+	calls atomic.Int32
+	block chan struct{} // when set, TRX calls wait until it is closed
+}
+
+func (r *testRates) USDRate(asset string) (float64, error) {
+	r.calls.Add(1)
+	if r.block != nil && asset == "TRX" {
+		<-r.block
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return 0, r.err
+	}
+	return r.rates[asset], nil
+}
+
+func (r *testRates) set(rate float64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rates["TRX"] = rate
+	r.err = err
+}
+
+func TestCachedRatesQuote(t *testing.T) {
+	source := &testRates{rates: map[string]float64{"TRX": 0.3}}
+	rates := NewCachedRates("test", source, StaticRates{"TRX": 0.25}, time.Hour)
+
+	quote, err := rates.Quote("TRX")
+	if err != nil || quote.Rate != 0.3 || quote.Source != "test" {
+		t.Fatalf("first quote = %+v, err %v", quote, err)
+	}
+	source.set(0.4, nil)
+	if quote, _ = rates.Quote("TRX"); quote.Rate != 0.3 || source.calls.Load() != 1 {
+		t.Errorf("cached quote = %+v after %d calls", quote, source.calls.Load())
+	}
+
+	// Past the ttl the source is asked again; when it fails the last rate is kept for another ttl
+	rates.rates["TRX"] = RateQuote{Asset: "TRX", Rate: 0.3, Source: "test", At: time.Now().Add(-90 * time.Minute)}
+	source.set(0, errors.New("down"))
+	if quote, err = rates.Quote("TRX"); err != nil || quote.Rate != 0.3 || quote.Source != "test" {
+		t.Errorf("stale quote = %+v, err %v", quote, err)
+	}
+
+	// Then the static rate takes over
+	rates.rates["TRX"] = RateQuote{Asset: "TRX", Rate: 0.3, Source: "test", At: time.Now().Add(-3 * time.Hour)}
+	if quote, err = rates.Quote("TRX"); err != nil || quote.Rate != 0.25 || quote.Source != "static" {
+		t.Errorf("fallback quote = %+v, err %v", quote, err)
+	}
+
+	// A zero rate counts as a failure, and without a static rate there is no quote
+	source.set(0, nil)
+	if _, err := rates.Quote("USDT"); err == nil {
+		t.Errorf("quoted an asset with no rate anywhere")
+	}
+
+	source.set(0.5, nil)
+	if quote, err = rates.Quote("TRX"); err != nil || quote.Rate != 0.5 {
+		t.Errorf("recovered quote = %+v, err %v", quote, err)
+	}
+}
+
+func TestCachedRatesStaticOnly(t *testing.T) {
+	rates := NewCachedRates("static", nil, StaticRates{"USDT": 1}, time.Minute)
+	if quote, err := rates.Quote("USDT"); err != nil || quote.Rate != 1 || quote.Source != "static" {
+		t.Errorf("quote = %+v, err %v", quote, err)
+	}
+	if _, err := rates.Quote("TRX"); err == nil {
+		t.Errorf("quoted TRX without a static rate")
+	}
+}
+
+func TestCachedRatesConcurrentFetch(t *testing.T) {
+	source := &testRates{rates: map[string]float64{"TRX": 0.3, "USDT": 1}, block: make(chan struct{})}
+	rates := NewCachedRates("test", source, StaticRates{}, time.Hour)
+
+	var wg sync.WaitGroup
+	quotes := make([]RateQuote, 5)
+	for i := range quotes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			quotes[i], _ = rates.Quote("TRX")
+		}()
+	}
+
+	// A slow source for TRX does not hold up quotes of other assets
+	done := make(chan struct{})
+	go func() {
+		rates.Quote("USDT")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("USDT quote blocked while the TRX rate was being fetched")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(source.block)
+	wg.Wait()
+
+	// One call for USDT, one shared by every TRX quote
+	if calls := source.calls.Load(); calls != 2 {
+		t.Errorf("source called %d times for concurrent quotes", calls)
+	}
+	for i, quote := range quotes {
+		if quote.Rate != 0.3 {
+			t.Errorf("quote %d = %+v", i, quote)
+		}
+	}
+}
+
+func TestRateProviders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/simple/price":
+			fmt.Fprintf(w, `{"%s":{"usd":0.2871}}`, r.URL.Query().Get("ids"))
+		case "/api/v3/ticker/price":
+			fmt.Fprintf(w, `{"symbol":"%s","price":"0.28700000"}`, r.URL.Query().Get("symbol"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	if rate, err := NewCoinGeckoRates(server.URL).USDRate("TRX"); err != nil || rate != 0.2871 {
+		t.Errorf("coingecko TRX = %v, err %v", rate, err)
+	}
+	if _, err := NewCoinGeckoRates(server.URL).USDRate("BTC"); err == nil {
+		t.Errorf("coingecko quoted an unknown asset")
+	}
+	if rate, err := NewBinanceRates(server.URL).USDRate("TRX"); err != nil || rate != 0.287 {
+		t.Errorf("binance TRX = %v, err %v", rate, err)
+	}
+	if rate, err := NewBinanceRates("http://127.0.0.1:0").USDRate("USDT"); err != nil || rate != 1 {
+		t.Errorf("binance USDT = %v, err %v", rate, err)
+	}
+}
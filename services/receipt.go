@@ -0,0 +1,171 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"gobotcat/config"
+)
+
+// ReceiptService renders PDF receipts locally, without any third-party service
+type ReceiptService struct {
+	seller config.SellerInfo
+}
+
+// Receipt holds everything printed on a receipt
+type Receipt struct {
+	InvoiceNumber int64
+	Date          time.Time
+	Buyer         string // Telegram user, e.g. "@name (123456)"
+	Item          string
+	Amount        string // total paid, formatted, e.g. "39.99"
+	Price         string // optional item price before the discount, formatted like Amount
+	Currency      string // e.g. "USD", "XTR" or "TRX"
+	Provider      string // e.g. "Stripe" or "Tron"
+	Reference     string // Stripe session ID, Telegram charge ID or Tron TxID
+	Discount      string // optional, formatted like Amount
+	Tip           string // optional, formatted like Amount
+}
+
+func NewReceiptService(seller config.SellerInfo) *ReceiptService {
+	return &ReceiptService{seller: seller}
+}
+
+// FormatInvoiceNumber returns the printed form of an invoice number, e.g. INV-000042
+func FormatInvoiceNumber(number int64) string {
+	return fmt.Sprintf("INV-%06d", number)
+}
+
+// Generate renders a one-page A4 PDF receipt
+func (s *ReceiptService) Generate(r Receipt) []byte {
+	page := &pdfPage{}
+
+	page.text(50, 780, 20, true, "Receipt")
+	page.text(400, 784, 11, true, FormatInvoiceNumber(r.InvoiceNumber))
+	page.text(400, 768, 10, false, r.Date.UTC().Format("2006-01-02 15:04 UTC"))
+
+	y := 730.0
+	page.text(50, y, 11, true, "Seller")
+	for _, line := range []string{s.seller.Name, s.seller.Address, s.seller.Email, s.seller.TaxID} {
+		if line == "" {
+			continue
+		}
+		y -= 15
+		page.text(50, y, 10, false, line)
+	}
+
+	page.text(320, 730, 11, true, "Buyer")
+	page.text(320, 715, 10, false, r.Buyer)
+
+	y -= 40
+	page.line(50, y+14, 545, y+14)
+	page.text(50, y, 11, true, "Item")
+	page.text(420, y, 11, true, "Amount")
+	page.line(50, y-6, 545, y-6)
+
+	y -= 24
+	price := r.Price
+	if price == "" {
+		price = r.Amount
+	}
+	page.text(50, y, 10, false, r.Item)
+	page.text(420, y, 10, false, price+" "+r.Currency)
+	if r.Discount != "" {
+		y -= 16
+		page.text(50, y, 10, false, "Discount")
+		page.text(420, y, 10, false, "-"+r.Discount+" "+r.Currency)
+	}
+	if r.Tip != "" {
+		y -= 16
+		page.text(50, y, 10, false, "of which tip")
+		page.text(420, y, 10, false, r.Tip+" "+r.Currency)
+	}
+
+	y -= 12
+	page.line(50, y, 545, y)
+	y -= 18
+	page.text(50, y, 11, true, "Total paid")
+	page.text(420, y, 11, true, r.Amount+" "+r.Currency)
+
+	y -= 40
+	page.text(50, y, 11, true, "Payment")
+	y -= 15
+	page.text(50, y, 10, false, "Provider: "+r.Provider)
+	y -= 15
+	page.text(50, y, 9, false, "Reference: "+r.Reference)
+
+	return page.render()
+}
+
+// ===== Minimal PDF writer =====
+
+// pdfPage collects drawing operators for a single A4 page using the standard Helvetica fonts
+type pdfPage struct {
+	content bytes.Buffer
+}
+
+func (p *pdfPage) text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&p.content, "BT /%s %.0f Tf %.1f %.1f Td (%s) Tj ET\n", font, size, x, y, pdfEscape(s))
+}
+
+func (p *pdfPage) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&p.content, "0.5 w %.1f %.1f m %.1f %.1f l S\n", x1, y1, x2, y2)
+}
+
+func (p *pdfPage) render() []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 4 0 R /F2 5 0 R >> >> /Contents 6 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.content.Len(), p.content.String()),
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return out.Bytes()
+}
+
+// pdfEscape converts s to a WinAnsi PDF string body; characters outside WinAnsi become '?'
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r == '€':
+			b.WriteString(`\200`)
+		case r == '—':
+			b.WriteString(`\227`)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, `\%03o`, r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"gobotcat/config"
+)
+
+func TestGenerateReceipt(t *testing.T) {
+	svc := NewReceiptService(config.SellerInfo{Name: "GoBotCat Ltd", Email: "billing@example.com"})
+
+	pdf := svc.Generate(Receipt{
+		InvoiceNumber: 42,
+		Date:          time.Date(2025, 12, 1, 10, 30, 0, 0, time.UTC),
+		Buyer:         "@cat (123)",
+		Item:          "Image Pack (5 photos)",
+		Amount:        "35.99",
+		Currency:      "EUR",
+		Provider:      "Stripe",
+		Reference:     "cs_test_(1)",
+	})
+
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatalf("not a PDF: %q", pdf[:20])
+	}
+
+	for _, want := range []string{"(INV-000042)", "(GoBotCat Ltd)", "(35.99 EUR)", `(Reference: cs_test_\(1\))`} {
+		if !bytes.Contains(pdf, []byte(want)) {
+			t.Errorf("receipt is missing %s", want)
+		}
+	}
+
+	// every xref entry must point at its "N 0 obj" header
+	match := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(pdf)
+	if match == nil {
+		t.Fatal("no startxref")
+	}
+	xref, _ := strconv.Atoi(string(match[1]))
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(pdf[xref:], -1)
+	if len(entries) != 6 {
+		t.Fatalf("xref has %d objects, want 6", len(entries))
+	}
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		header := fmt.Sprintf("%d 0 obj", i+1)
+		if !bytes.HasPrefix(pdf[offset:], []byte(header)) {
+			t.Errorf("xref entry %d points at %q", i+1, pdf[offset:offset+10])
+		}
+	}
+}
+
+func TestGenerateReceiptWithDiscount(t *testing.T) {
+	svc := NewReceiptService(config.SellerInfo{Name: "GoBotCat Ltd"})
+
+	pdf := svc.Generate(Receipt{
+		InvoiceNumber: 7,
+		Item:          "Image Pack",
+		Price:         "9.99",
+		Discount:      "2.00",
+		Amount:        "7.99",
+		Currency:      "USD",
+	})
+
+	// Item - Discount = Total paid
+	for _, want := range []string{"(Image Pack) Tj", "(9.99 USD)", "(-2.00 USD)", "(Total paid)", "(7.99 USD)"} {
+		if !bytes.Contains(pdf, []byte(want)) {
+			t.Errorf("receipt is missing %s", want)
+		}
+	}
+	if bytes.Count(pdf, []byte("(7.99 USD)")) != 1 {
+		t.Errorf("the discounted total is printed on the item line too")
+	}
+}
+
+func TestPDFEscape(t *testing.T) {
+	tests := map[string]string{
+		`a(b)c\d`: `a\(b\)c\\d`,
+		"€5":      `\2005`,
+		"Müller":  `M\374ller`,
+		"zł":      "z?",
+	}
+	for in, want := range tests {
+		if got := pdfEscape(in); got != want {
+			t.Errorf("pdfEscape(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
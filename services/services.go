@@ -3,26 +3,44 @@ package services
 import (
 	"log"
 
+	"github.com/stripe/stripe-go/v78"
 	"gobotcat/config"
 )
 
 type Services struct {
-	Stripe   *StripeService
-	Tron     *TronService
-	Telegram *TelegramService
+	Stripe         *StripeService            // default account, used for checkout
+	StripeAccounts map[string]*StripeService // every configured account by endpoint name
+	Tron           *TronService
+	Telegram       *TelegramService
+	Receipts       *ReceiptService
+	Rates          *CachedRates
 }
 
 func NewServicesFromConfig(cfg *config.Config) *Services {
-	stripeService := NewStripeService(cfg.StripeSecret)
-	tronService := NewTronService(cfg.TronAPIKey, cfg.TronMainAddress)
-	telegramService, err := NewTelegramService(cfg.TelegramKey)
+	var stripeBackends *stripe.Backends
+	if cfg.StripeAPIURL != "" {
+		stripeBackends = NewStripeBackends(cfg.StripeAPIURL)
+	}
+	stripeAccounts := make(map[string]*StripeService, len(cfg.StripeEndpoints))
+	for _, endpoint := range cfg.StripeEndpoints {
+		stripeAccounts[endpoint.Name] = NewStripeServiceWithBackends(endpoint.SecretKey, stripeBackends)
+	}
+	stripeService := stripeAccounts[cfg.StripeEndpoints[0].Name]
+	tronService, err := NewTronService(cfg.TronAPIKey, cfg.TronMainAddress, cfg.Tron)
+	if err != nil {
+		log.Fatalf("Failed to initialize Tron service: %v", err)
+	}
+	telegramService, err := NewTelegramService(cfg.TelegramKey, cfg.TelegramProviderToken)
 	if err != nil {
 		log.Fatalf("Failed to initialize Telegram bot: %v", err)
 	}
 
 	return &Services{
-		Stripe:   stripeService,
-		Tron:     tronService,
-		Telegram: telegramService,
+		Stripe:         stripeService,
+		StripeAccounts: stripeAccounts,
+		Tron:           tronService,
+		Telegram:       telegramService,
+		Receipts:       NewReceiptService(cfg.Seller),
+		Rates:          NewRatesFromConfig(cfg.Rates),
 	}
 }
@@ -1,34 +1,75 @@
 package services
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/stripe/stripe-go/v78"
-	"github.com/stripe/stripe-go/v78/checkout/session"
+	"github.com/stripe/stripe-go/v78/client"
 	"github.com/stripe/stripe-go/v78/webhook"
 )
 
 type StripeService struct {
 	secretKey string
+	client    *client.API // per-service client, no package-global stripe.Key
 }
 
 func NewStripeService(secretKey string) *StripeService {
-	stripe.Key = secretKey // Required by stripe-go SDK initialization
+	return NewStripeServiceWithBackends(secretKey, nil)
+}
+
+// NewStripeServiceWithBackends creates a StripeService on custom backends,
+// e.g. a local stripe-mock. nil backends use the real Stripe API.
+func NewStripeServiceWithBackends(secretKey string, backends *stripe.Backends) *StripeService {
 	return &StripeService{
 		secretKey: secretKey,
+		client:    client.New(secretKey, backends),
 	}
 }
 
+// NewStripeBackends points every Stripe backend at baseURL, e.g. http://localhost:12111 for stripe-mock
+func NewStripeBackends(baseURL string) *stripe.Backends {
+	config := &stripe.BackendConfig{URL: stripe.String(baseURL)}
+	return &stripe.Backends{
+		API:     stripe.GetBackendWithConfig(stripe.APIBackend, config),
+		Connect: stripe.GetBackendWithConfig(stripe.ConnectBackend, config),
+		Uploads: stripe.GetBackendWithConfig(stripe.UploadsBackend, config),
+	}
+}
+
+// CheckoutRequest describes a single Stripe checkout for a Telegram user
+type CheckoutRequest struct {
+	UserID           string            // Telegram user ID, sent as ClientReferenceID
+	CustomerID       string            // Stripe Customer linked to the user
+	Amount           int64             // Pack price in the currency's smallest unit, after bot-side discounts
+	Currency         string            // ISO code, lowercase; defaults to usd
+	Locale           string            // Checkout page language, e.g. "de" or "pt-BR"; defaults to auto
+	PhotoCount       int64             // Number of photos in the pack
+	PromotionCodeID  string            // Stripe promotion code applied up front (promo_...)
+	NoPromotionCodes bool              // Hide the promotion code field, e.g. when a bot-side discount was applied
+	Metadata         map[string]string // Copied to the Checkout Session
+}
+
 // Create Payment Session
-func (s *StripeService) CreatePaymentSession(userID string, amount int64, returnURL string) (string, error) {
+func (s *StripeService) CreatePaymentSession(req CheckoutRequest, returnURL string) (string, error) {
+	currency, locale := req.Currency, req.Locale
+	if currency == "" {
+		currency = string(stripe.CurrencyUSD)
+	}
+	if locale == "" {
+		locale = "auto"
+	}
+
 	params := &stripe.CheckoutSessionParams{
 		PaymentMethodTypes: stripe.StringSlice([]string{"card"}),
 		LineItems: []*stripe.CheckoutSessionLineItemParams{
 			{
 				PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
-					Currency: stripe.String("usd"),
+					Currency: stripe.String(currency),
 					ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
-						Name: stripe.String("Image Pack"),
+						Name: stripe.String(PackName(req.PhotoCount)),
 					},
-					UnitAmount: stripe.Int64(amount),
+					UnitAmount: stripe.Int64(req.Amount),
 				},
 				Quantity: stripe.Int64(1),
 			},
@@ -36,10 +77,24 @@ func (s *StripeService) CreatePaymentSession(userID string, amount int64, return
 		Mode:       stripe.String(string(stripe.CheckoutSessionModePayment)),
 		SuccessURL: stripe.String(returnURL + "/payment-success"),
 		CancelURL:  stripe.String(returnURL + "/payment-canceled"),
-		ClientReferenceID: stripe.String(userID),
+		ClientReferenceID: stripe.String(req.UserID),
+		Metadata:          req.Metadata,
+		Locale:            stripe.String(locale),
+	}
+	if req.CustomerID != "" {
+		params.Customer = stripe.String(req.CustomerID)
 	}
 
-	sess, err := session.New(params)
+	// Stripe rejects sessions that set both discounts and allow_promotion_codes
+	if req.PromotionCodeID != "" {
+		params.Discounts = []*stripe.CheckoutSessionDiscountParams{
+			{PromotionCode: stripe.String(req.PromotionCodeID)},
+		}
+	} else if !req.NoPromotionCodes {
+		params.AllowPromotionCodes = stripe.Bool(true)
+	}
+
+	sess, err := s.client.CheckoutSessions.New(params)
 	if err != nil {
 		return "", err
 	}
@@ -47,12 +102,110 @@ func (s *StripeService) CreatePaymentSession(userID string, amount int64, return
 	return sess.URL, nil
 }
 
-// ValidateWebhookSignature validates the webhook signature
-func (s *StripeService) ValidateWebhookSignature(body []byte, sig string, endpointSecret string) ([]byte, error) {
-	event, err := webhook.ConstructEvent(body, sig, endpointSecret)
-	if err != nil {
+// PackName is the product name shown at checkout and on receipts
+func PackName(photoCount int64) string {
+	if photoCount <= 1 {
+		return "Image Pack"
+	}
+	return fmt.Sprintf("Image Pack (%d photos)", photoCount)
+}
+
+// FindPromotionCode looks up an active Stripe promotion code by its customer-facing code
+func (s *StripeService) FindPromotionCode(code string) (*stripe.PromotionCode, error) {
+	params := &stripe.PromotionCodeListParams{
+		Active: stripe.Bool(true),
+		Code:   stripe.String(code),
+	}
+	params.Limit = stripe.Int64(1)
+
+	iter := s.client.PromotionCodes.List(params)
+	if iter.Next() {
+		return iter.PromotionCode(), nil
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("promotion code %q not found", code)
+}
+
+// GetSession retrieves a Checkout Session with its customer expanded
+func (s *StripeService) GetSession(sessionID string) (*stripe.CheckoutSession, error) {
+	params := &stripe.CheckoutSessionParams{}
+	params.AddExpand("customer")
+	return s.client.CheckoutSessions.Get(sessionID, params)
+}
+
+// ListSessions returns all Checkout Sessions created in [from, to)
+func (s *StripeService) ListSessions(from, to time.Time) ([]*stripe.CheckoutSession, error) {
+	params := &stripe.CheckoutSessionListParams{
+		CreatedRange: &stripe.RangeQueryParams{
+			GreaterThanOrEqual: from.Unix(),
+			LesserThan:         to.Unix(),
+		},
+	}
+	params.Limit = stripe.Int64(100)
+
+	var sessions []*stripe.CheckoutSession
+	iter := s.client.CheckoutSessions.List(params)
+	for iter.Next() {
+		sessions = append(sessions, iter.CheckoutSession())
+	}
+	if err := iter.Err(); err != nil {
 		return nil, err
 	}
 
-	return event.Data.Raw, nil
+	return sessions, nil
+}
+
+// CreateCustomer creates a Stripe Customer for a Telegram user
+func (s *StripeService) CreateCustomer(telegramID, username string) (string, error) {
+	params := &stripe.CustomerParams{
+		Description: stripe.String("Telegram user " + telegramID),
+	}
+	if username != "" {
+		params.Name = stripe.String("@" + username)
+	}
+	params.AddMetadata("telegram_id", telegramID)
+	params.AddMetadata("telegram_username", username)
+
+	cust, err := s.client.Customers.New(params)
+	if err != nil {
+		return "", err
+	}
+
+	return cust.ID, nil
+}
+
+// UpdateCustomerUsername keeps the Telegram username on the Stripe Customer in sync
+func (s *StripeService) UpdateCustomerUsername(customerID, username string) error {
+	params := &stripe.CustomerParams{}
+	if username != "" {
+		params.Name = stripe.String("@" + username)
+	}
+	params.AddMetadata("telegram_username", username)
+
+	_, err := s.client.Customers.Update(customerID, params)
+	return err
+}
+
+// GetCustomer retrieves a Stripe Customer by ID
+func (s *StripeService) GetCustomer(customerID string) (*stripe.Customer, error) {
+	return s.client.Customers.Get(customerID, nil)
+}
+
+// ConstructWebhookEvent verifies the signature against each active secret in turn
+// and returns the event with the index of the secret that matched
+func (s *StripeService) ConstructWebhookEvent(body []byte, sig string, secrets []string) (stripe.Event, int, error) {
+	err := fmt.Errorf("no webhook secret configured")
+	for i, secret := range secrets {
+		var event stripe.Event
+		event, err = webhook.ConstructEventWithOptions(body, sig, secret, webhook.ConstructEventOptions{
+			IgnoreAPIVersionMismatch: true,
+		})
+		if err == nil {
+			return event, i, nil
+		}
+	}
+	return stripe.Event{}, -1, err
 }
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/webhook"
+)
+
+// newTestStripeService starts a local stand-in for the Stripe API that answers
+// checkout session creation and records the last request form
+func newTestStripeService(t *testing.T) (*StripeService, *url.Values) {
+	t.Helper()
+
+	form := &url.Values{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/checkout/sessions" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"type":"invalid_request_error","message":"unexpected request"}}`)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("failed to parse form: %v", err)
+		}
+		*form = r.PostForm
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"cs_test_123","object":"checkout.session","url":"https://checkout.stripe.com/c/pay/cs_test_123"}`)
+	}))
+	t.Cleanup(server.Close)
+
+	return NewStripeServiceWithBackends("sk_test_123", NewStripeBackends(server.URL)), form
+}
+
+func TestCreatePaymentSession(t *testing.T) {
+	svc, form := newTestStripeService(t)
+
+	paymentURL, err := svc.CreatePaymentSession(CheckoutRequest{
+		UserID:     "42",
+		CustomerID: "cus_123",
+		Amount:     3599,
+		Currency:   "eur",
+		Locale:     "de",
+		PhotoCount: 5,
+		Metadata:   map[string]string{"photo_count": "5"},
+	}, "https://example.com")
+	if err != nil {
+		t.Fatalf("CreatePaymentSession: %v", err)
+	}
+	if paymentURL != "https://checkout.stripe.com/c/pay/cs_test_123" {
+		t.Errorf("url = %q", paymentURL)
+	}
+
+	want := map[string]string{
+		"client_reference_id":                           "42",
+		"customer":                                      "cus_123",
+		"line_items[0][price_data][unit_amount]":        "3599",
+		"line_items[0][price_data][currency]":           "eur",
+		"locale":                                        "de",
+		"line_items[0][price_data][product_data][name]": "Image Pack (5 photos)",
+		"line_items[0][quantity]":                       "1",
+		"metadata[photo_count]":                         "5",
+		"allow_promotion_codes":                         "true",
+		"success_url":                                   "https://example.com/payment-success",
+	}
+	for key, value := range want {
+		if got := form.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+}
+
+func TestCreatePaymentSessionWithPromotionCode(t *testing.T) {
+	svc, form := newTestStripeService(t)
+
+	_, err := svc.CreatePaymentSession(CheckoutRequest{
+		UserID:          "42",
+		Amount:          999,
+		PromotionCodeID: "promo_123",
+	}, "https://example.com")
+	if err != nil {
+		t.Fatalf("CreatePaymentSession: %v", err)
+	}
+
+	if got := form.Get("discounts[0][promotion_code]"); got != "promo_123" {
+		t.Errorf("discounts[0][promotion_code] = %q", got)
+	}
+	if form.Has("allow_promotion_codes") {
+		t.Errorf("allow_promotion_codes must not be sent together with discounts")
+	}
+	if form.Has("customer") {
+		t.Errorf("customer sent for a request without CustomerID")
+	}
+	if got := form.Get("line_items[0][price_data][currency]"); got != "usd" {
+		t.Errorf("default currency = %q, want usd", got)
+	}
+	if got := form.Get("locale"); got != "auto" {
+		t.Errorf("default locale = %q, want auto", got)
+	}
+}
+
+func TestCreatePaymentSessionWithoutPromotionCodes(t *testing.T) {
+	svc, form := newTestStripeService(t)
+
+	// The bot already discounted the amount, so Stripe codes must not be stacked on top
+	_, err := svc.CreatePaymentSession(CheckoutRequest{
+		UserID:           "42",
+		Amount:           799,
+		NoPromotionCodes: true,
+		Metadata:         map[string]string{"promo_source": "bot", "bot_discount": "200"},
+	}, "https://example.com")
+	if err != nil {
+		t.Fatalf("CreatePaymentSession: %v", err)
+	}
+
+	if form.Has("allow_promotion_codes") || form.Has("discounts[0][promotion_code]") {
+		t.Errorf("promotion codes allowed after a bot-side discount: %v", *form)
+	}
+	if got := form.Get("line_items[0][price_data][unit_amount]"); got != "799" {
+		t.Errorf("unit_amount = %q, want 799", got)
+	}
+}
+
+func TestConstructWebhookEventWithRotatedSecrets(t *testing.T) {
+	svc := NewStripeServiceWithBackends("sk_test_123", NewStripeBackends("http://127.0.0.1:0"))
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":          "evt_test_123",
+		"object":      "event",
+		"type":        "checkout.session.completed",
+		"api_version": stripe.APIVersion,
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"id":                  "cs_test_123",
+				"object":              "checkout.session",
+				"client_reference_id": "42",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{
+		Payload:   payload,
+		Secret:    "whsec_old",
+		Timestamp: time.Now(),
+	})
+
+	event, index, err := svc.ConstructWebhookEvent(signed.Payload, signed.Header, []string{"whsec_new", "whsec_old"})
+	if err != nil {
+		t.Fatalf("event signed with an active secret rejected: %v", err)
+	}
+	if index != 1 || event.ID != "evt_test_123" {
+		t.Errorf("matched secret #%d, event %q", index, event.ID)
+	}
+	var sess stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &sess); err != nil {
+		t.Fatalf("failed to parse session: %v", err)
+	}
+	if sess.ID != "cs_test_123" || sess.ClientReferenceID != "42" {
+		t.Errorf("session = %s / %s", sess.ID, sess.ClientReferenceID)
+	}
+
+	if _, _, err := svc.ConstructWebhookEvent(signed.Payload, signed.Header, []string{"whsec_new"}); err == nil {
+		t.Errorf("event accepted after its secret was retired")
+	}
+	if _, _, err := svc.ConstructWebhookEvent(signed.Payload, signed.Header, nil); err == nil {
+		t.Errorf("event accepted with no secrets configured")
+	}
+
+	tampered := []byte(string(signed.Payload) + " ")
+	if _, _, err := svc.ConstructWebhookEvent(tampered, signed.Header, []string{"whsec_new", "whsec_old"}); err == nil {
+		t.Errorf("event accepted for a tampered payload")
+	}
+
+	stale := webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{
+		Payload:   payload,
+		Secret:    "whsec_old",
+		Timestamp: time.Now().Add(-time.Hour),
+	})
+	if _, _, err := svc.ConstructWebhookEvent(stale.Payload, stale.Header, []string{"whsec_new", "whsec_old"}); err == nil {
+		t.Errorf("event accepted outside the tolerance window")
+	}
+}
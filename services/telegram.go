@@ -1,20 +1,32 @@
 package services
 
 import (
+	"log"
+
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+// StarsCurrency is the currency code of Telegram Stars
+const StarsCurrency = "XTR"
+
 type TelegramService struct {
-	bot *tgbotapi.BotAPI
+	bot           *tgbotapi.BotAPI
+	providerToken string // payment provider token from @BotFather, used for sendInvoice
 }
 
-func NewTelegramService(token string) (*TelegramService, error) {
-	bot, err := tgbotapi.NewBotAPI(token)
+func NewTelegramService(token, providerToken string) (*TelegramService, error) {
+	return NewTelegramServiceWithEndpoint(token, providerToken, tgbotapi.APIEndpoint)
+}
+
+// NewTelegramServiceWithEndpoint talks to the Bot API at apiEndpoint, formatted like tgbotapi.APIEndpoint
+// with the token and method, e.g. a local stand-in in tests
+func NewTelegramServiceWithEndpoint(token, providerToken, apiEndpoint string) (*TelegramService, error) {
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint(token, apiEndpoint)
 	if err != nil {
 		return nil, err
 	}
 
-	return &TelegramService{bot: bot}, nil
+	return &TelegramService{bot: bot, providerToken: providerToken}, nil
 }
 
 // SendImage sends an image by URL
@@ -26,6 +38,30 @@ func (t *TelegramService) SendImage(chatID int64, imageURL string, caption strin
 	return err
 }
 
+// SendImages sends several photos by file ID as one media group (2 to 10 photos)
+func (t *TelegramService) SendImages(chatID int64, fileIDs []string, caption string) error {
+	media := make([]interface{}, 0, len(fileIDs))
+	for i, fileID := range fileIDs {
+		photo := tgbotapi.NewInputMediaPhoto(tgbotapi.FileID(fileID))
+		if i == 0 {
+			photo.Caption = caption // Telegram shows the first item's caption for the whole group
+		}
+		media = append(media, photo)
+	}
+
+	_, err := t.bot.SendMediaGroup(tgbotapi.NewMediaGroup(chatID, media))
+	return err
+}
+
+// SendDocument uploads a file from memory, e.g. a generated PDF
+func (t *TelegramService) SendDocument(chatID int64, filename string, data []byte, caption string) error {
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: filename, Bytes: data})
+	doc.Caption = caption
+
+	_, err := t.bot.Send(doc)
+	return err
+}
+
 // SendMessage sends a text message
 func (t *TelegramService) SendMessage(chatID int64, message string) error {
 	msg := tgbotapi.NewMessage(chatID, message)
@@ -33,17 +69,61 @@ func (t *TelegramService) SendMessage(chatID int64, message string) error {
 	return err
 }
 
+// HasPaymentProvider reports whether native Telegram invoices are configured
+func (t *TelegramService) HasPaymentProvider() bool {
+	return t.providerToken != ""
+}
+
+// SendInvoice sends a native Telegram invoice for a single item, amount in the currency's smallest units
+func (t *TelegramService) SendInvoice(chatID int64, title, description, payload, currency string, amount int64) error {
+	return t.sendInvoice(chatID, title, description, payload, t.providerToken, currency, amount)
+}
+
+// SendStarsInvoice sends an invoice payable in Telegram Stars (XTR), which needs no payment provider
+func (t *TelegramService) SendStarsInvoice(chatID int64, title, description, payload string, stars int64) error {
+	return t.sendInvoice(chatID, title, description, payload, "", StarsCurrency, stars)
+}
+
+// RefundStarPayment returns a Stars payment to the user; the library has no config for this method yet
+func (t *TelegramService) RefundStarPayment(userID int64, chargeID string) error {
+	params := tgbotapi.Params{}
+	params.AddNonZero64("user_id", userID)
+	params.AddNonEmpty("telegram_payment_charge_id", chargeID)
+
+	_, err := t.bot.MakeRequest("refundStarPayment", params)
+	return err
+}
+
+func (t *TelegramService) sendInvoice(chatID int64, title, description, payload, providerToken, currency string, amount int64) error {
+	invoice := tgbotapi.NewInvoice(chatID, title, description, payload, providerToken, "", currency,
+		[]tgbotapi.LabeledPrice{{Label: title, Amount: int(amount)}})
+	invoice.SuggestedTipAmounts = []int{} // a nil slice is sent as "null", which Telegram rejects
+
+	_, err := t.bot.Send(invoice)
+	return err
+}
+
+// AnswerPreCheckoutQuery confirms or rejects a checkout; Telegram expects an answer within 10 seconds
+func (t *TelegramService) AnswerPreCheckoutQuery(queryID string, ok bool, errorMessage string) error {
+	_, err := t.bot.Request(tgbotapi.PreCheckoutConfig{
+		PreCheckoutQueryID: queryID,
+		OK:                 ok,
+		ErrorMessage:       errorMessage,
+	})
+	return err
+}
 
 // Bot returns the bot instance for direct access
 func (t *TelegramService) Bot() *tgbotapi.BotAPI {
 	return t.bot
 }
 
-// IsAdmin checks if a user is an administrator
+// admins are the Telegram IDs of bot administrators
 // TODO: Move admin IDs to configuration instead of hardcoding
-// For now, update this slice with your admin IDs
+var admins = []int64{5147599417} // Update with actual admin IDs
+
+// IsAdmin checks if a user is an administrator
 func (t *TelegramService) IsAdmin(chatID int64) bool {
-	admins := []int64{5147599417} // Update with actual admin IDs
 	for _, adminID := range admins {
 		if adminID == chatID {
 			return true
@@ -51,3 +131,12 @@ func (t *TelegramService) IsAdmin(chatID int64) bool {
 	}
 	return false
 }
+
+// NotifyAdmins sends a message to every administrator
+func (t *TelegramService) NotifyAdmins(message string) {
+	for _, adminID := range admins {
+		if err := t.SendMessage(adminID, message); err != nil {
+			log.Printf("Failed to notify admin %d: %v", adminID, err)
+		}
+	}
+}
@@ -1,60 +1,61 @@
 package services
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
+	"math/big"
+	"strings"
 	"time"
+
+	"gobotcat/config"
 )
 
 const (
-	// ========== TESTING CONFIGURATION (TESTNET ONLY) ==========
-	// NOTE: Currently configured for Shasta Testnet
-	// For testing on Shasta Testnet (free TRX from faucet https://www.tronweb.org/shasta)
-	// Using native TRX instead of USDT for simplicity and faster testing
-	TRON_RPC_URL      = "https://api.shasta.trongrid.io" // Testnet API endpoint
-	USE_TRX_FOR_TEST  = true                              // Set to false for USDT on mainnet - CURRENTLY IN TEST MODE
-
-	// ========== PRODUCTION CONFIGURATION (CHANGE FOR MAINNET) ==========
-	// TODO: Switch to mainnet for production - uncomment lines below
-	// TRON_RPC_URL      = "https://api.trongrid.io"
-	// USE_TRX_FOR_TEST  = false
-	
-	// TODO: Replace with real USDT contract address on mainnet
-	USDT_CONTRACT     = "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t" // USDT on Tron mainnet
-	
-	USDT_DECIMALS     = 6 // Decimal places for USDT token
-	TRX_DECIMALS      = 6 // Decimal places for native TRX
-	CONFIRMATION_NUM  = 25 // Number of blocks to wait for transaction confirmation
+	USDT_DECIMALS = 6 // Decimal places for USDT token
+	TRX_DECIMALS  = 6 // Decimal places for native TRX
 )
 
 // TronService provides methods to interact with the Tron blockchain
-// Currently configured for Shasta Testnet - switch to mainnet for production
+// The network, asset and confirmation depth come from config.TronConfig
 type TronService struct {
 	apiKey      string // TronGrid API key for authenticated requests
 	mainAddress string // Main wallet address for receiving payments
+	network     config.TronConfig
+	depositKey  *ExtendedPublicKey // derives per-order deposit addresses, nil when TRON_XPUB is unset
+	client      *TronGridClient    // shared by all copies made with WithContext
+	ctx         context.Context    // cancels requests and retry waits
 }
 
 // TronBalance represents the balance information for a Tron address
 type TronBalance struct {
-	Amount    int64  `json:"amount"`    // Balance amount in smallest units (sun/wei)
-	Decimals  int    `json:"decimals"`  // Decimal places (6 for TRX and USDT)
-	Address   string `json:"address"`   // The Tron address being queried
-	Timestamp int64  `json:"timestamp"` // Query timestamp
+	Amount    *big.Int `json:"amount"`    // Balance amount in smallest units (sun for TRX)
+	Decimals  int      `json:"decimals"`  // Decimal places (6 for TRX and USDT)
+	Address   string   `json:"address"`   // The Tron address being queried
+	Timestamp int64    `json:"timestamp"` // Query timestamp
+}
+
+// PaymentUnits converts the balance to payment units (see ToPaymentUnits)
+func (b *TronBalance) PaymentUnits() (int64, error) {
+	return ToPaymentUnits(b.Amount, b.Decimals)
 }
 
 // TronTransaction represents a Tron blockchain transaction
 type TronTransaction struct {
-	TxID          string `json:"txID"`            // Transaction ID (hash)
-	From          string `json:"from"`            // Sender address
-	To            string `json:"to"`              // Recipient address
-	Amount        int64  `json:"amount"`          // Transaction amount in smallest units
-	ContractAddr  string `json:"contractAddress"` // Smart contract address (for token transfers)
-	BlockNumber   int64  `json:"blockNumber"`     // Block number containing the transaction
-	Confirmed     bool   `json:"confirmed"`       // Whether transaction has sufficient confirmations
-	Timestamp     int64  `json:"timestamp"`       // Transaction timestamp
+	TxID         string   `json:"txID"`            // Transaction ID (hash)
+	From         string   `json:"from"`            // Sender address
+	To           string   `json:"to"`              // Recipient address
+	Amount       *big.Int `json:"amount"`          // Transaction amount in smallest units of the asset
+	Decimals     int      `json:"decimals"`        // Decimal places of Amount
+	ContractAddr string   `json:"contractAddress"` // Smart contract address (for token transfers)
+	BlockNumber  int64    `json:"blockNumber"`     // Block number containing the transaction
+	Confirmed    bool     `json:"confirmed"`       // Whether transaction has sufficient confirmations
+	Timestamp    int64    `json:"timestamp"`       // Transaction timestamp
+}
+
+// PaymentUnits converts the transferred amount to payment units (see ToPaymentUnits)
+func (tx *TronTransaction) PaymentUnits() (int64, error) {
+	return ToPaymentUnits(tx.Amount, tx.Decimals)
 }
 
 // TronRPCResponse represents a response from the Tron RPC API
@@ -72,11 +73,66 @@ type TronAccountInfo struct {
 	Balance int64  `json:"balance"` // Account balance in sun
 }
 
-func NewTronService(apiKey, mainAddress string) *TronService {
-	return &TronService{
+// NewTronService validates the configured addresses and the deposit xpub
+func NewTronService(apiKey, mainAddress string, network config.TronConfig) (*TronService, error) {
+	for name, address := range map[string]string{
+		"TRON_MAIN_ADDRESS":   mainAddress,
+		"TRON_TOKEN_CONTRACT": network.TokenContract,
+	} {
+		if address == "" {
+			continue
+		}
+		if err := ValidateTronAddress(address); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	s := &TronService{
 		apiKey:      apiKey,
 		mainAddress: mainAddress,
+		network:     network,
+		client:      NewTronGridClient(network.RPCURL, apiKey, network.RateLimit),
+		ctx:         context.Background(),
+	}
+
+	if network.XPub != "" {
+		key, err := ParseExtendedPublicKey(network.XPub)
+		if err != nil {
+			return nil, fmt.Errorf("TRON_XPUB: %w", err)
+		}
+		s.depositKey = key
 	}
+
+	return s, nil
+}
+
+// WithContext returns a copy of the service whose requests are cancelled with ctx.
+// The copy shares the TronGrid client, so rate limit and circuit breaker stay global.
+func (s *TronService) WithContext(ctx context.Context) *TronService {
+	copied := *s
+	copied.ctx = ctx
+	return &copied
+}
+
+// Metrics returns the TronGrid request counters and latencies
+func (s *TronService) Metrics() *TronGridMetrics {
+	return s.client.Metrics()
+}
+
+// HasDepositWallet reports whether orders get their own derived deposit address
+func (s *TronService) HasDepositWallet() bool {
+	return s.depositKey != nil
+}
+
+// DepositAddress derives the deposit address for derivation index m/44'/195'/0'/0/index
+func (s *TronService) DepositAddress(index int64) (string, error) {
+	if s.depositKey == nil {
+		return "", fmt.Errorf("no deposit wallet configured")
+	}
+	if index < 0 || index >= hardenedOffset {
+		return "", fmt.Errorf("derivation index %d out of range", index)
+	}
+	return s.depositKey.DepositAddress(uint32(index))
 }
 
 // GetMainAddress returns the main Tron address for receiving payments
@@ -84,304 +140,281 @@ func (s *TronService) GetMainAddress() string {
 	return s.mainAddress
 }
 
+// Network returns the configured network key, e.g. "mainnet"
+func (s *TronService) Network() string {
+	return s.network.Network
+}
+
+// NetworkName returns the human-readable network, e.g. "Tron (Mainnet)"
+func (s *TronService) NetworkName() string {
+	return s.network.NetworkName
+}
+
+// Asset returns the token buyers pay with, TRX or USDT
+func (s *TronService) Asset() string {
+	return s.network.Asset
+}
+
+// Decimals returns the decimal places of the configured asset on chain
+func (s *TronService) Decimals() int {
+	if s.network.Asset == "TRX" {
+		return TRX_DECIMALS
+	}
+	return s.network.TokenDecimals
+}
+
+// Confirmations returns the number of blocks a payment must be buried under
+func (s *TronService) Confirmations() int64 {
+	return s.network.Confirmations
+}
+
+// LateGrace is how long after expiry a payment is still accepted without review
+func (s *TronService) LateGrace() time.Duration {
+	return s.network.LateGrace
+}
+
+// LateWindow is how long after expiry transfers are still credited to a payment
+func (s *TronService) LateWindow() time.Duration {
+	return s.network.LateWindow
+}
+
 // CheckBalance checks TRX or USDT balance on a Tron address
 func (s *TronService) CheckBalance(address string) (*TronBalance, error) {
-	if USE_TRX_FOR_TEST {
+	if s.network.Asset == "TRX" {
 		return s.checkTRXBalance(address)
 	}
 	return s.checkUSDTBalance(address)
 }
 
-// checkTRXBalance checks native TRX balance for testing on Shasta Testnet
-// TESTNET MODE: This method is used because we're testing with native TRX instead of USDT
-// NOTE: This is for Shasta Testnet only. For mainnet USDT, use checkUSDTBalance()
+// checkTRXBalance checks native TRX balance
 func (s *TronService) checkTRXBalance(address string) (*TronBalance, error) {
-	// Use v1 REST API endpoint (works better with Base58 addresses than /walletsolidity endpoints)
-	// NOTE: Debug logs included - REMOVE fmt.Printf statements in production
-	url := TRON_RPC_URL + "/v1/accounts/" + address
-	
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	
-	if s.apiKey != "" {
-		req.Header.Set("TRON-PRO-API-KEY", s.apiKey)
-	}
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+	amount, _, err := s.GetTRXAccount(address)
 	if err != nil {
 		return nil, err
 	}
 
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, err
-	}
-
-	var amount int64 = 0
-	
-	// Parse balance from API response structure
-	// Try to get balance from data array
-	if data, ok := result["data"].([]interface{}); ok && len(data) > 0 {
-		if accountData, ok := data[0].(map[string]interface{}); ok {
-			if balance, ok := accountData["balance"].(float64); ok {
-				amount = int64(balance)
-			}
-		}
-	}
-
 	return &TronBalance{
-		Amount:    amount,
+		Amount:    big.NewInt(amount),
 		Decimals:  TRX_DECIMALS,
 		Address:   address,
 		Timestamp: time.Now().Unix(),
 	}, nil
 }
 
-func getKeys(m map[string]interface{}) []string {
-	keys := make([]string, 0, len(m))
-	for k := range m {
-		keys = append(keys, k)
-	}
-	return keys
-}
-
-// checkUSDTBalance checks USDT token balance (for mainnet production)
-// NOT USED IN TEST MODE - See checkTRXBalance for testnet balance checking
-// NOTE: This method needs proper Base58 to Hex conversion to work correctly
-// Currently it has issues with address format conversion - NEEDS TO BE FIXED BEFORE MAINNET SWITCH
+// checkUSDTBalance checks the balance of the configured TRC-20 token
 func (s *TronService) checkUSDTBalance(address string) (*TronBalance, error) {
-	// Convert address from Base58 (starts with 'T') to Hex format required by /walletsolidity endpoints
-	// TODO: Implement proper Base58 decode to Hex conversion (currently a stub returning address as-is)
-	hexAddr, err := s.addressToHex(address)
+	// /walletsolidity endpoints take hex addresses, not Base58
+	hexAddr, err := TronAddressToHex(address)
 	if err != nil {
-		return nil, fmt.Errorf("invalid address: %v", err)
+		return nil, err
 	}
 
-	// Get USDT balance (TRC-20 token transfer history)
-	// We check token balance by querying contract
-	usdtHex, err := s.addressToHex(USDT_CONTRACT)
+	contractHex, err := TronAddressToHex(s.network.TokenContract)
 	if err != nil {
-		return nil, fmt.Errorf("invalid usdt contract: %v", err)
+		return nil, fmt.Errorf("invalid token contract: %v", err)
 	}
 
+	// balanceOf takes the 20-byte account ID (without the 0x41 prefix), left-padded to 32 bytes
 	balancePayload := map[string]interface{}{
 		"owner_address":     hexAddr,
-		"contract_address":  usdtHex,
+		"contract_address":  contractHex,
 		"function_selector": "balanceOf(address)",
-		"parameter":         hexAddr,
+		"parameter":         strings.Repeat("0", 24) + hexAddr[2:],
 	}
 
-	balanceResp, err := s.callTronAPI("/walletsolidity/triggerconstantcontract", balancePayload)
-	if err != nil {
+	const endpoint = "/walletsolidity/triggerconstantcontract"
+	var result tronConstantResult
+	if err := s.postTronAPI(endpoint, balancePayload, &result); err != nil {
 		return nil, err
 	}
-
-	var result map[string]interface{}
-	if err := json.Unmarshal(balanceResp, &result); err != nil {
-		return nil, err
+	if !result.Result.Result {
+		return nil, fmt.Errorf("balanceOf failed: %s %s", result.Result.Code, decodeNodeMessage(result.Result.Message))
 	}
-
-	// Parse balance from constant_result
-	var amount int64 = 0
-	if constantResult, ok := result["constant_result"].([]interface{}); ok && len(constantResult) > 0 {
-		if resultStr, ok := constantResult[0].(string); ok {
-			// Convert hex string to int64
-			if val, err := parseHexBalance(resultStr); err == nil {
-				amount = val
-			}
-		}
+	if len(result.ConstantResult) == 0 {
+		return nil, schemaError(endpoint, "no constant_result")
+	}
+	amount, err := parseUint256(result.ConstantResult[0])
+	if err != nil {
+		return nil, schemaError(endpoint, "balance %q: %v", result.ConstantResult[0], err)
 	}
 
 	return &TronBalance{
 		Amount:    amount,
-		Decimals:  USDT_DECIMALS,
+		Decimals:  s.Decimals(),
 		Address:   address,
 		Timestamp: time.Now().Unix(),
 	}, nil
 }
 
-// GetTransaction retrieves detailed transaction information from Tron blockchain by transaction ID
+// GetTransaction looks up txID on chain and decodes it as a transfer of the configured asset
+// (see decodeTransfer). It fails for unknown and failed transactions and for transfers of anything else.
 func (s *TronService) GetTransaction(txID string) (*TronTransaction, error) {
-	payload := map[string]interface{}{
-		"value": txID,
-	}
-
-	respBody, err := s.callTronAPI("/walletsolidity/gettransactionbyid", payload)
-	if err != nil {
+	const endpoint = "/wallet/gettransactionbyid"
+	var txData tronTransaction
+	if err := s.postTronAPI(endpoint, map[string]interface{}{"value": txID}, &txData); err != nil {
 		return nil, err
 	}
-
-	var txData map[string]interface{}
-	if err := json.Unmarshal(respBody, &txData); err != nil {
-		return nil, err
+	if txData.TxID == "" {
+		return nil, fmt.Errorf("transaction %s not found", txID)
 	}
-
-	// Parse transaction
-	tx := &TronTransaction{
-		TxID:      txID,
-		Timestamp: time.Now().Unix(),
+	if txData.TxID != txID {
+		return nil, schemaError(endpoint, "asked for %s, got %s", txID, txData.TxID)
+	}
+	if ret, failed := txData.failed(); failed {
+		return nil, fmt.Errorf("transaction %s failed: %s", txID, ret)
 	}
 
-	if rawTx, ok := txData["raw_data"].(map[string]interface{}); ok {
-		if txBody, ok := rawTx["contract"].([]interface{}); ok && len(txBody) > 0 {
-			if contract, ok := txBody[0].(map[string]interface{}); ok {
-				if parameter, ok := contract["parameter"].(map[string]interface{}); ok {
-					if value, ok := parameter["value"].(map[string]interface{}); ok {
-						tx.From = toString(value["owner_address"])
-						tx.To = toString(value["to_address"])
-						if amount, ok := value["amount"].(float64); ok {
-							tx.Amount = int64(amount)
-						}
-					}
-				}
-			}
-		}
+	tx, err := s.decodeTransfer(&txData)
+	if err != nil {
+		return nil, err
 	}
+	tx.Timestamp = txData.RawData.Timestamp / 1000
 
-	// Check if confirmed (has block_number)
-	if blockNum, ok := txData["blockNumber"].(float64); ok {
-		tx.BlockNumber = int64(blockNum)
-		tx.Confirmed = true
+	block, err := s.GetTransactionBlock(txID)
+	if err != nil {
+		return nil, err
 	}
+	tx.BlockNumber = block
+	tx.Confirmed = block > 0
 
 	return tx, nil
 }
 
-// GetAddressTransactions retrieves recent TRC20 token transactions for an address
-// TESTNET NOTE: This endpoint returns empty results on Shasta Testnet for TRX transfers
-// For now, we confirm payment by balance check instead of transaction lookup (see CheckPendingPayments)
-// TODO: For mainnet, implement proper transaction verification with retries instead of balance checking
-func (s *TronService) GetAddressTransactions(address string) ([]TronTransaction, error) {
-	// Use GET request to TRC20 transactions endpoint (only returns token transfers, not native TRX)
-	// NOTE: This endpoint may be empty on testnet - currently not used for balance confirmation
-	url := TRON_RPC_URL + "/v1/accounts/" + address + "/transactions/trc20?limit=100"
-	
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
+// decodeTransfer reads the contract of a transaction as a transfer of the configured asset:
+// a TRX TransferContract, or a transfer/transferFrom call on the token contract.
+// Transfers missing required fields fail with ErrTronSchema.
+func (s *TronService) decodeTransfer(txData *tronTransaction) (*TronTransaction, error) {
+	txID := txData.TxID
+	if len(txData.RawData.Contract) == 0 {
+		return nil, fmt.Errorf("transaction %s: %w: no contract", txID, ErrTronSchema)
 	}
-	
-	if s.apiKey != "" {
-		req.Header.Set("TRON-PRO-API-KEY", s.apiKey)
+	contract := txData.RawData.Contract[0]
+	if contract.Type != "TransferContract" && contract.Type != "TriggerSmartContract" {
+		return nil, fmt.Errorf("transaction %s is a %s, not a transfer", txID, contract.Type)
 	}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	var value tronTransferValue
+	if err := json.Unmarshal(contract.Parameter.Value, &value); err != nil {
+		return nil, fmt.Errorf("transaction %s: %w: %v", txID, ErrTronSchema, err)
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	if value.OwnerAddress == "" {
+		return nil, fmt.Errorf("transaction %s: %w: no owner_address", txID, ErrTronSchema)
 	}
 
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, err
+	tx := &TronTransaction{
+		TxID:     txID,
+		From:     printableAddress(value.OwnerAddress),
+		Decimals: s.Decimals(),
 	}
 
-	var transactions []TronTransaction
-	if txList, ok := result["data"].([]interface{}); ok {
-		for _, tx := range txList {
-			if txMap, ok := tx.(map[string]interface{}); ok {
-				transaction := &TronTransaction{
-					Timestamp: time.Now().Unix(),
-				}
-				if txID, ok := txMap["transaction_id"].(string); ok {
-					transaction.TxID = txID
-				}
-				transactions = append(transactions, *transaction)
-			}
+	switch contract.Type {
+	case "TransferContract":
+		if s.network.Asset != "TRX" {
+			return nil, fmt.Errorf("transaction %s is a TRX transfer, expected %s", txID, s.network.Asset)
+		}
+		if value.ToAddress == "" {
+			return nil, fmt.Errorf("transaction %s: %w: no to_address", txID, ErrTronSchema)
+		}
+		tx.To = printableAddress(value.ToAddress)
+		tx.Amount = big.NewInt(value.Amount)
+
+	case "TriggerSmartContract":
+		tx.ContractAddr = printableAddress(value.ContractAddress)
+		if s.network.Asset == "TRX" || tx.ContractAddr != s.network.TokenContract {
+			return nil, fmt.Errorf("transaction %s calls %s, not the %s contract", txID, tx.ContractAddr, s.network.Asset)
+		}
+		// Arguments are 32-byte words after the 4-byte selector; addresses are the last 20 bytes of a word
+		data := value.Data
+		switch {
+		case len(data) == 8+2*64 && data[:8] == "a9059cbb": // transfer(address to, uint256 amount)
+		case len(data) == 8+3*64 && data[:8] == "23b872dd": // transferFrom(address from, address to, uint256 amount)
+			tx.From = printableAddress("41" + data[8+24:8+64])
+			data = data[:8] + data[8+64:]
+		default:
+			return nil, fmt.Errorf("transaction %s is not a token transfer", txID)
 		}
+		tx.To = printableAddress("41" + data[8+24:8+64])
+		amount, err := parseUint256(data[8+64:])
+		if err != nil {
+			return nil, fmt.Errorf("transaction %s: %w: %v", txID, ErrTronSchema, err)
+		}
+		tx.Amount = amount
 	}
 
-	return transactions, nil
+	return tx, nil
 }
 
-// ===== Helper methods =====
-
-// callTronAPI makes a POST request to the Tron API with the given endpoint and payload
-// Handles authentication and returns the raw response body or error
-func (s *TronService) callTronAPI(endpoint string, payload interface{}) ([]byte, error) {
-	url := TRON_RPC_URL + endpoint
-	
-	jsonPayload, err := json.Marshal(payload)
-	if err != nil {
-		return nil, err
+// GetLatestBlock returns the number of the current head block
+func (s *TronService) GetLatestBlock() (int64, error) {
+	const endpoint = "/wallet/getnowblock"
+	var block tronBlock
+	if err := s.postTronAPI(endpoint, map[string]interface{}{}, &block); err != nil {
+		return 0, err
 	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonPayload))
-	if err != nil {
-		return nil, err
+	if block.BlockHeader.RawData.Number == 0 {
+		return 0, schemaError(endpoint, "no block number")
 	}
+	return block.BlockHeader.RawData.Number, nil
+}
 
-	req.Header.Set("Content-Type", "application/json")
-	if s.apiKey != "" {
-		req.Header.Set("TRON-PRO-API-KEY", s.apiKey)
+// GetTransactionBlock returns the number of the block that includes txID, 0 if it is not in a block yet
+func (s *TronService) GetTransactionBlock(txID string) (int64, error) {
+	const endpoint = "/wallet/gettransactioninfobyid"
+	var info tronTransactionInfo
+	if err := s.postTronAPI(endpoint, map[string]interface{}{"value": txID}, &info); err != nil {
+		return 0, err
 	}
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	if info.ID != "" && info.ID != txID {
+		return 0, schemaError(endpoint, "asked for %s, got %s", txID, info.ID)
 	}
-	defer resp.Body.Close()
+	return info.BlockNumber, nil
+}
+
+// ===== Helper methods =====
 
-	body, err := io.ReadAll(resp.Body)
+// getTronAPI makes a GET request to a TronGrid v1 endpoint and decodes the data of the response into data
+func (s *TronService) getTronAPI(endpoint string, data any) error {
+	body, err := s.client.Get(s.ctx, endpoint)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("tron api error: %d %s", resp.StatusCode, string(body))
+	var result tronV1Response
+	if err := decodeTronResponse(endpoint, body, &result); err != nil {
+		return err
 	}
-
-	return body, nil
+	if !result.Success {
+		return fmt.Errorf("tron api error: %s", result.Error)
+	}
+	if result.Data == nil {
+		return schemaError(endpoint, "no data")
+	}
+	return decodeTronResponse(endpoint, result.Data, data)
 }
 
-// addressToHex converts a Tron address from Base58 format to hexadecimal format
-// TODO: Implement proper Base58 decoding (currently returns address as-is for testing)
-// IMPORTANT: This stub implementation will break USDT balance checking on mainnet
-func (s *TronService) addressToHex(address string) (string, error) {
-	// Tron addresses start with 'T' in Base58 encoding
-	// The /walletsolidity endpoints require hex format, not Base58
-	// For testing, we use the address as-is since Shasta API also accepts Base58
-	// TODO: For mainnet USDT, implement proper Base58 -> Hex conversion using external library
-	return address, nil
+// postTronAPI makes a POST request to a /wallet endpoint and decodes the response into result
+func (s *TronService) postTronAPI(endpoint string, payload, result any) error {
+	body, err := s.callTronAPI(endpoint, payload)
+	if err != nil {
+		return err
+	}
+	return decodeTronResponse(endpoint, body, result)
 }
 
-// parseHexBalance converts a hexadecimal balance string to int64
-// Handles optional '0x' prefix commonly used in blockchain APIs
-func parseHexBalance(hexStr string) (int64, error) {
-	// Remove '0x' prefix if present
-	if len(hexStr) > 2 && hexStr[0:2] == "0x" {
-		hexStr = hexStr[2:]
+// callTronAPI makes a POST request to the Tron API with the given endpoint and payload
+// Returns the raw response body or error
+func (s *TronService) callTronAPI(endpoint string, payload interface{}) ([]byte, error) {
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
 	}
-	var balance int64
-	_, err := fmt.Sscanf(hexStr, "%x", &balance)
-	return balance, err
+	return s.client.Post(s.ctx, endpoint, jsonPayload)
 }
 
-// toString converts various types to string representation
-// Handles string, float64, and other types with appropriate conversion
-func toString(v interface{}) string {
-	switch val := v.(type) {
-	case string:
-		return val
-	case float64:
-		return fmt.Sprintf("%v", val)
-	default:
-		return ""
+// printableAddress converts a hex address from the API to Base58, leaving anything else as-is
+func printableAddress(hexAddr string) string {
+	if address, err := TronAddressFromHex(hexAddr); err == nil {
+		return address
 	}
+	return hexAddr
 }
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	tronAddressPrefix = 0x41 // first byte of every Tron address, gives the leading 'T'
+	tronAddressLen    = 21   // prefix + 20-byte account ID
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var (
+	ErrInvalidTronAddress = errors.New("invalid tron address")
+	ErrTronChecksum       = errors.New("tron address checksum mismatch")
+)
+
+// ValidateTronAddress checks that address is a Base58Check Tron address with a valid checksum
+func ValidateTronAddress(address string) error {
+	_, err := decodeTronAddress(address)
+	return err
+}
+
+// tronAddressFromPublicKey hashes a public key into its Base58Check Tron address:
+// the last 20 bytes of Keccak-256 over the uncompressed key without its 04 prefix
+func tronAddressFromPublicKey(pub *secp256k1.PublicKey) string {
+	hash := keccak256(pub.SerializeUncompressed()[1:])
+	return base58CheckEncode(append([]byte{tronAddressPrefix}, hash[12:]...))
+}
+
+// keccak256 is the original Keccak-256 used by Tron and Ethereum, not the standardized SHA3-256
+func keccak256(data []byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// TronAddressToHex converts a Base58Check address (T...) to its 21-byte hex form (41...)
+func TronAddressToHex(address string) (string, error) {
+	raw, err := decodeTronAddress(address)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// TronAddressFromHex converts a hex address (41... or 0x41...) to Base58Check (T...)
+func TronAddressFromHex(hexAddr string) (string, error) {
+	raw, err := hex.DecodeString(strings.TrimPrefix(hexAddr, "0x"))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidTronAddress, err)
+	}
+	if len(raw) != tronAddressLen || raw[0] != tronAddressPrefix {
+		return "", fmt.Errorf("%w: %s", ErrInvalidTronAddress, hexAddr)
+	}
+	return base58CheckEncode(raw), nil
+}
+
+// decodeTronAddress returns the 21 raw bytes of a Base58Check address
+func decodeTronAddress(address string) ([]byte, error) {
+	raw, err := base58CheckDecode(address)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != tronAddressLen || raw[0] != tronAddressPrefix {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidTronAddress, address)
+	}
+	return raw, nil
+}
+
+// base58CheckEncode appends the 4-byte double-SHA256 checksum and encodes in Base58
+func base58CheckEncode(payload []byte) string {
+	return base58Encode(append(append([]byte{}, payload...), checksum(payload)...))
+}
+
+// base58CheckDecode decodes s and verifies and strips its checksum
+func base58CheckDecode(s string) ([]byte, error) {
+	raw, err := base58Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < 5 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidTronAddress, s)
+	}
+	payload, sum := raw[:len(raw)-4], raw[len(raw)-4:]
+	if !bytes.Equal(checksum(payload), sum) {
+		return nil, fmt.Errorf("%w: %s", ErrTronChecksum, s)
+	}
+	return payload, nil
+}
+
+func checksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	return second[:4]
+}
+
+func base58Encode(data []byte) string {
+	n := new(big.Int).SetBytes(data)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	// Each leading zero byte is written as '1'
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+func base58Decode(s string) ([]byte, error) {
+	if s == "" {
+		return nil, fmt.Errorf("%w: empty", ErrInvalidTronAddress)
+	}
+
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, r := range s {
+		digit := strings.IndexRune(base58Alphabet, r)
+		if digit < 0 {
+			return nil, fmt.Errorf("%w: bad character %q", ErrInvalidTronAddress, r)
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(digit)))
+	}
+
+	zeros := 0
+	for zeros < len(s) && s[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+var tronAddressVectors = []struct {
+	base58 string
+	hex    string
+}{
+	{"TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t", "41a614f803b6fd780986a42c78ec9c7f77e6ded13c"}, // USDT contract on mainnet
+	{"T9yD14Nj9j7xAB4dbGeiX9h8unkKHxuWwb", "410000000000000000000000000000000000000000"}, // zero address
+	{"TXLAQ63Xg1NAzckPwKHvzw7CSEmLMEqcdj", "41ea51342dabbb928ae1e576bd39eff8aaf070a8c6"},
+}
+
+func TestTronAddressToHex(t *testing.T) {
+	for _, v := range tronAddressVectors {
+		got, err := TronAddressToHex(v.base58)
+		if err != nil {
+			t.Errorf("TronAddressToHex(%s): %v", v.base58, err)
+			continue
+		}
+		if got != v.hex {
+			t.Errorf("TronAddressToHex(%s) = %s, want %s", v.base58, got, v.hex)
+		}
+	}
+}
+
+func TestTronAddressFromHex(t *testing.T) {
+	for _, v := range tronAddressVectors {
+		for _, in := range []string{v.hex, "0x" + v.hex} {
+			got, err := TronAddressFromHex(in)
+			if err != nil {
+				t.Errorf("TronAddressFromHex(%s): %v", in, err)
+				continue
+			}
+			if got != v.base58 {
+				t.Errorf("TronAddressFromHex(%s) = %s, want %s", in, got, v.base58)
+			}
+		}
+	}
+
+	for _, bad := range []string{
+		"",
+		"41a614f803b6fd780986a42c78ec9c7f77e6ded1",   // 20 bytes
+		"00a614f803b6fd780986a42c78ec9c7f77e6ded13c", // wrong prefix
+		"41a614f803b6fd780986a42c78ec9c7f77e6ded13z", // not hex
+	} {
+		if _, err := TronAddressFromHex(bad); !errors.Is(err, ErrInvalidTronAddress) {
+			t.Errorf("TronAddressFromHex(%q) error = %v, want ErrInvalidTronAddress", bad, err)
+		}
+	}
+}
+
+func TestValidateTronAddress(t *testing.T) {
+	valid := []string{
+		"TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t",
+		"TXYZopYRdj2D9XRtbG411XZZ3kM5VkAeBf", // USDT contract on Nile
+	}
+	for _, address := range valid {
+		if err := ValidateTronAddress(address); err != nil {
+			t.Errorf("ValidateTronAddress(%s): %v", address, err)
+		}
+	}
+
+	invalid := map[string]error{
+		"TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6u": ErrTronChecksum,       // last character changed
+		"TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6":  ErrTronChecksum,       // truncated
+		"1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa": ErrInvalidTronAddress, // Bitcoin, prefix 0x00
+		"TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj0t": ErrInvalidTronAddress, // '0' is not in the alphabet
+		"":                                   ErrInvalidTronAddress,
+	}
+	for address, want := range invalid {
+		if err := ValidateTronAddress(address); !errors.Is(err, want) {
+			t.Errorf("ValidateTronAddress(%q) error = %v, want %v", address, err, want)
+		}
+	}
+}
+
+func TestBase58RoundTrip(t *testing.T) {
+	for _, data := range [][]byte{{0}, {0, 0, 1}, {0xff, 0xfe}, []byte("hello")} {
+		decoded, err := base58Decode(base58Encode(data))
+		if err != nil {
+			t.Fatalf("base58Decode: %v", err)
+		}
+		if string(decoded) != string(data) {
+			t.Errorf("round trip %x -> %x", data, decoded)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// PaymentDecimals is the precision of payment amounts: prices, received totals and credits are
+// stored as int64 millionths of the asset, which is sun for TRX and the smallest unit of USDT
+const PaymentDecimals = 6
+
+// ErrAmountOverflow is returned for on-chain amounts too large for an int64 payment amount
+var ErrAmountOverflow = errors.New("amount does not fit in a payment amount")
+
+// maxUint256 is the largest TRC-20 amount
+var maxUint256 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// ToPaymentUnits converts amount, in the smallest unit of a token with decimals places, to payment units.
+// Digits beyond PaymentDecimals are dropped, so a transfer is never credited with more than it carried.
+func ToPaymentUnits(amount *big.Int, decimals int) (int64, error) {
+	if amount == nil || amount.Sign() < 0 {
+		return 0, fmt.Errorf("invalid amount %v", amount)
+	}
+
+	units := new(big.Int)
+	if decimals > PaymentDecimals {
+		units.Quo(amount, pow10(decimals-PaymentDecimals))
+	} else {
+		units.Mul(amount, pow10(PaymentDecimals-decimals))
+	}
+	if !units.IsInt64() {
+		return 0, fmt.Errorf("%w: %s", ErrAmountOverflow, FormatUnits(amount, decimals))
+	}
+	return units.Int64(), nil
+}
+
+// FromPaymentUnits converts payment units to the smallest unit of a token with decimals places.
+// A token with fewer decimals than PaymentDecimals gets the amount rounded down.
+func FromPaymentUnits(units int64, decimals int) *big.Int {
+	amount := big.NewInt(units)
+	if decimals > PaymentDecimals {
+		return amount.Mul(amount, pow10(decimals-PaymentDecimals))
+	}
+	return amount.Quo(amount, pow10(PaymentDecimals-decimals))
+}
+
+// FormatUnits formats amount in the smallest unit of a token with decimals places, e.g. 1500000 with 6 is 1.500000
+func FormatUnits(amount *big.Int, decimals int) string {
+	if amount == nil {
+		return "0"
+	}
+	digits := new(big.Int).Abs(amount).String()
+	if decimals > 0 {
+		digits = strings.Repeat("0", max(decimals+1-len(digits), 0)) + digits
+		digits = digits[:len(digits)-decimals] + "." + digits[len(digits)-decimals:]
+	}
+	if amount.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+// parseUint256 reads a hex-encoded uint256, such as a word of constant_result or call data
+func parseUint256(hexStr string) (*big.Int, error) {
+	hexStr = strings.TrimPrefix(hexStr, "0x")
+	value, ok := new(big.Int).SetString(hexStr, 16)
+	if !ok || value.Sign() < 0 || value.Cmp(maxUint256) > 0 {
+		return nil, fmt.Errorf("invalid uint256 %q", hexStr)
+	}
+	return value, nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func bigInt(t *testing.T, decimal string) *big.Int {
+	t.Helper()
+	value, ok := new(big.Int).SetString(decimal, 10)
+	if !ok {
+		t.Fatalf("bad number %q", decimal)
+	}
+	return value
+}
+
+func TestToPaymentUnits(t *testing.T) {
+	tests := []struct {
+		amount   string
+		decimals int
+		want     int64
+	}{
+		{"10004000", 6, 10_004_000},
+		{"10004000000000000000", 18, 10_004_000}, // more than int64 on chain, fine as payment units
+		{"10004000999999999999", 18, 10_004_000}, // dust below 1e-6 is dropped
+		{"1", 18, 0},
+		{"12", 0, 12_000_000},
+		{"9223372036854775807", 6, 9223372036854775807},
+	}
+	for _, tt := range tests {
+		got, err := ToPaymentUnits(bigInt(t, tt.amount), tt.decimals)
+		if err != nil || got != tt.want {
+			t.Errorf("ToPaymentUnits(%s, %d) = %d, %v; want %d", tt.amount, tt.decimals, got, err, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		amount   string
+		decimals int
+	}{
+		{"9223372036854775808", 6},
+		{"9223372036854775808000000000000", 18},
+		{"10000000000000", 0},
+		{maxUint256.String(), 6},
+	} {
+		if _, err := ToPaymentUnits(bigInt(t, tt.amount), tt.decimals); !errors.Is(err, ErrAmountOverflow) {
+			t.Errorf("ToPaymentUnits(%s, %d): err = %v, want ErrAmountOverflow", tt.amount, tt.decimals, err)
+		}
+	}
+	if _, err := ToPaymentUnits(big.NewInt(-1), 6); err == nil {
+		t.Error("negative amount accepted")
+	}
+}
+
+func TestFromPaymentUnits(t *testing.T) {
+	if got := FromPaymentUnits(10_004_000, 18).String(); got != "10004000000000000000" {
+		t.Errorf("18 decimals: %s", got)
+	}
+	if got := FromPaymentUnits(10_004_000, 2).String(); got != "1000" {
+		t.Errorf("2 decimals: %s", got)
+	}
+}
+
+func TestFormatUnits(t *testing.T) {
+	tests := []struct {
+		amount   string
+		decimals int
+		want     string
+	}{
+		{"1500000", 6, "1.500000"},
+		{"5", 6, "0.000005"},
+		{"10004000000000000000", 18, "10.004000000000000000"},
+		{"42", 0, "42"},
+		{"-5", 2, "-0.05"},
+	}
+	for _, tt := range tests {
+		if got := FormatUnits(bigInt(t, tt.amount), tt.decimals); got != tt.want {
+			t.Errorf("FormatUnits(%s, %d) = %s, want %s", tt.amount, tt.decimals, got, tt.want)
+		}
+	}
+}
+
+func TestParseUint256(t *testing.T) {
+	word := strings.Repeat("f", 64)
+	value, err := parseUint256(word)
+	if err != nil || value.Cmp(maxUint256) != 0 {
+		t.Fatalf("max uint256 = %v, %v", value, err)
+	}
+	if value, err := parseUint256("0x00000000000000000000000000000000000000000000000000000000000f4240"); err != nil || value.Int64() != 1_000_000 {
+		t.Errorf("0x-prefixed word = %v, %v", value, err)
+	}
+	for _, bad := range []string{"", "zz", "-1", "1" + word} {
+		if _, err := parseUint256(bad); err == nil {
+			t.Errorf("parseUint256(%q) accepted", bad)
+		}
+	}
+}
+
+func TestTransferParameterLargeAmount(t *testing.T) {
+	amount := bigInt(t, "10004000000000000000") // 10.004 of an 18 decimal token, more than int64
+	parameter, err := transferParameter("TXLAQ63Xg1NAzckPwKHvzw7CSEmLMEqcdj", amount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "0000000000000000000000000000000000000000000000008ad558ff1d020000"; parameter[64:] != want {
+		t.Errorf("amount word = %s, want %s", parameter[64:], want)
+	}
+	if _, err := transferParameter("TXLAQ63Xg1NAzckPwKHvzw7CSEmLMEqcdj", new(big.Int).Lsh(big.NewInt(1), 256)); err == nil {
+		t.Error("amount above uint256 accepted")
+	}
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrTronSchema is wrapped by errors for responses that do not have the shape of the models below
+var ErrTronSchema = errors.New("unexpected tron api response")
+
+// tronV1Response is the envelope of the v1 REST endpoints
+type tronV1Response struct {
+	Success bool            `json:"success"`
+	Error   string          `json:"error"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// tronAccount is an entry of /v1/accounts/{address}; the list is empty for accounts not activated yet
+type tronAccount struct {
+	Address string `json:"address"` // hex
+	Balance int64  `json:"balance"` // sun, missing when 0
+}
+
+// tronTransaction is a transaction as returned by gettransactionbyid and in blocks
+type tronTransaction struct {
+	TxID string `json:"txID"`
+	Ret  []struct {
+		ContractRet string `json:"contractRet"`
+	} `json:"ret"`
+	RawData struct {
+		Contract  []tronContract `json:"contract"`
+		Timestamp int64          `json:"timestamp"` // milliseconds
+	} `json:"raw_data"`
+}
+
+// tronContract is the contract a transaction executes. Its value depends on the type and is only
+// decoded for the transfer types, so an unusual contract elsewhere in a block does not fail the block.
+type tronContract struct {
+	Type      string `json:"type"`
+	Parameter struct {
+		Value json.RawMessage `json:"value"`
+	} `json:"parameter"`
+}
+
+// tronTransferValue is the value of a TransferContract or TriggerSmartContract, addresses in hex
+type tronTransferValue struct {
+	OwnerAddress    string `json:"owner_address"`
+	ToAddress       string `json:"to_address"` // TransferContract
+	Amount          int64  `json:"amount"`     // TransferContract, sun
+	ContractAddress string `json:"contract_address"`
+	Data            string `json:"data"` // TriggerSmartContract call data
+}
+
+// failed reports whether the transaction was executed without success. Transactions not executed
+// yet have no result.
+func (tx *tronTransaction) failed() (string, bool) {
+	if len(tx.Ret) == 0 || tx.Ret[0].ContractRet == "" || tx.Ret[0].ContractRet == "SUCCESS" {
+		return "", false
+	}
+	return tx.Ret[0].ContractRet, true
+}
+
+// tronTransactionInfo is the receipt from gettransactioninfobyid, {} for transactions not in a block
+type tronTransactionInfo struct {
+	ID          string `json:"id"`
+	BlockNumber int64  `json:"blockNumber"`
+}
+
+// tronConstantResult is the response of triggerconstantcontract
+type tronConstantResult struct {
+	Result struct {
+		Result  bool   `json:"result"`
+		Code    string `json:"code"`
+		Message string `json:"message"` // hex encoded
+	} `json:"result"`
+	EnergyUsed     *int64   `json:"energy_used"`
+	ConstantResult []string `json:"constant_result"` // hex encoded return values
+}
+
+// tronUnsignedTx is a transaction built by createtransaction or triggersmartcontract. raw_data is
+// kept as sent by the node, since broadcasttransaction needs it back unchanged with the signature.
+type tronUnsignedTx struct {
+	ID         string          `json:"txID"`
+	RawData    json.RawMessage `json:"raw_data"`
+	RawDataHex string          `json:"raw_data_hex"`
+	Visible    bool            `json:"visible"`
+}
+
+// tronSignedTx is the request of broadcasttransaction
+type tronSignedTx struct {
+	tronUnsignedTx
+	Signature []string `json:"signature"` // hex encoded
+}
+
+// tronTriggerResult is the response of triggersmartcontract
+type tronTriggerResult struct {
+	Result struct {
+		Result  bool   `json:"result"`
+		Code    string `json:"code"`
+		Message string `json:"message"` // hex encoded
+	} `json:"result"`
+	Transaction *tronUnsignedTx `json:"transaction"`
+}
+
+// tronBroadcastResult is the response of broadcasttransaction
+type tronBroadcastResult struct {
+	Result  bool   `json:"result"`
+	Code    string `json:"code"`
+	Message string `json:"message"` // hex encoded
+	TxID    string `json:"txid"`
+}
+
+// tronBlock is a block from getnowblock or getblockbylimitnext
+type tronBlock struct {
+	BlockID     string `json:"blockID"`
+	BlockHeader struct {
+		RawData struct {
+			Number    int64 `json:"number"`
+			Timestamp int64 `json:"timestamp"` // milliseconds
+		} `json:"raw_data"`
+	} `json:"block_header"`
+	Transactions []tronTransaction `json:"transactions"`
+}
+
+// tronBlockList is the response of getblockbylimitnext
+type tronBlockList struct {
+	Block []tronBlock `json:"block"`
+}
+
+// tronAccountResource is the response of getaccountresource; fields are missing when 0
+type tronAccountResource struct {
+	FreeNetLimit int64 `json:"freeNetLimit"`
+	FreeNetUsed  int64 `json:"freeNetUsed"`
+	NetLimit     int64 `json:"NetLimit"`
+	NetUsed      int64 `json:"NetUsed"`
+	EnergyLimit  int64 `json:"EnergyLimit"`
+	EnergyUsed   int64 `json:"EnergyUsed"`
+}
+
+// tronChainParameters is the response of getchainparameters
+type tronChainParameters struct {
+	ChainParameter []struct {
+		Key   string `json:"key"`
+		Value int64  `json:"value"`
+	} `json:"chainParameter"`
+}
+
+// decodeTronResponse unmarshals body into v. /wallet endpoints answer bad requests with 200 and
+// {"Error": "..."}, which is returned as an error; a body that does not fit v is a schema error.
+func decodeTronResponse(endpoint string, body []byte, v any) error {
+	var nodeErr struct {
+		Error string `json:"Error"`
+	}
+	if json.Unmarshal(body, &nodeErr) == nil && nodeErr.Error != "" {
+		return fmt.Errorf("%s: tron api error: %s", metricsEndpoint(endpoint), nodeErr.Error)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return schemaError(endpoint, "%v", err)
+	}
+	return nil
+}
+
+// schemaError reports a response of endpoint that is missing something or has it in the wrong type
+func schemaError(endpoint, format string, args ...any) error {
+	return fmt.Errorf("%s: %w: %s", metricsEndpoint(endpoint), ErrTronSchema, fmt.Sprintf(format, args...))
+}
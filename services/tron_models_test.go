@@ -0,0 +1,172 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gobotcat/config"
+)
+
+// newTestTronService answers every request with body
+func newTestTronService(t *testing.T, asset, body string) *TronService {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	s, err := NewTronService("", "", config.TronConfig{
+		RPCURL:        server.URL,
+		Asset:         asset,
+		TokenContract: "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t",
+		TokenDecimals: 6,
+		RateLimit:     1000,
+	})
+	if err != nil {
+		t.Fatalf("NewTronService: %v", err)
+	}
+	return s
+}
+
+func TestTronSchemaErrors(t *testing.T) {
+	const address = "TXLAQ63Xg1NAzckPwKHvzw7CSEmLMEqcdj"
+	tests := []struct {
+		name string
+		body string
+		call func(s *TronService) error
+	}{
+		{"balance of the wrong type", `{"success":true,"data":[{"balance":"12"}]}`, func(s *TronService) error {
+			_, err := s.CheckBalance(address)
+			return err
+		}},
+		{"account list missing", `{"success":true}`, func(s *TronService) error {
+			_, _, err := s.GetTRXAccount(address)
+			return err
+		}},
+		{"no block number", `{"blockID":"00"}`, func(s *TronService) error {
+			_, err := s.GetLatestBlock()
+			return err
+		}},
+		{"receipt of another transaction", `{"id":"bb","blockNumber":5}`, func(s *TronService) error {
+			_, err := s.GetTransactionBlock("aa")
+			return err
+		}},
+		{"transfer without recipient", `{"txID":"aa","raw_data":{"contract":[{"type":"TransferContract","parameter":{"value":{"owner_address":"41ea51342dabbb928ae1e576bd39eff8aaf070a8c6","amount":5}}}]}}`, func(s *TronService) error {
+			_, err := s.GetTransaction("aa")
+			return err
+		}},
+		{"amount of the wrong type", `{"txID":"aa","raw_data":{"contract":[{"type":"TransferContract","parameter":{"value":{"amount":"5"}}}]}}`, func(s *TronService) error {
+			_, err := s.GetTransaction("aa")
+			return err
+		}},
+		{"blocks of the wrong type", `{"block":{}}`, func(s *TronService) error {
+			_, _, err := s.GetBlockTransfers(1, 2)
+			return err
+		}},
+		{"no energy estimate", `{"result":{"result":true}}`, func(s *TronService) error {
+			_, err := s.EstimateTokenTransferEnergy(address, address, big.NewInt(1))
+			return err
+		}},
+		{"built transaction without raw data", `{"txID":"aa","visible":true}`, func(s *TronService) error {
+			_, err := s.BuildTRXTransfer(address, address, 5)
+			return err
+		}},
+		{"triggered call without transaction", `{"result":{"result":true}}`, func(s *TronService) error {
+			_, err := s.BuildTokenTransfer(address, address, big.NewInt(1), 1)
+			return err
+		}},
+		{"transaction of the wrong type", `{"result":{"result":true},"transaction":"aa"}`, func(s *TronService) error {
+			_, err := s.BuildTokenTransfer(address, address, big.NewInt(1), 1)
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call(newTestTronService(t, "TRX", tt.body))
+			if !errors.Is(err, ErrTronSchema) {
+				t.Errorf("err = %v, want ErrTronSchema", err)
+			}
+		})
+	}
+}
+
+func TestTronTokenBalanceSchema(t *testing.T) {
+	const address = "TXLAQ63Xg1NAzckPwKHvzw7CSEmLMEqcdj"
+
+	s := newTestTronService(t, "USDT", `{"result":{"result":true},"constant_result":["00000000000000000000000000000000000000000000000000000000000f4240"]}`)
+	balance, err := s.CheckBalance(address)
+	if err != nil || balance.Amount.Cmp(big.NewInt(1_000_000)) != 0 {
+		t.Fatalf("balance = %+v, err %v", balance, err)
+	}
+
+	s = newTestTronService(t, "USDT", `{"result":{"result":true}}`)
+	if _, err := s.CheckBalance(address); !errors.Is(err, ErrTronSchema) {
+		t.Errorf("missing constant_result: err = %v, want ErrTronSchema", err)
+	}
+}
+
+func TestTronNodeError(t *testing.T) {
+	s := newTestTronService(t, "TRX", `{"Error":"class org.tron.core.exception.BadItemException : invalid txID"}`)
+	_, err := s.GetTransaction("aa")
+	if err == nil || errors.Is(err, ErrTronSchema) {
+		t.Fatalf("err = %v, want the node's error", err)
+	}
+}
+
+func TestTronBuildAndBroadcast(t *testing.T) {
+	const from, to = "TXLAQ63Xg1NAzckPwKHvzw7CSEmLMEqcdj", "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t"
+
+	// raw_data_hex of a TransferContract of 5 sun to `to`, hashed into the txID the node returns
+	toRaw, _ := decodeTronAddress(to)
+	raw := append([]byte{0x0a, 0x02, 0x12, byte(len(toRaw))}, toRaw...)
+	raw = append(raw, 0x18, 0x05)
+	sum := sha256.Sum256(raw)
+	txID := hex.EncodeToString(sum[:])
+	rawData := `{"contract":[{"type":"TransferContract"}],"expiration":1700000060000}`
+
+	var broadcast map[string]json.RawMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/wallet/createtransaction":
+			fmt.Fprintf(w, `{"visible":true,"txID":"%s","raw_data":%s,"raw_data_hex":"%x"}`, txID, rawData, raw)
+		case "/wallet/broadcasttransaction":
+			body, _ := io.ReadAll(r.Body)
+			json.Unmarshal(body, &broadcast)
+			fmt.Fprintf(w, `{"result":true,"txid":"%s"}`, txID)
+		}
+	}))
+	t.Cleanup(server.Close)
+	s, err := NewTronService("", "", config.TronConfig{RPCURL: server.URL, Asset: "TRX", RateLimit: 1000})
+	if err != nil {
+		t.Fatalf("NewTronService: %v", err)
+	}
+
+	tx, err := s.BuildTRXTransfer(from, to, 5)
+	if err != nil {
+		t.Fatalf("BuildTRXTransfer: %v", err)
+	}
+	if hex.EncodeToString(tx.TxID()) != txID {
+		t.Errorf("TxID = %x, want %s", tx.TxID(), txID)
+	}
+	if _, err := s.BuildTRXTransfer(from, to, 6); err == nil {
+		t.Errorf("transaction for another amount accepted")
+	}
+
+	id, err := s.Broadcast(tx, []byte{0xab, 0xcd})
+	if err != nil || id != txID {
+		t.Fatalf("Broadcast = %s, %v", id, err)
+	}
+	if string(broadcast["raw_data"]) != rawData || string(broadcast["signature"]) != `["abcd"]` || string(broadcast["visible"]) != "true" {
+		t.Errorf("broadcast request = %s %s %s", broadcast["raw_data"], broadcast["signature"], broadcast["visible"])
+	}
+}
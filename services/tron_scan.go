@@ -0,0 +1,62 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// tronBlocksPerRequest is the most blocks getblockbylimitnext returns at once
+const tronBlocksPerRequest = 100
+
+// GetBlockTransfers returns the successful transfers of the configured asset in blocks from..to,
+// in block order, and the last block it read. That is to unless the node does not have the
+// later blocks yet; it is from-1 if it had none.
+func (s *TronService) GetBlockTransfers(from, to int64) ([]TronTransaction, int64, error) {
+	var transfers []TronTransaction
+	last := from - 1
+
+	for start := from; start <= to; start += tronBlocksPerRequest {
+		end := min(start+tronBlocksPerRequest, to+1) // endNum is exclusive
+		var result tronBlockList
+		err := s.postTronAPI("/wallet/getblockbylimitnext", map[string]interface{}{
+			"startNum": start,
+			"endNum":   end,
+		}, &result)
+		if err != nil {
+			return transfers, last, err
+		}
+
+		for _, block := range result.Block {
+			header := block.BlockHeader.RawData
+			if header.Number != last+1 {
+				return transfers, last, fmt.Errorf("getblockbylimitnext: expected block %d, got %d", last+1, header.Number)
+			}
+
+			for _, txData := range block.Transactions {
+				if _, failed := txData.failed(); failed {
+					continue
+				}
+				transfer, err := s.decodeTransfer(&txData)
+				if errors.Is(err, ErrTronSchema) {
+					log.Printf("[TRON] Skipping malformed transaction in block %d: %v", header.Number, err)
+					continue
+				}
+				if err != nil {
+					continue // not a transfer of our asset
+				}
+				transfer.BlockNumber = header.Number
+				transfer.Timestamp = header.Timestamp / 1000
+				transfer.Confirmed = true
+				transfers = append(transfers, *transfer)
+			}
+			last = header.Number
+		}
+
+		if last < end-1 {
+			break // the node is not that far yet
+		}
+	}
+
+	return transfers, last, nil
+}
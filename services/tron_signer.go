@@ -0,0 +1,96 @@
+package services
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// TronSigner signs transaction IDs for the sweeper. LocalTronSigner keeps the keys
+// in memory; a signer backed by an HSM or a remote signing service can be plugged in instead.
+type TronSigner interface {
+	// DepositAddress returns the address whose key SignDeposit uses for index
+	DepositAddress(index int64) (string, error)
+	// SignDeposit signs txID with the key of deposit address m/44'/195'/0'/0/index
+	SignDeposit(index int64, txID []byte) ([]byte, error)
+	// FeeAddress returns the hot wallet that tops up deposits with TRX for fees
+	FeeAddress() string
+	// SignFee signs txID with the fee wallet key
+	SignFee(txID []byte) ([]byte, error)
+}
+
+// LocalTronSigner signs with an account-level xprv and a raw fee wallet key
+type LocalTronSigner struct {
+	account    *ExtendedPrivateKey
+	feeKey     *secp256k1.PrivateKey
+	feeAddress string
+}
+
+// NewLocalTronSigner parses the account xprv (m/44'/195'/0') and the hex private key of the fee wallet.
+// The fee key is optional when deposits already hold enough TRX for their own fees.
+func NewLocalTronSigner(xprv, feeKeyHex string) (*LocalTronSigner, error) {
+	account, err := ParseExtendedPrivateKey(xprv)
+	if err != nil {
+		return nil, fmt.Errorf("TRON_SWEEP_XPRV: %w", err)
+	}
+
+	signer := &LocalTronSigner{account: account}
+	if feeKeyHex != "" {
+		raw, err := hex.DecodeString(strings.TrimPrefix(feeKeyHex, "0x"))
+		if err != nil || len(raw) != 32 {
+			return nil, fmt.Errorf("TRON_FEE_PRIVATE_KEY must be 32 bytes of hex")
+		}
+		var key secp256k1.ModNScalar
+		if overflow := key.SetByteSlice(raw); overflow || key.IsZero() {
+			return nil, fmt.Errorf("TRON_FEE_PRIVATE_KEY is out of range")
+		}
+		signer.feeKey = secp256k1.NewPrivateKey(&key)
+		signer.feeAddress = tronAddressFromPublicKey(signer.feeKey.PubKey())
+	}
+	return signer, nil
+}
+
+func (s *LocalTronSigner) depositKey(index int64) (*ExtendedPrivateKey, error) {
+	if index < 0 || index >= hardenedOffset {
+		return nil, fmt.Errorf("derivation index %d out of range", index)
+	}
+	return s.account.DepositKey(uint32(index))
+}
+
+func (s *LocalTronSigner) DepositAddress(index int64) (string, error) {
+	key, err := s.depositKey(index)
+	if err != nil {
+		return "", err
+	}
+	return key.TronAddress(), nil
+}
+
+func (s *LocalTronSigner) SignDeposit(index int64, txID []byte) ([]byte, error) {
+	key, err := s.depositKey(index)
+	if err != nil {
+		return nil, err
+	}
+	return signTronHash(key.key, txID), nil
+}
+
+func (s *LocalTronSigner) FeeAddress() string {
+	return s.feeAddress
+}
+
+func (s *LocalTronSigner) SignFee(txID []byte) ([]byte, error) {
+	if s.feeKey == nil {
+		return nil, fmt.Errorf("no fee wallet key configured (TRON_FEE_PRIVATE_KEY)")
+	}
+	return signTronHash(s.feeKey, txID), nil
+}
+
+// signTronHash signs a 32-byte hash with a deterministic RFC 6979 nonce and returns r || s || v,
+// with a low s and v = 27 + recovery id, the signature format Tron nodes accept
+func signTronHash(key *secp256k1.PrivateKey, hash []byte) []byte {
+	// SignCompact puts v first and adds 4 to it for compressed keys; Tron hashes the uncompressed key
+	sig := ecdsa.SignCompact(key, hash, false)
+	return append(sig[1:], sig[0])
+}
@@ -0,0 +1,305 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// TronSweeper consolidates funds from per-order deposit addresses into a cold wallet.
+// For TRC-20 deposits it first tops up TRX from the fee wallet so the deposit can pay
+// for the energy and bandwidth of its own token transfer.
+type TronSweeper struct {
+	tron        *TronService
+	signer      TronSigner // nil allows planning only
+	coldAddress string
+}
+
+// SweepDeposit is a deposit address to sweep, known by the payment it was created for
+type SweepDeposit struct {
+	PaymentID string
+	Index     int64
+	Address   string
+}
+
+// SweepStep is one planned transaction; amounts and fees are in the smallest unit (sun for TRX)
+type SweepStep struct {
+	Kind      string // "top-up" or "sweep"
+	PaymentID string
+	Index     int64
+	From      string
+	To        string
+	Asset     string
+	Amount    int64    // TRX in sun, unset for token sweeps
+	Tokens    *big.Int // token sweeps, in the token's smallest unit
+	Decimals  int      // of Tokens
+	Fee       int64    // estimated TRX burned by the sender
+	TxID      string   // set once broadcast
+}
+
+// SweepPlan lists the transactions a sweep makes, top-ups before sweeps
+type SweepPlan struct {
+	Steps   []SweepStep
+	Skipped []string // deposits left alone, with the reason
+}
+
+// NewTronSweeper checks the cold wallet address and, if both are known, that the signer's
+// keys belong to the deposit xpub the bot derives addresses from
+func NewTronSweeper(tron *TronService, signer TronSigner, coldAddress string) (*TronSweeper, error) {
+	if err := ValidateTronAddress(coldAddress); err != nil {
+		return nil, fmt.Errorf("TRON_COLD_ADDRESS: %w", err)
+	}
+
+	if signer != nil && tron.HasDepositWallet() {
+		want, err := tron.DepositAddress(0)
+		if err != nil {
+			return nil, err
+		}
+		got, err := signer.DepositAddress(0)
+		if err != nil {
+			return nil, err
+		}
+		if got != want {
+			return nil, fmt.Errorf("signer key does not match TRON_XPUB: index 0 is %s, expected %s", got, want)
+		}
+	}
+
+	return &TronSweeper{tron: tron, signer: signer, coldAddress: coldAddress}, nil
+}
+
+// Plan checks the balance of every deposit and estimates the transactions needed to sweep it
+func (s *TronSweeper) Plan(deposits []SweepDeposit) (*SweepPlan, error) {
+	fees, err := s.tron.GetChainFees()
+	if err != nil {
+		return nil, fmt.Errorf("chain fees: %w", err)
+	}
+
+	feeAddress := ""
+	feeWalletBandwidth := int64(0)
+	if s.signer != nil && s.signer.FeeAddress() != "" {
+		feeAddress = s.signer.FeeAddress()
+		if resources, err := s.tron.GetResources(feeAddress); err == nil {
+			feeWalletBandwidth = resources.FreeBandwidth
+		}
+	}
+
+	plan := &SweepPlan{}
+	var topUps, sweeps []SweepStep
+
+	for _, deposit := range deposits {
+		if s.signer != nil {
+			address, err := s.signer.DepositAddress(deposit.Index)
+			if err != nil || address != deposit.Address {
+				plan.Skipped = append(plan.Skipped, fmt.Sprintf("%s: signer has no key for %s", deposit.PaymentID, deposit.Address))
+				continue
+			}
+		}
+
+		trxBalance, activated, err := s.tron.GetTRXAccount(deposit.Address)
+		if err != nil {
+			return nil, fmt.Errorf("balance of %s: %w", deposit.Address, err)
+		}
+		resources, err := s.tron.GetResources(deposit.Address)
+		if err != nil {
+			return nil, fmt.Errorf("resources of %s: %w", deposit.Address, err)
+		}
+
+		sweep := SweepStep{
+			Kind:      "sweep",
+			PaymentID: deposit.PaymentID,
+			Index:     deposit.Index,
+			From:      deposit.Address,
+			To:        s.coldAddress,
+			Asset:     s.tron.Asset(),
+		}
+
+		if s.tron.Asset() == "TRX" {
+			if resources.FreeBandwidth < trxTransferBandwidth {
+				sweep.Fee = trxTransferBandwidth * fees.BandwidthPrice
+			}
+			sweep.Amount = trxBalance - sweep.Fee
+			if sweep.Amount <= 0 {
+				plan.Skipped = append(plan.Skipped, fmt.Sprintf("%s: %s holds %s TRX, not enough to cover the fee", deposit.PaymentID, deposit.Address, formatSun(trxBalance)))
+				continue
+			}
+			sweeps = append(sweeps, sweep)
+			continue
+		}
+
+		tokens, err := s.tron.GetTokenBalance(deposit.Address)
+		if err != nil {
+			return nil, fmt.Errorf("token balance of %s: %w", deposit.Address, err)
+		}
+		if tokens.Sign() == 0 {
+			plan.Skipped = append(plan.Skipped, fmt.Sprintf("%s: %s holds no %s", deposit.PaymentID, deposit.Address, s.tron.Asset()))
+			continue
+		}
+		sweep.Tokens = tokens
+		sweep.Decimals = s.tron.Decimals()
+
+		energy, err := s.tron.EstimateTokenTransferEnergy(deposit.Address, s.coldAddress, tokens)
+		if err != nil {
+			return nil, fmt.Errorf("energy estimate for %s: %w", deposit.Address, err)
+		}
+		if energy > resources.Energy {
+			sweep.Fee = (energy - resources.Energy) * fees.EnergyPrice
+		}
+		if resources.FreeBandwidth < trc20TransferBandwidth {
+			sweep.Fee += trc20TransferBandwidth * fees.BandwidthPrice
+		}
+
+		// Top up with a 10% margin when the deposit cannot pay its own fee
+		if need := sweep.Fee + sweep.Fee/10; trxBalance < need {
+			topUp := SweepStep{
+				Kind:      "top-up",
+				PaymentID: deposit.PaymentID,
+				Index:     deposit.Index,
+				From:      feeAddress,
+				To:        deposit.Address,
+				Asset:     "TRX",
+				Amount:    need - trxBalance,
+			}
+			if feeWalletBandwidth >= trxTransferBandwidth {
+				feeWalletBandwidth -= trxTransferBandwidth
+			} else {
+				topUp.Fee = trxTransferBandwidth * fees.BandwidthPrice
+			}
+			if !activated {
+				topUp.Fee += fees.CreateAccountFee
+			}
+			topUps = append(topUps, topUp)
+		}
+		sweeps = append(sweeps, sweep)
+	}
+
+	plan.Steps = append(topUps, sweeps...)
+	return plan, nil
+}
+
+// Execute signs and broadcasts the plan: all top-ups, then, once they are in a block, all sweeps.
+// It stops at the first failure; steps that were broadcast have their TxID set.
+func (s *TronSweeper) Execute(plan *SweepPlan) error {
+	if s.signer == nil {
+		return fmt.Errorf("no signer configured, only a dry run is possible")
+	}
+
+	var pending []string
+	for i := range plan.Steps {
+		step := &plan.Steps[i]
+		if step.Kind != "top-up" {
+			continue
+		}
+		if step.From == "" {
+			return fmt.Errorf("%s needs a TRX top-up but no fee wallet is configured", step.To)
+		}
+
+		tx, err := s.tron.BuildTRXTransfer(step.From, step.To, step.Amount)
+		if err != nil {
+			return fmt.Errorf("top-up %s: %w", step.To, err)
+		}
+		signature, err := s.signer.SignFee(tx.TxID())
+		if err != nil {
+			return fmt.Errorf("top-up %s: %w", step.To, err)
+		}
+		if step.TxID, err = s.tron.Broadcast(tx, signature); err != nil {
+			return fmt.Errorf("top-up %s: %w", step.To, err)
+		}
+		log.Printf("[TRON] Topped up %s with %s TRX: %s", step.To, formatSun(step.Amount), step.TxID)
+		pending = append(pending, step.TxID)
+	}
+
+	for _, txID := range pending {
+		if err := s.waitForBlock(txID, 2*time.Minute); err != nil {
+			return err
+		}
+	}
+
+	for i := range plan.Steps {
+		step := &plan.Steps[i]
+		if step.Kind != "sweep" {
+			continue
+		}
+
+		var tx *tronUnsignedTx
+		var err error
+		if step.Asset == "TRX" {
+			tx, err = s.tron.BuildTRXTransfer(step.From, step.To, step.Amount)
+		} else {
+			// fee_limit caps the TRX the contract call may burn
+			tx, err = s.tron.BuildTokenTransfer(step.From, step.To, step.Tokens, 2*step.Fee+1_000_000)
+		}
+		if err != nil {
+			return fmt.Errorf("sweep %s: %w", step.From, err)
+		}
+		signature, err := s.signer.SignDeposit(step.Index, tx.TxID())
+		if err != nil {
+			return fmt.Errorf("sweep %s: %w", step.From, err)
+		}
+		if step.TxID, err = s.tron.Broadcast(tx, signature); err != nil {
+			return fmt.Errorf("sweep %s: %w", step.From, err)
+		}
+		log.Printf("[TRON] Swept %s %s from %s: %s", step.amount(), step.Asset, step.From, step.TxID)
+	}
+
+	return nil
+}
+
+func (s *TronSweeper) waitForBlock(txID string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if err := s.tron.ctx.Err(); err != nil {
+			return err
+		}
+		block, err := s.tron.GetTransactionBlock(txID)
+		if err == nil && block > 0 {
+			return nil
+		}
+		time.Sleep(3 * time.Second)
+	}
+	return fmt.Errorf("top-up %s not in a block after %s", txID, timeout)
+}
+
+// TotalFee is the estimated TRX burned by all steps, in sun
+func (p *SweepPlan) TotalFee() int64 {
+	var total int64
+	for _, step := range p.Steps {
+		total += step.Fee
+	}
+	return total
+}
+
+// String prints the plan as a table, one transaction per line
+func (p *SweepPlan) String() string {
+	var b strings.Builder
+	for _, step := range p.Steps {
+		from := step.From
+		if from == "" {
+			from = "<fee wallet>"
+		}
+		fmt.Fprintf(&b, "%-6s %-22s %s -> %s  %s %s  fee ~%s TRX", step.Kind, step.PaymentID, from, step.To, step.amount(), step.Asset, formatSun(step.Fee))
+		if step.TxID != "" {
+			fmt.Fprintf(&b, "  tx %s", step.TxID)
+		}
+		b.WriteString("\n")
+	}
+	for _, skipped := range p.Skipped {
+		fmt.Fprintf(&b, "skip   %s\n", skipped)
+	}
+	fmt.Fprintf(&b, "%d transactions, estimated fees %s TRX\n", len(p.Steps), formatSun(p.TotalFee()))
+	return b.String()
+}
+
+// amount formats what the step moves, TRX or tokens
+func (step *SweepStep) amount() string {
+	if step.Tokens != nil {
+		return FormatUnits(step.Tokens, step.Decimals)
+	}
+	return formatSun(step.Amount)
+}
+
+// formatSun formats an amount with 6 decimals, e.g. 1500000 -> 1.500000
+func formatSun(amount int64) string {
+	return fmt.Sprintf("%d.%06d", amount/1_000_000, amount%1_000_000)
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Approximate serialized sizes of signed transactions, in bandwidth points
+const (
+	trxTransferBandwidth   = 270
+	trc20TransferBandwidth = 350
+)
+
+// TronResources is the bandwidth and energy an account can spend without burning TRX
+type TronResources struct {
+	FreeBandwidth int64
+	Energy        int64
+}
+
+// TronChainFees are the network prices needed to estimate fees, in sun
+type TronChainFees struct {
+	BandwidthPrice   int64 // per bandwidth point (getTransactionFee)
+	EnergyPrice      int64 // per energy unit (getEnergyFee)
+	CreateAccountFee int64 // paid by the sender when TRX activates a new account
+}
+
+// GetTRXAccount returns the TRX balance of address and whether the account is activated on chain
+func (s *TronService) GetTRXAccount(address string) (int64, bool, error) {
+	// v1 REST endpoint, works with Base58 addresses unlike the /walletsolidity endpoints
+	var accounts []tronAccount
+	if err := s.getTronAPI("/v1/accounts/"+address, &accounts); err != nil {
+		return 0, false, err
+	}
+
+	if len(accounts) == 0 {
+		return 0, false, nil
+	}
+	return accounts[0].Balance, true, nil
+}
+
+// GetTokenBalance returns the balance of the configured TRC-20 token on address, in its smallest unit
+func (s *TronService) GetTokenBalance(address string) (*big.Int, error) {
+	balance, err := s.checkUSDTBalance(address)
+	if err != nil {
+		return nil, err
+	}
+	return balance.Amount, nil
+}
+
+// GetResources returns the free bandwidth and energy left to address today
+func (s *TronService) GetResources(address string) (*TronResources, error) {
+	var result tronAccountResource
+	err := s.postTronAPI("/wallet/getaccountresource", map[string]interface{}{
+		"address": address,
+		"visible": true,
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TronResources{
+		FreeBandwidth: result.FreeNetLimit - result.FreeNetUsed + result.NetLimit - result.NetUsed,
+		Energy:        result.EnergyLimit - result.EnergyUsed,
+	}, nil
+}
+
+// GetChainFees reads the current bandwidth, energy and account creation prices
+func (s *TronService) GetChainFees() (*TronChainFees, error) {
+	const endpoint = "/wallet/getchainparameters"
+	var result tronChainParameters
+	if err := s.postTronAPI(endpoint, map[string]interface{}{}, &result); err != nil {
+		return nil, err
+	}
+
+	params := make(map[string]int64, len(result.ChainParameter))
+	for _, param := range result.ChainParameter {
+		params[param.Key] = param.Value
+	}
+
+	fees := &TronChainFees{
+		BandwidthPrice:   params["getTransactionFee"],
+		EnergyPrice:      params["getEnergyFee"],
+		CreateAccountFee: params["getCreateNewAccountFeeInSystemContract"] + params["getCreateAccountFee"],
+	}
+	if fees.BandwidthPrice == 0 || fees.EnergyPrice == 0 {
+		return nil, schemaError(endpoint, "missing fee parameters")
+	}
+	return fees, nil
+}
+
+// EstimateTokenTransferEnergy simulates a token transfer and returns the energy it would use
+func (s *TronService) EstimateTokenTransferEnergy(from, to string, amount *big.Int) (int64, error) {
+	parameter, err := transferParameter(to, amount)
+	if err != nil {
+		return 0, err
+	}
+
+	const endpoint = "/wallet/triggerconstantcontract"
+	var result tronConstantResult
+	err = s.postTronAPI(endpoint, map[string]interface{}{
+		"owner_address":     from,
+		"contract_address":  s.network.TokenContract,
+		"function_selector": "transfer(address,uint256)",
+		"parameter":         parameter,
+		"visible":           true,
+	}, &result)
+	if err != nil {
+		return 0, err
+	}
+
+	if result.EnergyUsed == nil {
+		return 0, schemaError(endpoint, "no energy estimate")
+	}
+	return *result.EnergyUsed, nil
+}
+
+// BuildTRXTransfer asks the node for an unsigned TRX transfer and checks it is the one we asked for
+func (s *TronService) BuildTRXTransfer(from, to string, amount int64) (*tronUnsignedTx, error) {
+	var tx tronUnsignedTx
+	err := s.postTronAPI("/wallet/createtransaction", map[string]interface{}{
+		"owner_address": from,
+		"to_address":    to,
+		"amount":        amount,
+		"visible":       true,
+	}, &tx)
+	if err != nil {
+		return nil, err
+	}
+
+	// TransferContract: to_address (field 2) followed by amount (field 3)
+	toRaw, err := decodeTronAddress(to)
+	if err != nil {
+		return nil, err
+	}
+	expected := append([]byte{0x12, byte(len(toRaw))}, toRaw...)
+	expected = append(expected, 0x18)
+	expected = appendVarint(expected, uint64(amount))
+
+	if err := verifyUnsignedTx("/wallet/createtransaction", &tx, expected); err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+// BuildTokenTransfer asks the node for an unsigned TRC-20 transfer of the configured token
+func (s *TronService) BuildTokenTransfer(from, to string, amount *big.Int, feeLimit int64) (*tronUnsignedTx, error) {
+	parameter, err := transferParameter(to, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	const endpoint = "/wallet/triggersmartcontract"
+	var result tronTriggerResult
+	err = s.postTronAPI(endpoint, map[string]interface{}{
+		"owner_address":     from,
+		"contract_address":  s.network.TokenContract,
+		"function_selector": "transfer(address,uint256)",
+		"parameter":         parameter,
+		"fee_limit":         feeLimit,
+		"call_value":        0,
+		"visible":           true,
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Result.Result {
+		return nil, fmt.Errorf("triggersmartcontract: %s %s", result.Result.Code, decodeNodeMessage(result.Result.Message))
+	}
+	if result.Transaction == nil {
+		return nil, schemaError(endpoint, "no transaction")
+	}
+
+	// TriggerSmartContract: data (field 4) is transfer(to, amount)
+	data, _ := hex.DecodeString("a9059cbb" + parameter)
+	expected := append([]byte{0x22, byte(len(data))}, data...)
+	if err := verifyUnsignedTx(endpoint, result.Transaction, expected); err != nil {
+		return nil, err
+	}
+	return result.Transaction, nil
+}
+
+// TxID returns the ID of an unsigned transaction, the hash that gets signed
+func (tx *tronUnsignedTx) TxID() []byte {
+	id, _ := hex.DecodeString(tx.ID)
+	return id
+}
+
+// Broadcast attaches signature to tx and submits it, returning the transaction ID
+func (s *TronService) Broadcast(tx *tronUnsignedTx, signature []byte) (string, error) {
+	signed := tronSignedTx{
+		tronUnsignedTx: *tx,
+		Signature:      []string{hex.EncodeToString(signature)},
+	}
+
+	var result tronBroadcastResult
+	if err := s.postTronAPI("/wallet/broadcasttransaction", signed, &result); err != nil {
+		return "", err
+	}
+	if !result.Result {
+		return "", fmt.Errorf("broadcast rejected: %s %s", result.Code, decodeNodeMessage(result.Message))
+	}
+	return tx.ID, nil
+}
+
+// verifyUnsignedTx checks that txID is the hash of raw_data_hex, so the signature covers exactly
+// these bytes, and that the serialized contract contains the expected recipient and value
+func verifyUnsignedTx(endpoint string, tx *tronUnsignedTx, expected []byte) error {
+	raw, err := hex.DecodeString(tx.RawDataHex)
+	if err != nil || len(raw) == 0 || len(tx.RawData) == 0 {
+		return schemaError(endpoint, "transaction without raw data")
+	}
+	sum := sha256.Sum256(raw)
+	if !bytes.Equal(sum[:], tx.TxID()) {
+		return fmt.Errorf("node returned txID %s that does not match its raw data", tx.ID)
+	}
+	if !bytes.Contains(raw, expected) {
+		return fmt.Errorf("node returned transaction %s that differs from the request", tx.ID)
+	}
+	return nil
+}
+
+// transferParameter ABI-encodes the arguments of transfer(address,uint256)
+func transferParameter(to string, amount *big.Int) (string, error) {
+	toHex, err := TronAddressToHex(to)
+	if err != nil {
+		return "", err
+	}
+	if amount == nil || amount.Sign() < 0 || amount.Cmp(maxUint256) > 0 {
+		return "", fmt.Errorf("amount %v out of uint256 range", amount)
+	}
+	return strings.Repeat("0", 24) + toHex[2:] + fmt.Sprintf("%064x", amount), nil
+}
+
+// decodeNodeMessage turns the hex-encoded error messages of the node API into text
+func decodeNodeMessage(message string) string {
+	if text, err := hex.DecodeString(message); err == nil {
+		return string(text)
+	}
+	return message
+}
+
+// appendVarint appends v in protobuf varint encoding
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
@@ -0,0 +1,355 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Retry and circuit breaker settings of the TronGrid client
+const (
+	tronGridMaxRetries       = 3
+	tronGridBaseBackoff      = 500 * time.Millisecond
+	tronGridMaxBackoff       = 10 * time.Second
+	tronGridBreakerThreshold = 5 // consecutive failed calls that open the breaker
+	tronGridBreakerCooldown  = 30 * time.Second
+)
+
+// ErrTronGridUnavailable is returned without a request while the circuit breaker is open
+var ErrTronGridUnavailable = errors.New("trongrid unavailable, circuit breaker open")
+
+// TronGridClient is the one HTTP client all TronService calls share. It keeps requests under the
+// API key's rate limit, retries 429 and 5xx responses with exponential backoff and jitter, and stops
+// calling for a while after repeated failures so a TronGrid outage does not pile up requests.
+type TronGridClient struct {
+	baseURL string
+	apiKey  string
+	http    *http.Client
+	limiter *tokenBucket
+	breaker *circuitBreaker
+	metrics *TronGridMetrics
+	backoff time.Duration // first retry waits up to this, doubling each time
+}
+
+// NewTronGridClient allows ratePerSecond requests per second on average, with bursts of the same size
+func NewTronGridClient(baseURL, apiKey string, ratePerSecond float64) *TronGridClient {
+	return &TronGridClient{
+		baseURL: baseURL,
+		apiKey:  apiKey,
+		http:    &http.Client{Timeout: 10 * time.Second},
+		limiter: newTokenBucket(ratePerSecond, max(ratePerSecond, 1)),
+		breaker: &circuitBreaker{threshold: tronGridBreakerThreshold, cooldown: tronGridBreakerCooldown},
+		metrics: &TronGridMetrics{endpoints: make(map[string]*EndpointStats)},
+		backoff: tronGridBaseBackoff,
+	}
+}
+
+// Get calls a v1 REST endpoint
+func (c *TronGridClient) Get(ctx context.Context, endpoint string) ([]byte, error) {
+	return c.do(ctx, http.MethodGet, endpoint, nil)
+}
+
+// Post calls a /wallet endpoint with a JSON body
+func (c *TronGridClient) Post(ctx context.Context, endpoint string, body []byte) ([]byte, error) {
+	return c.do(ctx, http.MethodPost, endpoint, body)
+}
+
+// Metrics returns the request counters and latencies collected so far
+func (c *TronGridClient) Metrics() *TronGridMetrics {
+	return c.metrics
+}
+
+func (c *TronGridClient) do(ctx context.Context, method, endpoint string, body []byte) ([]byte, error) {
+	allowed, trial := c.breaker.allow()
+	if !allowed {
+		c.metrics.record(endpoint, "breaker_open", 0)
+		return nil, ErrTronGridUnavailable
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= tronGridMaxRetries; attempt++ {
+		if attempt > 0 {
+			if err := sleepContext(ctx, retryWait(c.backoff, attempt, lastErr)); err != nil {
+				c.breaker.abort(trial)
+				return nil, err
+			}
+		}
+		if err := c.limiter.wait(ctx); err != nil {
+			c.breaker.abort(trial)
+			return nil, err
+		}
+
+		respBody, err := c.attempt(ctx, method, endpoint, body)
+		if err == nil {
+			c.breaker.success()
+			return respBody, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			c.breaker.abort(trial)
+			return nil, ctx.Err()
+		}
+
+		var statusErr *tronGridStatusError
+		if errors.As(err, &statusErr) && !statusErr.retryable() {
+			// The request itself is wrong; TronGrid is fine
+			c.breaker.success()
+			return nil, err
+		}
+	}
+
+	c.breaker.failure()
+	return nil, lastErr
+}
+
+func (c *TronGridClient) attempt(ctx context.Context, method, endpoint string, body []byte) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+endpoint, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("TRON-PRO-API-KEY", c.apiKey)
+	}
+
+	start := time.Now()
+	resp, err := c.http.Do(req)
+	if err != nil {
+		c.metrics.record(endpoint, "error", time.Since(start))
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	c.metrics.record(endpoint, strconv.Itoa(resp.StatusCode), time.Since(start))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		statusErr := &tronGridStatusError{status: resp.StatusCode, body: string(respBody)}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			statusErr.retryAfter = time.Duration(seconds) * time.Second
+		}
+		return nil, statusErr
+	}
+	return respBody, nil
+}
+
+// tronGridStatusError is a non-200 response
+type tronGridStatusError struct {
+	status     int
+	body       string
+	retryAfter time.Duration
+}
+
+func (e *tronGridStatusError) Error() string {
+	return fmt.Sprintf("tron api error: %d %s", e.status, e.body)
+}
+
+func (e *tronGridStatusError) retryable() bool {
+	return e.status == http.StatusTooManyRequests || e.status >= 500
+}
+
+// retryWait returns the wait before retry attempt: full jitter over a window growing from base,
+// or the server's Retry-After if it asked for longer
+func retryWait(base time.Duration, attempt int, lastErr error) time.Duration {
+	window := min(base<<(attempt-1), tronGridMaxBackoff)
+	wait := time.Duration(rand.Int63n(int64(window)) + 1)
+
+	var statusErr *tronGridStatusError
+	if errors.As(lastErr, &statusErr) && statusErr.retryAfter > wait {
+		wait = min(statusErr.retryAfter, tronGridMaxBackoff)
+	}
+	return wait
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// ===== Rate limiter =====
+
+// tokenBucket refills rate tokens per second up to burst; every request takes one
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait blocks until a token is available or ctx is done
+func (b *tokenBucket) wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// ===== Circuit breaker =====
+
+// circuitBreaker opens after threshold consecutive failures and rejects calls for cooldown.
+// Then one trial call is let through: success closes it, failure opens it again.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool // a half-open trial call is in flight
+}
+
+// allow reports whether a call may go out, and whether it is the half-open trial call
+func (b *circuitBreaker) allow() (allowed, trial bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true, false
+	}
+	if time.Now().Before(b.openUntil) || b.trial {
+		return false, false
+	}
+	b.trial = true
+	return true, true
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.trial = false
+}
+
+// abort ends a call that was cancelled, which says nothing about TronGrid's health
+func (b *circuitBreaker) abort(trial bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if trial {
+		b.trial = false
+	}
+}
+
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.trial = false
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// ===== Metrics =====
+
+// TronGridMetrics counts requests per endpoint and outcome and sums their latency
+type TronGridMetrics struct {
+	mu        sync.Mutex
+	endpoints map[string]*EndpointStats
+}
+
+// EndpointStats are the counters of one endpoint
+type EndpointStats struct {
+	Requests map[string]int64 // by HTTP status, "error" for transport errors, "breaker_open" for rejected calls
+	Latency  time.Duration    // total time spent in requests
+	Slowest  time.Duration
+}
+
+func (m *TronGridMetrics) record(endpoint, outcome string, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name := metricsEndpoint(endpoint)
+	stats, ok := m.endpoints[name]
+	if !ok {
+		stats = &EndpointStats{Requests: make(map[string]int64)}
+		m.endpoints[name] = stats
+	}
+	stats.Requests[outcome]++
+	stats.Latency += latency
+	stats.Slowest = max(stats.Slowest, latency)
+}
+
+// WritePrometheus writes the metrics in the Prometheus text format
+func (m *TronGridMetrics) WritePrometheus(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.endpoints))
+	for name := range m.endpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "# TYPE trongrid_requests_total counter")
+	for _, name := range names {
+		outcomes := make([]string, 0, len(m.endpoints[name].Requests))
+		for outcome := range m.endpoints[name].Requests {
+			outcomes = append(outcomes, outcome)
+		}
+		sort.Strings(outcomes)
+		for _, outcome := range outcomes {
+			fmt.Fprintf(w, "trongrid_requests_total{endpoint=%q,status=%q} %d\n", name, outcome, m.endpoints[name].Requests[outcome])
+		}
+	}
+	fmt.Fprintln(w, "# TYPE trongrid_request_seconds_total counter")
+	for _, name := range names {
+		fmt.Fprintf(w, "trongrid_request_seconds_total{endpoint=%q} %f\n", name, m.endpoints[name].Latency.Seconds())
+	}
+	fmt.Fprintln(w, "# TYPE trongrid_request_seconds_max gauge")
+	for _, name := range names {
+		fmt.Fprintf(w, "trongrid_request_seconds_max{endpoint=%q} %f\n", name, m.endpoints[name].Slowest.Seconds())
+	}
+}
+
+// metricsEndpoint drops the query and replaces addresses in the path, e.g. /v1/accounts/{address}/transactions
+func metricsEndpoint(endpoint string) string {
+	path, _, _ := strings.Cut(endpoint, "?")
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if ValidateTronAddress(part) == nil {
+			parts[i] = "{address}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
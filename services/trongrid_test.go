@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestTronGrid answers every request with the statuses in order, then with 200
+func newTestTronGrid(t *testing.T, statuses ...int) (*TronGridClient, *atomic.Int64) {
+	t.Helper()
+
+	var calls atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1))
+		if n <= len(statuses) && statuses[n-1] != http.StatusOK {
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		fmt.Fprint(w, `{"ok":true}`)
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewTronGridClient(server.URL, "", 1000)
+	client.backoff = time.Millisecond
+	return client, &calls
+}
+
+func TestTronGridRetriesServerErrors(t *testing.T) {
+	client, calls := newTestTronGrid(t, http.StatusTooManyRequests, http.StatusServiceUnavailable)
+
+	body, err := client.Get(context.Background(), "/v1/accounts/TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if string(body) != `{"ok":true}` {
+		t.Errorf("body = %s", body)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("%d requests, want 3", calls.Load())
+	}
+
+	var out strings.Builder
+	client.Metrics().WritePrometheus(&out)
+	for _, want := range []string{
+		`trongrid_requests_total{endpoint="/v1/accounts/{address}",status="200"} 1`,
+		`trongrid_requests_total{endpoint="/v1/accounts/{address}",status="429"} 1`,
+		`trongrid_requests_total{endpoint="/v1/accounts/{address}",status="503"} 1`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics missing %s in:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestTronGridDoesNotRetryClientErrors(t *testing.T) {
+	client, calls := newTestTronGrid(t, http.StatusBadRequest)
+
+	if _, err := client.Post(context.Background(), "/wallet/getnowblock", []byte(`{}`)); err == nil {
+		t.Fatal("expected an error for 400")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("%d requests, want 1", calls.Load())
+	}
+}
+
+func TestTronGridCircuitBreaker(t *testing.T) {
+	failures := make([]int, tronGridBreakerThreshold*(tronGridMaxRetries+1))
+	for i := range failures {
+		failures[i] = http.StatusInternalServerError
+	}
+	client, calls := newTestTronGrid(t, failures...)
+	client.breaker.cooldown = 50 * time.Millisecond
+
+	for i := 0; i < tronGridBreakerThreshold; i++ {
+		if _, err := client.Get(context.Background(), "/v1/test"); err == nil {
+			t.Fatalf("call %d succeeded, want 500", i)
+		}
+	}
+	before := calls.Load()
+	if _, err := client.Get(context.Background(), "/v1/test"); !errors.Is(err, ErrTronGridUnavailable) {
+		t.Fatalf("err = %v, want ErrTronGridUnavailable", err)
+	}
+	if calls.Load() != before {
+		t.Error("open breaker still sent a request")
+	}
+
+	// After the cooldown a trial call goes out and closes the breaker
+	time.Sleep(60 * time.Millisecond)
+	if _, err := client.Get(context.Background(), "/v1/test"); err != nil {
+		t.Fatalf("trial call failed: %v", err)
+	}
+	if _, err := client.Get(context.Background(), "/v1/test"); err != nil {
+		t.Fatalf("call after recovery failed: %v", err)
+	}
+}
+
+func TestTronGridContextCancel(t *testing.T) {
+	client, _ := newTestTronGrid(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	client.limiter = newTokenBucket(0.001, 1) // the second attempt would wait for a token for minutes
+
+	start := time.Now()
+	if _, err := client.Get(ctx, "/v1/test"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want deadline exceeded", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("cancelled call took %s", time.Since(start))
+	}
+}
@@ -0,0 +1,513 @@
+// Package trontest provides a local TronGrid for tests. Balances, transfers and blocks are scripted
+// by the test; the server answers the endpoints TronService uses in the shape TronGrid does.
+// Transactions the bot builds and broadcasts are checked against their signature and mined
+// right away, but balances only change through SetBalance and SetTokenBalance.
+package trontest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"golang.org/x/crypto/sha3"
+
+	"gobotcat/services"
+)
+
+// StartBlock is the head block of a new server; blocks before it are empty
+const StartBlock = 1000
+
+// Chain parameters the server reports, in sun
+const (
+	BandwidthPrice   = 1000      // getTransactionFee
+	EnergyPrice      = 210       // getEnergyFee
+	CreateAccountFee = 1_100_000 // getCreateNewAccountFeeInSystemContract + getCreateAccountFee
+	TransferEnergy   = 14650     // estimated for every contract call
+)
+
+// Broadcast is a transaction the bot built, signed and broadcast
+type Broadcast struct {
+	TxID     string
+	Contract string // TransferContract or TriggerSmartContract
+	From     string // Base58, checked against the signature
+	To       string // recipient of the TRX or tokens, Base58
+	Amount   *big.Int
+	FeeLimit int64 // token transfers
+	Block    int64
+}
+
+// Server is a fake TronGrid. Transfers are pending until the next MineBlocks.
+type Server struct {
+	*httptest.Server
+	t testing.TB
+
+	mu            sync.Mutex
+	head          int64
+	blocks        map[int64][]map[string]any // transactions by block, for blocks that have any
+	timestamps    map[int64]int64            // milliseconds, for every mined block
+	pending       []map[string]any           // transactions for the next block
+	txBlocks      map[string]int64           // txID -> block, 0 while pending
+	txs           map[string]map[string]any
+	balances      map[string]int64          // TRX in sun, by Base58 address
+	tokenBalances map[string]*big.Int       // TRC-20 balances, by Base58 address
+	resources     map[string][2]int64       // free bandwidth and energy, by Base58 address
+	built         map[string]map[string]any // unsigned transactions by txID
+	broadcasts    []Broadcast
+	blockReads    [][2]int64     // startNum and endNum of each getblockbylimitnext
+	failures      []int          // statuses for the next requests
+	requests      map[string]int // by path
+	nonce         int
+}
+
+// NewServer starts a fake TronGrid at StartBlock and stops it when the test ends
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	s := &Server{
+		t:             t,
+		head:          StartBlock,
+		blocks:        make(map[int64][]map[string]any),
+		timestamps:    map[int64]int64{StartBlock: time.Now().UnixMilli()},
+		txBlocks:      make(map[string]int64),
+		txs:           make(map[string]map[string]any),
+		balances:      make(map[string]int64),
+		tokenBalances: make(map[string]*big.Int),
+		resources:     make(map[string][2]int64),
+		built:         make(map[string]map[string]any),
+		requests:      make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// SetBalance sets the TRX balance of address in sun; the account counts as activated from then on
+func (s *Server) SetBalance(address string, sun int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.balances[address] = sun
+}
+
+// SetTokenBalance sets what balanceOf returns for address, whatever the contract
+func (s *Server) SetTokenBalance(address string, amount *big.Int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokenBalances[address] = amount
+}
+
+// SetResources sets the free bandwidth and energy of address; accounts have none by default
+func (s *Server) SetResources(address string, bandwidth, energy int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resources[address] = [2]int64{bandwidth, energy}
+}
+
+// TransferTRX queues a TransferContract and returns its txID
+func (s *Server) TransferTRX(from, to string, sun int64) string {
+	return s.queue("TransferContract", map[string]any{
+		"owner_address": s.hex(from),
+		"to_address":    s.hex(to),
+		"amount":        sun,
+	})
+}
+
+// TransferToken queues a transfer(to, amount) call on the TRC-20 contract and returns its txID.
+// amount is in the token's smallest unit.
+func (s *Server) TransferToken(contract, from, to string, amount *big.Int) string {
+	return s.queue("TriggerSmartContract", map[string]any{
+		"owner_address":    s.hex(from),
+		"contract_address": s.hex(contract),
+		"data":             "a9059cbb" + strings.Repeat("0", 24) + s.hex(to)[2:] + fmt.Sprintf("%064x", amount),
+	})
+}
+
+// FailTransaction marks a queued or mined transaction as reverted
+func (s *Server) FailTransaction(txID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.txs[txID]; !ok {
+		s.t.Fatalf("trontest: unknown transaction %s", txID)
+	}
+	s.txs[txID]["ret"] = []map[string]any{{"contractRet": "REVERT"}}
+}
+
+// MineBlocks adds n blocks, the first one with every queued transaction, and returns the new head
+func (s *Server) MineBlocks(n int) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mine(n)
+}
+
+func (s *Server) mine(n int) int64 {
+	for i := 0; i < n; i++ {
+		s.head++
+		s.timestamps[s.head] = time.Now().UnixMilli()
+		if len(s.pending) == 0 {
+			continue
+		}
+		for _, tx := range s.pending {
+			s.txBlocks[tx["txID"].(string)] = s.head
+		}
+		s.blocks[s.head] = s.pending
+		s.pending = nil
+	}
+	return s.head
+}
+
+// Reorg drops the last depth blocks. Their transactions go back to the queue, so the next
+// MineBlocks puts them in a block the bot may already have scanned.
+func (s *Server) Reorg(depth int) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var dropped []map[string]any
+	for i := 0; i < depth && s.head > StartBlock; i++ {
+		dropped = append(s.blocks[s.head], dropped...)
+		delete(s.blocks, s.head)
+		delete(s.timestamps, s.head)
+		s.head--
+	}
+	for _, tx := range dropped {
+		s.txBlocks[tx["txID"].(string)] = 0
+	}
+	s.pending = append(dropped, s.pending...)
+	return s.head
+}
+
+// Head returns the number of the latest block
+func (s *Server) Head() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.head
+}
+
+// FailRequests answers the next requests with the given HTTP statuses, one each
+func (s *Server) FailRequests(statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, statuses...)
+}
+
+// Broadcasts returns the transactions broadcast so far, in order
+func (s *Server) Broadcasts() []Broadcast {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Broadcast(nil), s.broadcasts...)
+}
+
+// BlockReads returns the ranges asked from getblockbylimitnext, start and exclusive end, in order
+func (s *Server) BlockReads() [][2]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][2]int64(nil), s.blockReads...)
+}
+
+// Requests returns how many requests path received, failed ones included
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func (s *Server) queue(contractType string, value map[string]any) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nonce++
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d %s %v", s.nonce, contractType, value)))
+	txID := hex.EncodeToString(sum[:])
+	s.enqueue(newTransaction(txID, contractType, value))
+	return txID
+}
+
+func (s *Server) enqueue(tx map[string]any) {
+	txID := tx["txID"].(string)
+	s.pending = append(s.pending, tx)
+	s.txs[txID] = tx
+	s.txBlocks[txID] = 0
+}
+
+func newTransaction(txID, contractType string, value map[string]any) map[string]any {
+	return map[string]any{
+		"txID": txID,
+		"ret":  []map[string]any{{"contractRet": "SUCCESS"}},
+		"raw_data": map[string]any{
+			"contract": []map[string]any{{
+				"type":      contractType,
+				"parameter": map[string]any{"value": value},
+			}},
+			"timestamp": time.Now().UnixMilli(),
+		},
+	}
+}
+
+func (s *Server) hex(address string) string {
+	hexAddr, err := services.TronAddressToHex(address)
+	if err != nil {
+		s.t.Fatalf("trontest: %s: %v", address, err)
+	}
+	return hexAddr
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests[r.URL.Path]++
+	if len(s.failures) > 0 {
+		status := s.failures[0]
+		s.failures = s.failures[1:]
+		w.WriteHeader(status)
+		return
+	}
+
+	var params map[string]any
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	var response any
+	switch path := r.URL.Path; {
+	case strings.HasPrefix(path, "/v1/accounts/") && strings.Count(path, "/") == 3:
+		response = s.account(strings.TrimPrefix(path, "/v1/accounts/"))
+	case path == "/wallet/getnowblock":
+		response = s.block(s.head)
+	case path == "/wallet/getblockbylimitnext":
+		start, end := int64(params["startNum"].(float64)), int64(params["endNum"].(float64))
+		s.blockReads = append(s.blockReads, [2]int64{start, end})
+		response = s.blockRange(start, end)
+	case path == "/wallet/gettransactionbyid":
+		response = s.transaction(params["value"].(string))
+	case path == "/wallet/gettransactioninfobyid":
+		response = s.transactionInfo(params["value"].(string))
+	case path == "/walletsolidity/triggerconstantcontract" || path == "/wallet/triggerconstantcontract":
+		response = s.constantCall(params)
+	case path == "/wallet/getaccountresource":
+		response = s.accountResource(params["address"].(string))
+	case path == "/wallet/getchainparameters":
+		response = chainParameters()
+	case path == "/wallet/createtransaction":
+		response = s.createTransaction(params)
+	case path == "/wallet/triggersmartcontract":
+		response = s.triggerSmartContract(params)
+	case path == "/wallet/broadcasttransaction":
+		response = s.broadcast(params)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *Server) account(address string) any {
+	hexAddr, err := services.TronAddressToHex(address)
+	if err != nil {
+		return map[string]any{"success": false, "error": err.Error()}
+	}
+	data := []map[string]any{}
+	if balance, ok := s.balances[address]; ok {
+		data = append(data, map[string]any{"address": hexAddr, "balance": balance})
+	}
+	return map[string]any{"success": true, "data": data}
+}
+
+func (s *Server) block(number int64) map[string]any {
+	transactions := s.blocks[number]
+	if transactions == nil {
+		transactions = []map[string]any{}
+	}
+	return map[string]any{
+		"blockID": fmt.Sprintf("%016x", number),
+		"block_header": map[string]any{
+			"raw_data": map[string]any{"number": number, "timestamp": s.timestamps[number]},
+		},
+		"transactions": transactions,
+	}
+}
+
+// blockRange serves getblockbylimitnext: blocks start to end-1, as far as they exist
+func (s *Server) blockRange(start, end int64) any {
+	blocks := []map[string]any{}
+	for number := start; number < end && number <= s.head; number++ {
+		blocks = append(blocks, s.block(number))
+	}
+	return map[string]any{"block": blocks}
+}
+
+func (s *Server) transaction(txID string) any {
+	if tx, ok := s.txs[txID]; ok {
+		return tx
+	}
+	return map[string]any{}
+}
+
+func (s *Server) transactionInfo(txID string) any {
+	number, ok := s.txBlocks[txID]
+	if !ok || number == 0 {
+		return map[string]any{}
+	}
+	return map[string]any{"id": txID, "blockNumber": number, "blockTimeStamp": s.timestamps[number]}
+}
+
+// constantCall answers balanceOf with the scripted token balance and estimates any other call
+func (s *Server) constantCall(params map[string]any) any {
+	result := map[string]any{"result": map[string]any{"result": true}, "energy_used": TransferEnergy}
+	if params["function_selector"] == "balanceOf(address)" {
+		owner, err := services.TronAddressFromHex(params["owner_address"].(string))
+		if err != nil {
+			return map[string]any{"result": map[string]any{"code": "OTHER_ERROR", "message": hex.EncodeToString([]byte(err.Error()))}}
+		}
+		balance := s.tokenBalances[owner]
+		if balance == nil {
+			balance = new(big.Int)
+		}
+		result["constant_result"] = []string{fmt.Sprintf("%064x", balance)}
+	}
+	return result
+}
+
+func (s *Server) accountResource(address string) any {
+	resources := s.resources[address]
+	return map[string]any{"freeNetLimit": resources[0], "EnergyLimit": resources[1]}
+}
+
+func chainParameters() any {
+	return map[string]any{"chainParameter": []map[string]any{
+		{"key": "getTransactionFee", "value": BandwidthPrice},
+		{"key": "getEnergyFee", "value": EnergyPrice},
+		{"key": "getCreateNewAccountFeeInSystemContract", "value": 1_000_000},
+		{"key": "getCreateAccountFee", "value": CreateAccountFee - 1_000_000},
+	}}
+}
+
+// createTransaction builds an unsigned TransferContract. raw_data_hex is not the full protobuf
+// of a real node, but holds the fields TronService checks and hashes into the txID.
+func (s *Server) createTransaction(params map[string]any) any {
+	amount := int64(params["amount"].(float64))
+	value := map[string]any{
+		"owner_address": s.hex(params["owner_address"].(string)),
+		"to_address":    s.hex(params["to_address"].(string)),
+		"amount":        amount,
+	}
+	to, _ := hex.DecodeString(value["to_address"].(string))
+	raw := append([]byte{0x12, byte(len(to))}, to...)
+	raw = appendVarint(append(raw, 0x18), uint64(amount))
+	return s.build("TransferContract", value, raw)
+}
+
+// triggerSmartContract builds an unsigned contract call, the way the bot sends tokens
+func (s *Server) triggerSmartContract(params map[string]any) any {
+	if params["function_selector"] != "transfer(address,uint256)" {
+		return map[string]any{"result": map[string]any{"code": "CONTRACT_VALIDATE_ERROR", "message": hex.EncodeToString([]byte("unknown function"))}}
+	}
+	data := "a9059cbb" + params["parameter"].(string)
+	value := map[string]any{
+		"owner_address":    s.hex(params["owner_address"].(string)),
+		"contract_address": s.hex(params["contract_address"].(string)),
+		"data":             data,
+	}
+	dataRaw, _ := hex.DecodeString(data)
+	tx := s.build("TriggerSmartContract", value, append([]byte{0x22, byte(len(dataRaw))}, dataRaw...))
+	tx["raw_data"].(map[string]any)["fee_limit"] = int64(params["fee_limit"].(float64))
+	return map[string]any{"result": map[string]any{"result": true}, "transaction": tx}
+}
+
+// build hashes raw, prefixed with a nonce so equal transfers get distinct IDs, into the txID
+func (s *Server) build(contractType string, value map[string]any, raw []byte) map[string]any {
+	s.nonce++
+	raw = append(appendVarint([]byte{0x08}, uint64(s.nonce)), raw...)
+	sum := sha256.Sum256(raw)
+	txID := hex.EncodeToString(sum[:])
+
+	tx := newTransaction(txID, contractType, value)
+	s.built[txID] = tx
+	return map[string]any{
+		"txID":         txID,
+		"raw_data":     tx["raw_data"],
+		"raw_data_hex": hex.EncodeToString(raw),
+		"visible":      true,
+	}
+}
+
+// broadcast accepts a transaction built by this server and signed by its owner, and mines it
+func (s *Server) broadcast(params map[string]any) any {
+	reject := func(code, message string) any {
+		return map[string]any{"result": false, "code": code, "message": hex.EncodeToString([]byte(message))}
+	}
+
+	txID, _ := params["txID"].(string)
+	tx, ok := s.built[txID]
+	if !ok {
+		return reject("OTHER_ERROR", "unknown transaction")
+	}
+	rawData := tx["raw_data"].(map[string]any)
+	contract := rawData["contract"].([]map[string]any)[0]
+	value := contract["parameter"].(map[string]any)["value"].(map[string]any)
+
+	signatures, _ := params["signature"].([]any)
+	if len(signatures) != 1 {
+		return reject("SIGERROR", "expected one signature")
+	}
+	signature, _ := hex.DecodeString(signatures[0].(string))
+	from, err := services.TronAddressFromHex(value["owner_address"].(string))
+	if err != nil || recoverSigner(signature, txID) != from {
+		return reject("SIGERROR", "signature does not match the owner")
+	}
+
+	broadcast := Broadcast{TxID: txID, Contract: contract["type"].(string), From: from}
+	if broadcast.Contract == "TransferContract" {
+		broadcast.To, _ = services.TronAddressFromHex(value["to_address"].(string))
+		broadcast.Amount = big.NewInt(value["amount"].(int64))
+	} else {
+		data := value["data"].(string)
+		broadcast.To, _ = services.TronAddressFromHex("41" + data[32:72])
+		broadcast.Amount, _ = new(big.Int).SetString(data[72:], 16)
+		broadcast.FeeLimit = rawData["fee_limit"].(int64)
+	}
+
+	delete(s.built, txID)
+	s.enqueue(tx)
+	broadcast.Block = s.mine(1)
+	s.broadcasts = append(s.broadcasts, broadcast)
+	return map[string]any{"result": true, "txid": txID}
+}
+
+// recoverSigner returns the address whose key made signature, r || s || v, over the hex txID
+func recoverSigner(signature []byte, txID string) string {
+	hash, err := hex.DecodeString(txID)
+	if err != nil || len(signature) != 65 {
+		return ""
+	}
+	compact := append([]byte{signature[64]}, signature[:64]...)
+	pub, _, err := ecdsa.RecoverCompact(compact, hash)
+	if err != nil {
+		return ""
+	}
+	keccak := sha3.NewLegacyKeccak256()
+	keccak.Write(pub.SerializeUncompressed()[1:])
+	address, _ := services.TronAddressFromHex("41" + hex.EncodeToString(keccak.Sum(nil)[12:]))
+	return address
+}
+
+// appendVarint appends v in protobuf varint encoding
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
@@ -1,10 +1,21 @@
 package storer
 
 import (
+	"errors"
 	"math/rand"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrTransferClaimed means a Tron transaction is already recorded on another payment
+	ErrTransferClaimed = errors.New("transfer already claimed by another payment")
+	// ErrNoFreeAmount means every amount offset for a Tron address is held by another payment
+	ErrNoFreeAmount = errors.New("no free payment amount for this address")
+	// ErrPromoLimitReached means a promo code has no uses left, in total or for the user
+	ErrPromoLimitReached = errors.New("promo code usage limit reached")
 )
 
 type GormStorer struct {
@@ -12,7 +23,11 @@ type GormStorer struct {
 }
 
 func NewGormStorer(db *gorm.DB) *GormStorer {
-	db.AutoMigrate(&Payment{}, &Photo{})
+	db.AutoMigrate(&Payment{}, &Photo{}, &User{}, &PromoCode{}, &PromoRedemption{}, &DeliveredPhoto{}, &TronTransfer{}, &UnmatchedTronTransfer{}, &ScanCursor{})
+	// Replaced by idx_payments_reserved_amount, which also holds amounts of orders past "pending"
+	if db.Migrator().HasIndex(&Payment{}, "idx_payments_pending_amount") {
+		db.Migrator().DropIndex(&Payment{}, "idx_payments_pending_amount")
+	}
 	return &GormStorer{db: db}
 }
 
@@ -57,39 +72,469 @@ func (s *GormStorer) UpdatePaymentStatus(id string, status string) error {
 	return s.db.Model(&Payment{}).Where("id = ?", id).Update("status", status).Error
 }
 
+// FindPayment returns a payment of any type by ID
+func (s *GormStorer) FindPayment(id string) (*Payment, error) {
+	var payment Payment
+	err := s.db.Where("id = ?", id).First(&payment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func (s *GormStorer) GetPaymentByInvoiceNumber(number int64) (*Payment, error) {
+	var payment Payment
+	err := s.db.Where("invoice_number = ?", number).First(&payment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// AssignInvoiceNumber gives a fulfilled payment the next invoice number, or returns the one it has.
+// Numbers are only taken inside the transaction that stores them, so the sequence has no gaps.
+func (s *GormStorer) AssignInvoiceNumber(paymentID string) (int64, error) {
+	var number int64
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var payment Payment
+		if err := tx.Where("id = ?", paymentID).First(&payment).Error; err != nil {
+			return err
+		}
+		if payment.InvoiceNumber != nil {
+			number = *payment.InvoiceNumber
+			return nil
+		}
+
+		if err := tx.Model(&Payment{}).Select("COALESCE(MAX(invoice_number), 0) + 1").Scan(&number).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Payment{}).Where("id = ?", paymentID).Update("invoice_number", number).Error
+	})
+	return number, err
+}
+
+// GetPaymentsByID returns the recorded payments among ids, keyed by ID
+func (s *GormStorer) GetPaymentsByID(ids []string) (map[string]Payment, error) {
+	var payments []Payment
+	err := s.db.Where("id IN ?", ids).Find(&payments).Error
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]Payment, len(payments))
+	for _, payment := range payments {
+		byID[payment.ID] = payment
+	}
+	return byID, nil
+}
+
+// MarkMismatchAlerted flags the amount mismatch of a payment as reported to admins. It returns
+// false when the payment was already flagged, so each mismatch is alerted once.
+func (s *GormStorer) MarkMismatchAlerted(id string) (bool, error) {
+	result := s.db.Model(&Payment{}).
+		Where("id = ? AND (mismatch_alerted = ? OR mismatch_alerted IS NULL)", id, false).
+		Update("mismatch_alerted", true)
+	return result.RowsAffected > 0, result.Error
+}
+
 func (s *GormStorer) GetFailedPayments() ([]Payment, error) {
 	return s.getPaymentsByStatus("failed", "stripe")
 }
 
+// GetPaymentStats sums gross, discount and net amounts per currency for successful payments of a type
+func (s *GormStorer) GetPaymentStats(paymentType string) ([]PaymentStats, error) {
+	var stats []PaymentStats
+	err := s.db.Model(&Payment{}).
+		Select("COALESCE(NULLIF(currency, ''), 'usd') AS currency, COUNT(*) AS count, COALESCE(SUM(gross_amount), 0) AS gross, COALESCE(SUM(discount_amount), 0) AS discount, COALESCE(SUM(tip_amount), 0) AS tips, COALESCE(SUM(amount), 0) AS net, COALESCE(SUM(amount_usd), 0) AS usd").
+		Where("type = ? AND status IN ?", paymentType, []string{"paid", "confirmed", "image_sent", "partially_sent"}).
+		Group("COALESCE(NULLIF(currency, ''), 'usd')").
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// ========== Payments - Telegram ==========
+
+func (s *GormStorer) SaveTelegramPayment(payment *Payment) error {
+	return s.savePaymentWithType(payment, "telegram")
+}
+
+func (s *GormStorer) GetTelegramPayment(chargeID string) (*Payment, error) {
+	return s.getPaymentByField("telegram_charge_id", chargeID, "telegram")
+}
+
+// ========== Payments - Telegram Stars ==========
+
+func (s *GormStorer) SaveStarsPayment(payment *Payment) error {
+	return s.savePaymentWithType(payment, "stars")
+}
+
+func (s *GormStorer) GetStarsPayment(chargeID string) (*Payment, error) {
+	return s.getPaymentByField("telegram_charge_id", chargeID, "stars")
+}
+
 // ========== Payments - Tron ==========
 
 func (s *GormStorer) SaveTronPayment(payment *Payment) error {
 	return s.savePaymentWithType(payment, "tron")
 }
 
-func (s *GormStorer) GetTronPayment(txID string) (*Payment, error) {
-	return s.getPaymentByField("tx_id", txID, "tron")
+// GetConfirmingTronPayments returns Tron payments whose transfer was seen but is not yet deep enough
+func (s *GormStorer) GetConfirmingTronPayments() ([]Payment, error) {
+	var payments []Payment
+	err := s.db.Where("type = ? AND status IN ?", "tron", []string{"seen", "confirming"}).Find(&payments).Error
+	if err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+// UpdateTronConfirmations stores the confirmation count of a seen or confirming payment and moves it
+// to status. It reports false if the payment was no longer seen or confirming, e.g. already confirmed.
+func (s *GormStorer) UpdateTronConfirmations(paymentID string, blockNumber, confirmations int64, status string) (bool, error) {
+	updates := map[string]interface{}{
+		"block_number":  blockNumber,
+		"confirmations": confirmations,
+		"status":        status,
+	}
+	if status == "confirmed" {
+		updates["confirmed_at"] = time.Now()
+	}
+
+	result := s.db.Model(&Payment{}).
+		Where("id = ? AND type = ? AND status IN ?", paymentID, "tron", []string{"seen", "confirming"}).
+		Updates(updates)
+	return result.RowsAffected > 0, result.Error
 }
 
-func (s *GormStorer) GetTronPaymentByAddress(address string) (*Payment, error) {
-	return s.getPaymentByField("address", address, "tron")
+// ReserveTronPayment saves a pending Tron payment whose amount no other payment to the same
+// address holds: payment.Amount plus the lowest free offset among step, 2*step ... slots*step.
+// An amount is held as long as a transfer of it can still be matched to its payment: while it is
+// open or in review, and otherwise until lateWindow after it expired (see GetWatchedTronPayments).
+func (s *GormStorer) ReserveTronPayment(payment *Payment, step, slots int64, lateWindow time.Duration) error {
+	base := payment.Amount
+	since := time.Now().Add(-lateWindow).Unix()
+	held := "(status IN ? OR status = ? OR (status IN ? AND expires_at > ?))"
+	heldArgs := []interface{}{"tron", payment.Address, tronOpenStatuses, "review", tronSettledStatuses, since}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// Amounts of payments no transfer can reach any more become free
+		err := tx.Model(&Payment{}).
+			Where("type = ? AND address = ? AND NOT "+held+" AND reserved_amount IS NOT NULL", heldArgs...).
+			Update("reserved_amount", nil).Error
+		if err != nil {
+			return err
+		}
+
+		var taken []int64
+		err = tx.Model(&Payment{}).
+			Where("type = ? AND address = ? AND "+held, heldArgs...).
+			Pluck("amount", &taken).Error
+		if err != nil {
+			return err
+		}
+
+		used := make(map[int64]bool, len(taken))
+		for _, amount := range taken {
+			used[amount] = true
+		}
+		for i := int64(1); i <= slots; i++ {
+			if used[base+i*step] {
+				continue
+			}
+			amount := base + i*step
+			payment.Amount = amount
+			payment.ReservedAmount = &amount
+			payment.Type = "tron"
+			payment.Status = "pending"
+			payment.CreatedAt = time.Now()
+			payment.UpdatedAt = time.Now()
+			return tx.Create(payment).Error
+		}
+		return ErrNoFreeAmount
+	})
+}
+
+// ReserveTronDepositPayment saves a pending Tron payment with the next unused derivation index
+// and the deposit address derive returns for it
+func (s *GormStorer) ReserveTronDepositPayment(payment *Payment, derive func(index int64) (string, error)) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var index int64
+		if err := tx.Model(&Payment{}).Select("COALESCE(MAX(derivation_index), -1) + 1").Scan(&index).Error; err != nil {
+			return err
+		}
+
+		address, err := derive(index)
+		if err != nil {
+			return err
+		}
+
+		payment.Address = address
+		payment.DerivationIndex = &index
+		payment.Type = "tron"
+		payment.Status = "pending"
+		payment.CreatedAt = time.Now()
+		payment.UpdatedAt = time.Now()
+		return tx.Create(payment).Error
+	})
 }
 
-func (s *GormStorer) GetTronPaymentsByUserID(userID string) ([]Payment, error) {
+// GetUnsweptTronDeposits returns Tron payments on derived deposit addresses not yet swept to cold storage:
+// confirmed and delivered ones, and rejected ones, whose funds are refunded from cold storage.
+// Payments in review stay on their deposit address until an admin decides on them
+func (s *GormStorer) GetUnsweptTronDeposits() ([]Payment, error) {
 	var payments []Payment
-	err := s.db.Where("user_id = ? AND type = ?", userID, "tron").Order("created_at DESC").Find(&payments).Error
+	err := s.db.Where("type = ? AND status IN ? AND derivation_index IS NOT NULL AND (sweep_tx_id IS NULL OR sweep_tx_id = '')", "tron",
+		[]string{"confirmed", "image_sent", "partially_sent", "failed", "rejected"}).
+		Order("derivation_index").
+		Find(&payments).Error
 	if err != nil {
 		return nil, err
 	}
 	return payments, nil
 }
 
+// MarkTronPaymentSwept records the transaction that moved a deposit to cold storage
+func (s *GormStorer) MarkTronPaymentSwept(paymentID, txID string) error {
+	return s.db.Model(&Payment{}).Where("id = ?", paymentID).Update("sweep_tx_id", txID).Error
+}
+
+func (s *GormStorer) GetTronPayment(txID string) (*Payment, error) {
+	return s.getPaymentByField("tx_id", txID, "tron")
+}
+
 func (s *GormStorer) UpdateTronPayment(payment *Payment) error {
 	return s.db.Save(payment).Error
 }
 
-func (s *GormStorer) GetPendingTronPayments() ([]Payment, error) {
-	return s.getPaymentsByStatus("pending", "tron")
+// Tron payments transfers are matched to: open ones always, settled ones until the late window after expiry
+var (
+	tronOpenStatuses    = []string{"pending", "underpaid"}
+	tronSettledStatuses = []string{"seen", "confirming", "confirmed", "image_sent", "partially_sent", "failed", "expired", "review"}
+)
+
+// GetWatchedTronPayments returns Tron payments that incoming transfers may still be credited to:
+// pending and underpaid ones, and paid, delivered, expired or in-review ones that expired after since
+func (s *GormStorer) GetWatchedTronPayments(since time.Time) ([]Payment, error) {
+	var payments []Payment
+	err := s.db.Where("type = ? AND (status IN ? OR (status IN ? AND expires_at > ?))", "tron",
+		tronOpenStatuses, tronSettledStatuses, since.Unix()).
+		Order("created_at").
+		Find(&payments).Error
+	if err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+// CreditTronTransfer records transfer as paid towards a Tron payment and adds it to the received total.
+// The transfer that brings the total up to the amount becomes the payment's TxID and block; anything
+// above the amount is kept as credit. decide gets the updated payment and returns its new status.
+// A transaction is credited at most once: it returns ErrTransferClaimed if it is already recorded.
+func (s *GormStorer) CreditTronTransfer(paymentID string, transfer *TronTransfer, decide func(payment *Payment) string) (*Payment, error) {
+	var payment Payment
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&TronTransfer{}).Where("tx_id = ?", transfer.TxID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			// Payments claimed before transfers were recorded separately only hold the TxID
+			if err := tx.Model(&Payment{}).Where("tx_id = ?", transfer.TxID).Count(&count).Error; err != nil {
+				return err
+			}
+		}
+		if count > 0 {
+			return ErrTransferClaimed
+		}
+
+		if err := tx.Where("id = ? AND type = ?", paymentID, "tron").First(&payment).Error; err != nil {
+			return err
+		}
+		if payment.Received == 0 && payment.TxID != "" {
+			payment.Received = payment.Amount // paid in full by a single transfer before this was tracked
+		}
+
+		payment.Received += transfer.Amount
+		if payment.FromAddress == "" {
+			payment.FromAddress = transfer.FromAddress
+		}
+		if payment.TxID == "" && payment.Received >= payment.Amount {
+			payment.TxID = transfer.TxID
+			payment.BlockNumber = transfer.BlockNumber
+		}
+		payment.Credit = max(payment.Received-payment.Amount, 0)
+		payment.Status = decide(&payment)
+
+		transfer.PaymentID = paymentID
+		transfer.CreatedAt = time.Now()
+		if err := tx.Create(transfer).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Payment{}).Where("id = ?", paymentID).Updates(map[string]interface{}{
+			"received":     payment.Received,
+			"credit":       payment.Credit,
+			"from_address": payment.FromAddress,
+			"tx_id":        payment.TxID,
+			"block_number": payment.BlockNumber,
+			"status":       payment.Status,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// ResolveTronReview settles a Tron payment in "review". Approving moves it to "seen" so it is
+// fulfilled once confirmed, taking the latest transfer as its TxID if it was never paid in full;
+// rejecting marks it "rejected" for a manual refund.
+func (s *GormStorer) ResolveTronReview(paymentID string, approve bool) (*Payment, error) {
+	var payment Payment
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND type = ? AND status = ?", paymentID, "tron", "review").First(&payment).Error; err != nil {
+			return err
+		}
+
+		payment.Status = "rejected"
+		if approve {
+			payment.Status = "seen"
+			if payment.TxID == "" {
+				var latest TronTransfer
+				if err := tx.Where("payment_id = ?", paymentID).Order("created_at DESC").First(&latest).Error; err != nil {
+					return errors.New("payment has no transfers")
+				}
+				payment.TxID = latest.TxID
+				payment.BlockNumber = latest.BlockNumber
+			}
+		}
+
+		return tx.Model(&Payment{}).Where("id = ?", paymentID).Updates(map[string]interface{}{
+			"status":       payment.Status,
+			"tx_id":        payment.TxID,
+			"block_number": payment.BlockNumber,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// ========== Scan cursors ==========
+
+// RecordUnmatchedTronTransfer keeps a transfer no payment took. It returns false when the transfer
+// was recorded before, so a transfer in rescanned blocks is reported once.
+func (s *GormStorer) RecordUnmatchedTronTransfer(transfer *UnmatchedTronTransfer) (bool, error) {
+	transfer.CreatedAt = time.Now()
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(transfer)
+	return result.RowsAffected > 0, result.Error
+}
+
+// GetScanCursor returns the last block the named scanner processed, 0 if it never ran
+func (s *GormStorer) GetScanCursor(name string) (int64, error) {
+	var cursor ScanCursor
+	err := s.db.Where("name = ?", name).Limit(1).Find(&cursor).Error
+	return cursor.Block, err
+}
+
+// SaveScanCursor records block as processed by the named scanner
+func (s *GormStorer) SaveScanCursor(name string, block int64) error {
+	return s.db.Save(&ScanCursor{Name: name, Block: block, UpdatedAt: time.Now()}).Error
+}
+
+// ========== Users ==========
+
+func (s *GormStorer) GetUser(id string) (*User, error) {
+	var user User
+	err := s.db.Where("id = ?", id).First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *GormStorer) GetUserByStripeCustomerID(customerID string) (*User, error) {
+	var user User
+	err := s.db.Where("stripe_customer_id = ?", customerID).First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *GormStorer) SaveUser(user *User) error {
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+	user.UpdatedAt = time.Now()
+	return s.db.Save(user).Error
+}
+
+// ========== Promo codes ==========
+
+func (s *GormStorer) SavePromoCode(promo *PromoCode) error {
+	if promo.CreatedAt.IsZero() {
+		promo.CreatedAt = time.Now()
+	}
+	return s.db.Save(promo).Error
+}
+
+func (s *GormStorer) GetPromoCode(code string) (*PromoCode, error) {
+	var promo PromoCode
+	err := s.db.Where("code = ?", code).First(&promo).Error
+	if err != nil {
+		return nil, err
+	}
+	return &promo, nil
+}
+
+func (s *GormStorer) CountPromoRedemptions(code, userID string) (int64, error) {
+	var count int64
+	err := s.db.Model(&PromoRedemption{}).Where("code = ? AND user_id = ?", code, userID).Count(&count).Error
+	return count, err
+}
+
+// RedeemPromoCode records a use of a bot-side promo code for a payment. The usage limits are
+// checked again here, since concurrent checkouts can all pass the check made before payment;
+// ErrPromoLimitReached means this use is one too many and nothing was recorded.
+func (s *GormStorer) RedeemPromoCode(code, userID, paymentID string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&PromoCode{}).
+			Where("code = ? AND (max_uses = 0 OR uses < max_uses)", code).
+			Update("uses", gorm.Expr("uses + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrPromoLimitReached
+		}
+
+		var promo PromoCode
+		if err := tx.Where("code = ?", code).First(&promo).Error; err != nil {
+			return err
+		}
+		if promo.MaxUsesPerUser > 0 {
+			var count int64
+			err := tx.Model(&PromoRedemption{}).Where("code = ? AND user_id = ?", code, userID).Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count >= promo.MaxUsesPerUser {
+				return ErrPromoLimitReached
+			}
+		}
+
+		redemption := &PromoRedemption{
+			Code:      code,
+			UserID:    userID,
+			PaymentID: paymentID,
+			CreatedAt: time.Now(),
+		}
+		return tx.Create(redemption).Error
+	})
 }
 
 // ========== Photos ==========
@@ -99,6 +544,12 @@ func (s *GormStorer) SavePhoto(photo *Photo) error {
 	return s.db.Create(photo).Error
 }
 
+func (s *GormStorer) CountPhotos() (int64, error) {
+	var count int64
+	err := s.db.Model(&Photo{}).Count(&count).Error
+	return count, err
+}
+
 func (s *GormStorer) GetRandomPhoto() (*Photo, error) {
 	var photos []Photo
 	err := s.db.Find(&photos).Error
@@ -112,3 +563,32 @@ func (s *GormStorer) GetRandomPhoto() (*Photo, error) {
 	randomIndex := rand.Intn(len(photos))
 	return &photos[randomIndex], nil
 }
+
+// GetRandomPhotos returns up to n distinct photos in random order
+func (s *GormStorer) GetRandomPhotos(n int) ([]Photo, error) {
+	var photos []Photo
+	err := s.db.Find(&photos).Error
+	if err != nil {
+		return nil, err
+	}
+
+	rand.Shuffle(len(photos), func(i, j int) {
+		photos[i], photos[j] = photos[j], photos[i]
+	})
+	if len(photos) > n {
+		photos = photos[:n]
+	}
+	return photos, nil
+}
+
+func (s *GormStorer) SaveDeliveredPhotos(paymentID string, photos []Photo) error {
+	delivered := make([]DeliveredPhoto, 0, len(photos))
+	for _, photo := range photos {
+		delivered = append(delivered, DeliveredPhoto{
+			PaymentID: paymentID,
+			PhotoID:   photo.ID,
+			CreatedAt: time.Now(),
+		})
+	}
+	return s.db.Create(&delivered).Error
+}
@@ -4,24 +4,117 @@ import "time"
 
 type Photo struct {
 	ID        int64     `gorm:"primaryKey" json:"id"`
-	FileID   string `json:"file_id"`
+	FileID    string    `json:"file_id"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
 type Payment struct {
-	ID            string    `gorm:"primaryKey" json:"id"`
-	UserID        string    `gorm:"index" json:"user_id"`
-	Type          string    `json:"type"` // "stripe" or "tron"
-	Amount        int64     `json:"amount"`
-	AmountUSD     float64   `json:"amount_usd,omitempty"` // для tron
-	Status        string    `json:"status"` // "pending", "paid", "confirmed", "image_sent", "failed"
-	Error         string    `json:"error,omitempty"`
-	Address       string    `json:"address,omitempty"` // для tron платежей
-	TxID          string    `gorm:"index" json:"tx_id,omitempty"` // для tron платежей
-	Confirmations int64     `json:"confirmations,omitempty"` // для tron
-	BlockNumber   int64     `json:"block_number,omitempty"` // для tron
-	ExpiresAt     int64     `json:"expires_at,omitempty"` // для tron
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
-	ConfirmedAt   time.Time `json:"confirmed_at,omitempty"`
+	ID               string    `gorm:"primaryKey" json:"id"`
+	UserID           string    `gorm:"index" json:"user_id"`
+	Type             string    `json:"type"` // "stripe", "telegram", "stars" or "tron"
+	Amount           int64     `json:"amount"`
+	Currency         string    `json:"currency,omitempty"`    // lowercase ISO code, empty for old USD payments
+	AmountUSD        float64   `json:"amount_usd,omitempty"`  // для tron
+	QuotedRate       float64   `json:"quoted_rate,omitempty"` // для tron, USD за единицу на момент заказа
+	Status           string    `json:"status"`                // "pending", "paid", "underpaid", "seen", "confirming", "confirmed", "image_sent", "partially_sent", "expired", "review", "rejected", "failed"
+	Error            string    `json:"error,omitempty"`
+	Address          string    `gorm:"uniqueIndex:idx_payments_reserved_amount" json:"address,omitempty"`               // для tron платежей
+	ReservedAmount   *int64    `gorm:"uniqueIndex:idx_payments_reserved_amount" json:"reserved_amount,omitempty"`       // для tron, amount held on Address while transfers can still match
+	TxID             string    `gorm:"uniqueIndex:idx_payments_tx_id_claimed,where:tx_id <> ''" json:"tx_id,omitempty"` // для tron платежей
+	FromAddress      string    `json:"from_address,omitempty"`                                                          // для tron, отправитель
+	Confirmations    int64     `json:"confirmations,omitempty"`                                                         // для tron
+	BlockNumber      int64     `json:"block_number,omitempty"`                                                          // для tron
+	DerivationIndex  *int64    `gorm:"uniqueIndex" json:"derivation_index,omitempty"`                                   // для tron, адрес m/44'/195'/0'/0/i
+	Received         int64     `json:"received,omitempty"`                                                              // для tron, сумма всех входящих переводов
+	Credit           int64     `json:"credit,omitempty"`                                                                // для tron, переплата сверх суммы заказа
+	SweepTxID        string    `json:"sweep_tx_id,omitempty"`                                                           // для tron, перевод в холодный кошелёк
+	ExpiresAt        int64     `json:"expires_at,omitempty"`                                                            // для tron
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	ConfirmedAt      time.Time `json:"confirmed_at,omitempty"`
+	GrossAmount      int64     `json:"gross_amount,omitempty"`    // price before any discount
+	DiscountAmount   int64     `json:"discount_amount,omitempty"` // bot-side and Stripe discounts combined
+	PromoCode        string    `json:"promo_code,omitempty"`
+	PhotoCount       int64     `json:"photo_count,omitempty"`                             // photos bought in this payment
+	TipAmount        int64     `json:"tip_amount,omitempty"`                              // paid above the base price with "Name your price"
+	TelegramChargeID string    `gorm:"index" json:"telegram_payment_charge_id,omitempty"` // для telegram и stars
+	InvoiceNumber    *int64    `gorm:"uniqueIndex" json:"invoice_number,omitempty"`       // gapless, assigned on fulfillment
+	ProviderChargeID string    `json:"provider_payment_charge_id,omitempty"`              // для telegram
+	MismatchAlerted  bool      `json:"mismatch_alerted,omitempty"`                        // для stripe, admins were told the amount differs
+}
+
+// TronTransfer is an incoming Tron transfer credited to a payment; a payment can be paid in several
+type TronTransfer struct {
+	TxID        string    `gorm:"primaryKey" json:"tx_id"`
+	PaymentID   string    `gorm:"index" json:"payment_id"`
+	FromAddress string    `json:"from_address"`
+	Amount      int64     `json:"amount"`
+	BlockNumber int64     `json:"block_number,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// UnmatchedTronTransfer is a transfer to a watched Tron address that no payment took, kept for the admins
+type UnmatchedTronTransfer struct {
+	TxID        string    `gorm:"primaryKey" json:"tx_id"`
+	ToAddress   string    `json:"to_address"`
+	FromAddress string    `json:"from_address"`
+	Amount      string    `json:"amount"` // in the smallest unit of the asset, may not fit an int64
+	BlockNumber int64     `json:"block_number,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ScanCursor is the last block a chain scanner has fully processed
+type ScanCursor struct {
+	Name      string    `gorm:"primaryKey" json:"name"` // e.g. "tron:mainnet:USDT"
+	Block     int64     `json:"block"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// DeliveredPhoto records a photo sent to the buyer for a payment
+type DeliveredPhoto struct {
+	ID        int64     `gorm:"primaryKey" json:"id"`
+	PaymentID string    `gorm:"index" json:"payment_id"`
+	PhotoID   int64     `json:"photo_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type User struct {
+	ID               string    `gorm:"primaryKey" json:"id"` // Telegram user ID
+	Username         string    `json:"username,omitempty"`
+	StripeCustomerID string    `gorm:"index" json:"stripe_customer_id,omitempty"`
+	PromoCode        string    `json:"promo_code,omitempty"`      // code entered with /promo, applied on next checkout
+	StripePromoID    string    `json:"stripe_promo_id,omitempty"` // set when PromoCode is a Stripe promotion code
+	Currency         string    `json:"currency,omitempty"`        // chosen with /currency, overrides language_code
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// PromoCode is a discount code managed by the bot itself (not by Stripe)
+type PromoCode struct {
+	Code           string    `gorm:"primaryKey" json:"code"`
+	PercentOff     int64     `json:"percent_off,omitempty"`
+	AmountOff      int64     `json:"amount_off,omitempty"`        // in cents
+	MaxUses        int64     `json:"max_uses,omitempty"`          // 0 = unlimited
+	MaxUsesPerUser int64     `json:"max_uses_per_user,omitempty"` // 0 = unlimited
+	Uses           int64     `json:"uses"`
+	ExpiresAt      int64     `json:"expires_at,omitempty"` // unix, 0 = never
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type PromoRedemption struct {
+	ID        int64     `gorm:"primaryKey" json:"id"`
+	Code      string    `gorm:"index" json:"code"`
+	UserID    string    `gorm:"index" json:"user_id"`
+	PaymentID string    `json:"payment_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type PaymentStats struct {
+	Currency string
+	Count    int64
+	Gross    int64
+	Discount int64
+	Tips     int64
+	Net      int64
+	USD      float64 // sum of amount_usd, for crypto payments
 }